@@ -0,0 +1,261 @@
+package chord
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*------------------------------------------------------------*/
+/*                  Ring Authentication Below                 */
+/*------------------------------------------------------------*/
+
+/*
+* A ring can be given an ID and a shared secret (Config.Ring, Config.Secret).
+* Every call then carries the ring ID and a token next to its parameters, as it
+* carries its trace, and a node rejects the calls that change its state,
+* joining included, unless they carry a valid token of its ring. Lookups and
+* reads stay open. The secret itself is never sent.
+*
+* The token is an HMAC keyed with the secret over the ring ID, the method, the
+* time of the call and a random nonce (see callMessage). A node takes it only
+* within maxClockSkew of its own clock and only once, so a token seen on the
+* network cannot be replayed for another method or later on. It does not cover
+* the parameters and nothing is encrypted: whoever can change the traffic can
+* change what an authorized call does, so a closed ring still needs a trusted
+* network (or a tunnel such as a VPN).
+*
+* A node proves the address of its process on every connection it dials (see
+* sayHello), so a node takes the backup RPCs only from the process of its
+* predecessor. The administrative RPCs need a token of a separate admin secret
+* (Config.AdminSecret).
+ */
+
+// Credentials are sent with every call, a node sends those of its ring and a client those of SetCredentials
+type Credentials struct {
+	Ring  string // ID of the ring
+	Time  int64  // Unix time of the call in seconds
+	Nonce string // Random hex string, a node takes every nonce once
+	Token string // Hex HMAC-SHA256 of the call keyed with the secret, empty for an open ring
+	Admin string // Hex HMAC-SHA256 of the call keyed with the admin secret, empty if none
+}
+
+// ErrUnauthorized is wrapped by the errors of rejected calls
+var ErrUnauthorized = errors.New("unauthorized")
+
+// How far the time of a call may be from the clock of the node it is sent to
+const maxClockSkew = time.Minute
+
+// ringSecrets are the ring ID and secrets a node checks calls with, or a client signs them with
+type ringSecrets struct {
+	ring   string
+	secret string
+	admin  string
+}
+
+// closed reports whether the calls changing a node need the credentials of its ring
+func (s ringSecrets) closed() bool {
+	return s.ring != "" || s.secret != ""
+}
+
+// callMessage is what the tokens of a call of method are computed over
+func callMessage(c *Credentials, method string) string {
+	return fmt.Sprintf("%s\n%s\n%d\n%s", c.Ring, method, c.Time, c.Nonce)
+}
+
+// token is the hex HMAC-SHA256 of message keyed with secret, empty without a secret
+func token(secret string, message string) string {
+	if secret == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sign a call of method with the secrets, nil for an open ring without admin secret
+func (s ringSecrets) sign(method string) *Credentials {
+	if s == (ringSecrets{}) {
+		return nil
+	}
+	c := &Credentials{Ring: s.ring, Time: time.Now().Unix(), Nonce: randomHex(16)}
+	message := callMessage(c, method)
+	c.Token = token(s.secret, message)
+	c.Admin = token(s.admin, message)
+	return c
+}
+
+// Secrets of the client calls of the process, see SetCredentials and SetAdminSecret
+var process = struct {
+	mutex   sync.RWMutex
+	secrets ringSecrets
+}{}
+
+/*
+* @description: Set the ring ID and secret the client calls of the process are
+*				signed with from now on, empty strings send no token. Nodes sign
+*				with the secret of their own Config.
+ */
+func SetCredentials(ring string, secret string) {
+	process.mutex.Lock()
+	process.secrets.ring, process.secrets.secret = ring, secret
+	process.mutex.Unlock()
+}
+
+/*
+* @description: Set the admin secret the client calls of the process are signed
+*				with from now on, needed by the administrative RPCs such as
+*				SetPredecessorRPC. An empty secret sends none.
+ */
+func SetAdminSecret(secret string) {
+	process.mutex.Lock()
+	process.secrets.admin = secret
+	process.mutex.Unlock()
+}
+
+/*
+* @description: Credentials sent with a call of method made with ctx: signed
+*				with the secret of the ring of the calling node, or with those
+*				of the process for a client
+* @return: 		the credentials, nil for a client without any
+ */
+func callCredentials(ctx context.Context, method string) *Credentials {
+	caller := callingNode(ctx)
+	if caller == nil {
+		process.mutex.RLock()
+		defer process.mutex.RUnlock()
+		return process.secrets.sign(method)
+	}
+	// A node never signs with its admin secret, the administrative RPCs are sent by clients
+	secrets := caller.secrets
+	secrets.admin = ""
+	return secrets.sign(method)
+}
+
+// nonceCache keeps the nonces a node took within maxClockSkew, shared by its virtual nodes
+type nonceCache struct {
+	mutex sync.Mutex
+	seen  map[string]int64 // Nonce -> time of its call
+	prune time.Time        // When the nonces out of the window are dropped next
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: make(map[string]int64)}
+}
+
+/*
+* @description: Take the nonce of a call made at the given Unix time
+* @return: 		an error if the time is too far from now or the nonce was taken before
+ */
+func (n *nonceCache) use(nonce string, at int64, now time.Time) error {
+	skew := now.Sub(time.Unix(at, 0))
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		return fmt.Errorf("call made at %s, %s away from the clock of the node", time.Unix(at, 0).UTC().Format(time.RFC3339), skew.Round(time.Second))
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if _, ok := n.seen[nonce]; ok {
+		return errors.New("token already used")
+	}
+	// Nonces out of the window cannot be replayed anyway, drop them once per window
+	if now.After(n.prune) {
+		for old, t := range n.seen {
+			if now.Sub(time.Unix(t, 0)) > maxClockSkew {
+				delete(n.seen, old)
+			}
+		}
+		n.prune = now.Add(maxClockSkew)
+	}
+	n.seen[nonce] = at
+	return nil
+}
+
+// RPCs changing the state of a node, they need the credentials of its ring
+var mutatingRPCs = map[string]bool{
+	"HelloRPC":                 true,
+	"NotifyRPC":                true,
+	"SetPredecessorRPC":        true,
+	"StoreFileRPC":             true,
+	"PutFileRPC":               true,
+	"DeleteFileRPC":            true,
+	"DeleteBackupFileRPC":      true,
+	"SuccessorStoreFileRPC":    true,
+	"DeleteSuccessorBackupRPC": true,
+}
+
+// RPCs a node only takes from its predecessor, whose bucket its backup mirrors
+var predecessorRPCs = map[string]bool{
+	"SuccessorStoreFileRPC":    true,
+	"DeleteBackupFileRPC":      true,
+	"DeleteSuccessorBackupRPC": true,
+}
+
+// RPCs repairing a ring by hand, they need the admin token and are disabled on a node without admin secret
+var adminRPCs = map[string]bool{
+	"SetPredecessorRPC": true,
+}
+
+/*
+* @description: Check the credentials sent with a call to node
+* @param: 		caller: the process ("IP:Port") that proved its address on the
+*				connection of the call, empty if none did
+* @return: 		an error wrapping ErrUnauthorized if the call changes the state
+*				of node and does not carry a valid token of its ring, was made
+*				too long ago or already taken, or is not sent by the process
+*				allowed to make it
+ */
+func (node *Node) authorize(method string, presented *Credentials, caller NodeAddress) error {
+	name := methodLabel(method)
+	if !mutatingRPCs[name] && !adminRPCs[name] {
+		return nil
+	}
+	if presented == nil {
+		presented = &Credentials{}
+	}
+	message := callMessage(presented, name)
+	if node.secrets.closed() {
+		switch {
+		case presented.Ring == "" && presented.Token == "":
+			return fmt.Errorf("%w: %s needs the credentials of ring %q", ErrUnauthorized, name, node.secrets.ring)
+		case presented.Ring != node.secrets.ring:
+			return fmt.Errorf("%w: credentials of ring %q, %s is in ring %q", ErrUnauthorized, presented.Ring, node.Address, node.secrets.ring)
+		case !hmac.Equal([]byte(presented.Token), []byte(token(node.secrets.secret, message))):
+			return fmt.Errorf("%w: invalid token for %s in ring %q", ErrUnauthorized, name, node.secrets.ring)
+		}
+	}
+	if adminRPCs[name] {
+		switch {
+		case node.secrets.admin == "":
+			return fmt.Errorf("%w: %s is disabled, %s has no admin secret", ErrUnauthorized, name, node.Address)
+		case !hmac.Equal([]byte(presented.Admin), []byte(token(node.secrets.admin, message))):
+			return fmt.Errorf("%w: %s needs the admin token", ErrUnauthorized, name)
+		}
+	}
+	// A valid token is taken once, within maxClockSkew
+	if node.secrets.secret != "" || adminRPCs[name] {
+		err := node.nonces.use(presented.Nonce, presented.Time, time.Now())
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUnauthorized, err)
+		}
+	}
+	// A node may always call itself, e.g. the only node of a ring is its own predecessor
+	self, _ := splitAddress(node.Address)
+	predecessor := node.getPredecessor()
+	if predecessorRPCs[name] && string(caller) != self {
+		process, _ := splitAddress(predecessor)
+		switch {
+		case predecessor == "":
+			return fmt.Errorf("%w: %s is only taken from the predecessor, %s has none", ErrUnauthorized, name, node.Address)
+		case caller == "":
+			return fmt.Errorf("%w: %s is only taken from the predecessor %s, the caller did not prove its address", ErrUnauthorized, name, predecessor)
+		case string(caller) != process:
+			return fmt.Errorf("%w: %s is only taken from the predecessor %s, not from %s", ErrUnauthorized, name, predecessor, caller)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package chord
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newAuthNode is a node that only checks calls, it is not started
+func newAuthNode(secrets ringSecrets) *Node {
+	return &Node{Address: "10.0.0.1:4000", secrets: secrets, nonces: newNonceCache()}
+}
+
+// signedAt signs a call of method with secrets as if it was made at the given time
+func signedAt(secrets ringSecrets, method string, at time.Time) *Credentials {
+	c := &Credentials{Ring: secrets.ring, Time: at.Unix(), Nonce: randomHex(16)}
+	c.Token = token(secrets.secret, callMessage(c, method))
+	c.Admin = token(secrets.admin, callMessage(c, method))
+	return c
+}
+
+func TestAuthorizeOpenRing(t *testing.T) {
+	node := newAuthNode(ringSecrets{})
+	for _, method := range []string{"Node.StoreFileRPC", "Node.NotifyRPC", "Node.GetFileRPC"} {
+		if err := node.authorize(method, nil, ""); err != nil {
+			t.Errorf("%s without credentials: %v", method, err)
+		}
+	}
+	// Credentials of some ring do not matter to an open one
+	if err := node.authorize("Node.StoreFileRPC", (ringSecrets{ring: "other", secret: "x"}).sign("StoreFileRPC"), ""); err != nil {
+		t.Errorf("StoreFileRPC with foreign credentials: %v", err)
+	}
+}
+
+func TestAuthorizeClosedRing(t *testing.T) {
+	secrets := ringSecrets{ring: "ring", secret: "secret"}
+	node := newAuthNode(secrets)
+	now := time.Now()
+
+	if err := node.authorize("Node.GetFileRPC", nil, ""); err != nil {
+		t.Fatalf("a read without credentials: %v", err)
+	}
+	if err := node.authorize("Node.StoreFileRPC", secrets.sign("StoreFileRPC"), ""); err != nil {
+		t.Fatalf("a signed write: %v", err)
+	}
+	// The token of a virtual node is that of its method
+	if err := node.authorize("Node/2.StoreFileRPC", secrets.sign("StoreFileRPC"), ""); err != nil {
+		t.Fatalf("a signed write to a virtual node: %v", err)
+	}
+
+	replayed := secrets.sign("StoreFileRPC")
+	node.authorize("Node.StoreFileRPC", replayed, "")
+	tampered := secrets.sign("StoreFileRPC")
+	tampered.Time++
+	refused := map[string]*Credentials{
+		"no credentials":     nil,
+		"another ring":       (ringSecrets{ring: "other", secret: "secret"}).sign("StoreFileRPC"),
+		"another secret":     (ringSecrets{ring: "ring", secret: "guess"}).sign("StoreFileRPC"),
+		"another method":     secrets.sign("NotifyRPC"),
+		"changed time":       tampered,
+		"replayed":           replayed,
+		"too old":            signedAt(secrets, "StoreFileRPC", now.Add(-2*maxClockSkew)),
+		"from the future":    signedAt(secrets, "StoreFileRPC", now.Add(2*maxClockSkew)),
+		"ring without token": {Ring: "ring", Time: now.Unix(), Nonce: randomHex(16)},
+	}
+	for name, presented := range refused {
+		if err := node.authorize("Node.StoreFileRPC", presented, ""); !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("%s: %v, want ErrUnauthorized", name, err)
+		}
+	}
+	// Within the clock skew a token is taken
+	if err := node.authorize("Node.StoreFileRPC", signedAt(secrets, "StoreFileRPC", now.Add(-maxClockSkew/2)), ""); err != nil {
+		t.Errorf("a token within the clock skew: %v", err)
+	}
+}
+
+func TestAuthorizeAdmin(t *testing.T) {
+	admin := ringSecrets{admin: "admin"}
+	if err := newAuthNode(ringSecrets{}).authorize("Node.SetPredecessorRPC", admin.sign("SetPredecessorRPC"), ""); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("SetPredecessorRPC on a node without admin secret: %v", err)
+	}
+	node := newAuthNode(admin)
+	if err := node.authorize("Node.SetPredecessorRPC", nil, ""); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("SetPredecessorRPC without the admin token: %v", err)
+	}
+	if err := node.authorize("Node.SetPredecessorRPC", (ringSecrets{admin: "guess"}).sign("SetPredecessorRPC"), ""); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("SetPredecessorRPC with another admin secret: %v", err)
+	}
+	presented := admin.sign("SetPredecessorRPC")
+	if err := node.authorize("Node.SetPredecessorRPC", presented, ""); err != nil {
+		t.Fatalf("SetPredecessorRPC with the admin token: %v", err)
+	}
+	if err := node.authorize("Node.SetPredecessorRPC", presented, ""); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("replayed admin token: %v", err)
+	}
+
+	// The ring token is needed on top of the admin one
+	closed := newAuthNode(ringSecrets{ring: "ring", secret: "secret", admin: "admin"})
+	if err := closed.authorize("Node.SetPredecessorRPC", admin.sign("SetPredecessorRPC"), ""); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("SetPredecessorRPC without the ring token: %v", err)
+	}
+	if err := closed.authorize("Node.SetPredecessorRPC", closed.secrets.sign("SetPredecessorRPC"), ""); err != nil {
+		t.Fatalf("SetPredecessorRPC with both tokens: %v", err)
+	}
+}
+
+func TestAuthorizePredecessorRPCs(t *testing.T) {
+	node := newAuthNode(ringSecrets{})
+	if err := node.authorize("Node.SuccessorStoreFileRPC", nil, "10.0.0.2:4000"); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("backup on a node without predecessor: %v", err)
+	}
+	node.Predecessor = "10.0.0.2:4000/3"
+	cases := []struct {
+		caller NodeAddress
+		ok     bool
+	}{
+		{"", false},              // Did not prove its address
+		{"10.0.0.3:4000", false}, // Another process
+		{"10.0.0.2:4001", false},
+		{"10.0.0.2:4000", true}, // Any node of the process of the predecessor
+		{"10.0.0.1:4000", true}, // Itself
+	}
+	for _, method := range []string{"Node.SuccessorStoreFileRPC", "Node.DeleteBackupFileRPC", "Node.DeleteSuccessorBackupRPC"} {
+		for _, c := range cases {
+			err := node.authorize(method, nil, c.caller)
+			if c.ok && err != nil || !c.ok && !errors.Is(err, ErrUnauthorized) {
+				t.Errorf("%s from %q: %v", method, c.caller, err)
+			}
+		}
+	}
+	// Other writes are taken from anyone
+	if err := node.authorize("Node.StoreFileRPC", nil, ""); err != nil {
+		t.Fatalf("StoreFileRPC from an unproven caller: %v", err)
+	}
+}
+
+func TestCallCredentials(t *testing.T) {
+	defer SetCredentials("", "")
+	defer SetAdminSecret("")
+	ctx := context.Background()
+	if c := callCredentials(ctx, "StoreFileRPC"); c != nil {
+		t.Fatalf("a client without credentials sends %+v", c)
+	}
+	SetCredentials("ring", "secret")
+	SetAdminSecret("admin")
+	c := callCredentials(ctx, "StoreFileRPC")
+	if c == nil || c.Ring != "ring" || c.Token == "" || c.Admin == "" {
+		t.Fatalf("client credentials %+v", c)
+	}
+
+	// A node signs with the secrets of its ring, never with an admin secret
+	node := newAuthNode(ringSecrets{ring: "own", secret: "own secret", admin: "admin"})
+	c = callCredentials(contextWithNode(ctx, node), "StoreFileRPC")
+	if c == nil || c.Ring != "own" || c.Admin != "" {
+		t.Fatalf("node credentials %+v", c)
+	}
+	if err := newAuthNode(node.secrets).authorize("Node.StoreFileRPC", c, ""); err != nil {
+		t.Fatalf("a member's call: %v", err)
+	}
+}
+
+func TestProvenCallerTakesBackups(t *testing.T) {
+	cfg := Config{Ring: "ring", Secret: "secret", Stabilize: time.Minute, CheckPredecessor: time.Minute}
+	a := startTestNode(t, cfg)
+	b := startTestNode(t, cfg)
+	b.Predecessor = a.Address
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	f := FileRPC{Id: Identifier("a.txt"), Name: "a.txt", Content: []byte("a")}
+	f.Checksum = contentChecksum(f.Content)
+
+	// a says hello on the connections it dials and proves its address
+	var reply SuccessorStoreFileRPCReply
+	if err := ChordCallContext(contextWithNode(ctx, a), b.Address, "Node.SuccessorStoreFileRPC", f, &reply); err != nil {
+		t.Fatalf("backup from the predecessor: %v", err)
+	}
+	// A valid token of the ring does not make an anonymous connection the predecessor
+	client := dialTestNode(t, b)
+	request := callParams{Auth: a.secrets.sign("SuccessorStoreFileRPC"), Params: f}
+	if err := remoteError(client.Call("Node.SuccessorStoreFileRPC", request, &reply)); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("backup on an anonymous connection: %v, want ErrUnauthorized", err)
+	}
+}
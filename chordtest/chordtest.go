@@ -0,0 +1,411 @@
+// Package chordtest runs a Chord ring inside one process, for tests of the
+// ring protocol and of code built on top of it.
+package chordtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlexwellChen/chord"
+)
+
+/*------------------------------------------------------------*/
+/*                    In-process Ring Below                   */
+/*------------------------------------------------------------*/
+
+// Options configure the nodes of a Ring, zero values select the defaults
+type Options struct {
+	Stabilize  time.Duration // Time between invocations of stabilize, default 50ms
+	FixFingers time.Duration // Time between invocations of fix_fingers, default 20ms
+	CheckPred  time.Duration // Time between invocations of check_predecessor, default 50ms
+	Successors int           // Number of successors to maintain, default 3
+	VNodes     int           // Ring positions per node, default 1
+	Storage    string        // "file" (default, objects survive Restart) or "memory"
+	StoreMode  string        // Default chord.StoreModeName
+	Logger     chord.Logger  // Logger of the nodes, default the one set with chord.SetLogger
+	Wire       string        // Wire format of the ring, default chord.WireJSON
+	Ring       string        // ID of the ring, see chord.Config.Ring
+	Secret     string        // Shared secret of the ring, see chord.Config.Secret
+}
+
+func (o *Options) setDefaults() {
+	if o.Stabilize == 0 {
+		o.Stabilize = 50 * time.Millisecond
+	}
+	if o.FixFingers == 0 {
+		o.FixFingers = 20 * time.Millisecond
+	}
+	if o.CheckPred == 0 {
+		o.CheckPred = 50 * time.Millisecond
+	}
+	if o.Successors == 0 {
+		o.Successors = 3
+	}
+	if o.VNodes == 0 {
+		o.VNodes = 1
+	}
+	if o.Storage == "" {
+		o.Storage = "file"
+	}
+	if o.StoreMode == "" {
+		o.StoreMode = chord.StoreModeName
+	}
+}
+
+// member is one node of the ring, it keeps its name, port and folder across restarts
+type member struct {
+	name     string
+	port     int
+	node     *chord.Node // nil while killed
+	listener *listener
+}
+
+/*
+* @description: Ring is a set of nodes running in this process, each with its
+*				own RPC server on an ephemeral port of 127.0.0.1 and its own
+*				folder in a temporary directory. Nodes are numbered in the order
+*				they were started; a killed node keeps its number, name, port and
+*				folder so it can be restarted.
+ */
+type Ring struct {
+	Dir string // Temporary folder holding the node folders, removed by Close
+
+	opts    Options
+	mutex   sync.Mutex
+	members []*member
+	used    map[string]bool // Identifiers taken by the nodes and their virtual nodes
+}
+
+/*
+* @description: Start a ring of n nodes. The first node creates the ring and the
+*				others join it one after the other; use WaitConverged before
+*				relying on the finger tables and successor lists.
+ */
+func NewRing(n int, opts Options) (*Ring, error) {
+	opts.setDefaults()
+	dir, err := os.MkdirTemp("", "chordtest")
+	if err != nil {
+		return nil, err
+	}
+	r := &Ring{Dir: dir, opts: opts, used: make(map[string]bool)}
+	for i := 0; i < n; i++ {
+		_, err := r.Add()
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Len returns the number of nodes ever started, killed ones included
+func (r *Ring) Len() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.members)
+}
+
+// Node returns the i-th node, nil while it is killed
+func (r *Ring) Node(i int) *chord.Node {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.members[i].node
+}
+
+// Address returns the address of the i-th node, which does not change on restart
+func (r *Ring) Address(i int) chord.NodeAddress {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return address(r.members[i].port)
+}
+
+// Alive returns the indexes of the running nodes
+func (r *Ring) Alive() []int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	alive := []int{}
+	for i, m := range r.members {
+		if m.node != nil {
+			alive = append(alive, i)
+		}
+	}
+	return alive
+}
+
+/*
+* @description: Start a new node and join it to the ring through the first
+*				running node, or create the ring if none is running
+* @return: 		the index of the node
+ */
+func (r *Ring) Add() (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	name, err := r.freeName(len(r.members))
+	if err != nil {
+		return 0, err
+	}
+	m := &member{name: name}
+	err = r.start(m)
+	if err != nil {
+		return 0, err
+	}
+	r.members = append(r.members, m)
+	return len(r.members) - 1, nil
+}
+
+/*
+* @description: Crash the i-th node: its listener and every connection it
+*				accepted are closed without notice and its periodic tasks stop.
+*				Its folder is kept for Restart.
+ */
+func (r *Ring) Kill(i int) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	m := r.members[i]
+	if m.node == nil {
+		return fmt.Errorf("node %d is not running", i)
+	}
+	m.listener.closeAll()
+	m.node.Quit()
+	m.node = nil
+	m.listener = nil
+	return nil
+}
+
+// Restart starts the killed i-th node again on the same port and folder and rejoins the ring
+func (r *Ring) Restart(i int) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	m := r.members[i]
+	if m.node != nil {
+		return fmt.Errorf("node %d is running", i)
+	}
+	return r.start(m)
+}
+
+// Close kills every running node and removes Dir
+func (r *Ring) Close() error {
+	for _, i := range r.Alive() {
+		r.Kill(i)
+	}
+	return os.RemoveAll(r.Dir)
+}
+
+/*
+* @description: Wait until a crawl of the ring finds every running node and its
+*				virtual nodes, in a complete ring without problems (see
+*				chord.CrawlRing): successors, predecessors and fingers agree.
+* @return: 		the report of the converged ring, or the last report and an
+*				error describing what was still wrong when ctx was done
+ */
+func (r *Ring) WaitConverged(ctx context.Context) (*chord.RingReport, error) {
+	ticker := time.NewTicker(r.opts.Stabilize)
+	defer ticker.Stop()
+	for {
+		report, problem := r.check(ctx)
+		if problem == "" {
+			return report, nil
+		}
+		select {
+		case <-ctx.Done():
+			return report, fmt.Errorf("ring did not converge: %s", problem)
+		case <-ticker.C:
+		}
+	}
+}
+
+// Client returns a client calling the ring as its first running node, with the credentials of the ring
+func (r *Ring) Client(ctx context.Context) (*chord.Client, error) {
+	for _, i := range r.Alive() {
+		if node := r.Node(i); node != nil {
+			return chord.NewClient(node), nil
+		}
+	}
+	return nil, errors.New("no node is running")
+}
+
+/*------------------------------------------------------------*/
+/*                         Helpers                            */
+/*------------------------------------------------------------*/
+
+func address(port int) chord.NodeAddress {
+	return chord.NodeAddress(fmt.Sprintf("127.0.0.1:%d", port))
+}
+
+/*
+* @description: Pick the name of the i-th node so that neither it nor its
+*				virtual nodes share an identifier with another node; the 2^m
+*				identifier space is small enough for names like n0, n1 to collide.
+ */
+func (r *Ring) freeName(i int) (string, error) {
+	for attempt := 0; attempt < 1000; attempt++ {
+		name := fmt.Sprintf("n%d", i)
+		if attempt > 0 {
+			name = fmt.Sprintf("n%d.%d", i, attempt)
+		}
+		ids := []string{chord.Identifier(name).String()}
+		for v := 1; v < r.opts.VNodes; v++ {
+			ids = append(ids, chord.Identifier(fmt.Sprintf("%s#%d", name, v)).String())
+		}
+		free := true
+		for j, id := range ids {
+			for _, other := range ids[:j] {
+				free = free && id != other
+			}
+			free = free && !r.used[id]
+		}
+		if free {
+			for _, id := range ids {
+				r.used[id] = true
+			}
+			return name, nil
+		}
+	}
+	return "", errors.New("no free identifier left for a new node")
+}
+
+// start runs m on its port (a new ephemeral one the first time) and joins it to the ring
+func (r *Ring) start(m *member) error {
+	inner, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", m.port))
+	if err != nil {
+		return err
+	}
+	m.port = inner.Addr().(*net.TCPAddr).Port
+	l := &listener{Listener: inner, conns: make(map[net.Conn]struct{})}
+
+	cfg := chord.Config{
+		Address:          "127.0.0.1",
+		Port:             m.port,
+		Listener:         l,
+		Name:             m.name,
+		Stabilize:        r.opts.Stabilize,
+		FixFingers:       r.opts.FixFingers,
+		CheckPredecessor: r.opts.CheckPred,
+		Successors:       r.opts.Successors,
+		VNodes:           r.opts.VNodes,
+		Storage:          r.opts.Storage,
+		DataDir:          r.Dir,
+		StoreMode:        r.opts.StoreMode,
+		Logger:           r.opts.Logger,
+		Wire:             r.opts.Wire,
+		Ring:             r.opts.Ring,
+		Secret:           r.opts.Secret,
+	}
+	for _, other := range r.members {
+		if other.node != nil && other != m {
+			cfg.Join = address(other.port)
+			break
+		}
+	}
+	node, err := chord.Start(context.Background(), cfg)
+	if err != nil {
+		return fmt.Errorf("start %s: %w", m.name, err)
+	}
+	m.node = node
+	m.listener = l
+	return nil
+}
+
+// check crawls the ring once, the problem is "" if it has converged
+func (r *Ring) check(ctx context.Context) (*chord.RingReport, string) {
+	expected := make(map[chord.NodeAddress]bool)
+	var start chord.NodeAddress
+	r.mutex.Lock()
+	for _, m := range r.members {
+		if m.node == nil {
+			continue
+		}
+		for _, local := range m.node.LocalNodes() {
+			expected[local.Address] = true
+		}
+		if start == "" {
+			start = m.node.Address
+		}
+	}
+	r.mutex.Unlock()
+	if start == "" {
+		return nil, "no node is running"
+	}
+
+	report, err := chord.CrawlRing(ctx, start)
+	if err != nil {
+		return nil, err.Error()
+	}
+	if !report.Complete {
+		return report, fmt.Sprintf("the walk from %s did not come back", start)
+	}
+	if len(report.Problems) > 0 {
+		problems := []string{}
+		for _, p := range report.Problems {
+			problems = append(problems, fmt.Sprintf("%s at %s: %s", p.Kind, p.Node, p.Detail))
+		}
+		return report, strings.Join(problems, "; ")
+	}
+	for _, member := range report.Members {
+		if !expected[member.Address] {
+			return report, fmt.Sprintf("%s is not running but still on the ring", member.Address)
+		}
+		delete(expected, member.Address)
+	}
+	for addr := range expected {
+		return report, fmt.Sprintf("%s is running but not on the ring", addr)
+	}
+	return report, ""
+}
+
+/*------------------------------------------------------------*/
+/*                    Crashable Listener                      */
+/*------------------------------------------------------------*/
+
+// listener keeps the connections it accepted, so that Kill can cut them as a crash would
+type listener struct {
+	net.Listener
+	mutex  sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		c.Close()
+		return nil, net.ErrClosed
+	}
+	l.conns[c] = struct{}{}
+	return &conn{Conn: c, listener: l}, nil
+}
+
+// closeAll closes the listener and every connection still open
+func (l *listener) closeAll() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.closed = true
+	l.Listener.Close()
+	for c := range l.conns {
+		c.Close()
+	}
+	l.conns = nil
+}
+
+type conn struct {
+	net.Conn
+	listener *listener
+}
+
+func (c *conn) Close() error {
+	c.listener.mutex.Lock()
+	delete(c.listener.conns, c.Conn)
+	c.listener.mutex.Unlock()
+	return c.Conn.Close()
+}
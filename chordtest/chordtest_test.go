@@ -0,0 +1,173 @@
+package chordtest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AlexwellChen/chord"
+)
+
+func init() {
+	// Failed calls to killed nodes are expected, keep the test output readable
+	chord.SetLogger(chord.NopLogger())
+}
+
+// newRing starts a ring of n nodes and waits until it converged
+func newRing(t *testing.T, n int, opts Options) *Ring {
+	t.Helper()
+	r, err := NewRing(n, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	waitConverged(t, r)
+	return r
+}
+
+func waitConverged(t *testing.T, r *Ring) *chord.RingReport {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	report, err := r.WaitConverged(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestRingConverges(t *testing.T) {
+	r := newRing(t, 5, Options{})
+	report := waitConverged(t, r)
+	if len(report.Members) != 5 {
+		t.Fatalf("%d members on the ring, want 5", len(report.Members))
+	}
+	for i := 1; i < len(report.Members); i++ {
+		if report.Members[i].Predecessor != report.Members[i-1].Address {
+			t.Errorf("predecessor of %s is %s, want %s", report.Members[i].Address, report.Members[i].Predecessor, report.Members[i-1].Address)
+		}
+	}
+}
+
+func TestPutGetAcrossTheRing(t *testing.T) {
+	r := newRing(t, 4, Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	client, err := r.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		err := client.Put(ctx, key, []byte("value of "+key))
+		if err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+	// Every node finds every key
+	for _, i := range r.Alive() {
+		client := chord.NewClient(r.Node(i))
+		for j := 0; j < 8; j++ {
+			key := fmt.Sprintf("key-%d", j)
+			value, err := client.Get(ctx, key)
+			if err != nil || string(value) != "value of "+key {
+				t.Fatalf("Get %s from node %d = %q, %v", key, i, value, err)
+			}
+		}
+	}
+	if err := client.Delete(ctx, "key-0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(ctx, "key-0"); err == nil {
+		t.Fatal("Get of a deleted key succeeded")
+	}
+}
+
+func TestKillAndRestart(t *testing.T) {
+	r := newRing(t, 4, Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	client, err := r.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if err := client.Put(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+	// Let the successors take their backups
+	time.Sleep(4 * r.opts.Stabilize)
+
+	victim := r.Alive()[len(r.Alive())-1]
+	if err := r.Kill(victim); err != nil {
+		t.Fatal(err)
+	}
+	report := waitConverged(t, r)
+	if len(report.Members) != 3 {
+		t.Fatalf("%d members after a crash, want 3", len(report.Members))
+	}
+	// The keys of the crashed node are served from the backup of its successor
+	// once it took them over, which may be after the pointers converged
+	client, err = r.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		for {
+			value, err := client.Get(ctx, key)
+			if err == nil && string(value) == key {
+				break
+			}
+			if ctx.Err() != nil {
+				t.Fatalf("Get %s after a crash = %q, %v", key, value, err)
+			}
+			time.Sleep(r.opts.Stabilize)
+		}
+	}
+
+	if err := r.Restart(victim); err != nil {
+		t.Fatal(err)
+	}
+	report = waitConverged(t, r)
+	if len(report.Members) != 4 {
+		t.Fatalf("%d members after the restart, want 4", len(report.Members))
+	}
+	if r.Node(victim).Address != r.Address(victim) {
+		t.Fatalf("restarted node at %s, want %s", r.Node(victim).Address, r.Address(victim))
+	}
+	client = chord.NewClient(r.Node(victim))
+	for i := 0; i < 8; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if value, err := client.Get(ctx, key); err != nil || string(value) != key {
+			t.Fatalf("Get %s after the restart = %q, %v", key, value, err)
+		}
+	}
+}
+
+func TestRingWithSecret(t *testing.T) {
+	r := newRing(t, 3, Options{Ring: "test", Secret: "secret", Wire: chord.WireGob})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	client, err := r.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Put(ctx, "key", []byte("value")); err != nil {
+		t.Fatalf("Put as a member: %v", err)
+	}
+	// A client without the secret reads but does not write
+	stranger, err := chord.DialContext(ctx, r.Address(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := stranger.Get(ctx, "key"); err != nil || string(value) != "value" {
+		t.Fatalf("Get without the secret = %q, %v", value, err)
+	}
+	if err := stranger.Put(ctx, "other", []byte("value")); err == nil {
+		t.Fatal("Put without the secret succeeded")
+	}
+}
@@ -0,0 +1,182 @@
+package chord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+/*------------------------------------------------------------*/
+/*                  Key-Value Client Below                    */
+/*------------------------------------------------------------*/
+
+/*
+* @description: Client uses the ring as a distributed key-value store. Values
+*				are sent as they are, nothing is read from or written to the
+*				node's upload and download folders and nothing is encrypted.
+*				Keys follow the object name rules of ValidateName.
+ */
+type Client struct {
+	entry     NodeAddress // Node used to enter the ring for lookups
+	storeMode string      // Store mode of the ring, decides the key of StoreFile
+	node      *Node       // Local node the calls are made as, nil for a client of Dial
+}
+
+// NewClient returns a client entering the ring through node, its calls are made as node
+func NewClient(node *Node) *Client {
+	return &Client{entry: node.Address, storeMode: node.StoreMode, node: node}
+}
+
+// context marks the calls of ctx as calls of the node of the client, if it has one
+func (c *Client) context(ctx context.Context) context.Context {
+	if c.node == nil {
+		return ctx
+	}
+	return contextWithNode(ctx, c.node)
+}
+
+/*
+* @description: Connect to a ring without joining it. Lookups and object
+*				operations are routed through bootstrap, which can be any member.
+* @return: 		error if bootstrap cannot be reached
+ */
+func Dial(bootstrap NodeAddress) (*Client, error) {
+	return DialContext(context.Background(), bootstrap)
+}
+
+// Dial that gives up when ctx is done
+func DialContext(ctx context.Context, bootstrap NodeAddress) (*Client, error) {
+	var reply GetStoreModeRPCReply
+	err := ChordCallContext(ctx, bootstrap, "Node.GetStoreModeRPC", "", &reply)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach %s: %w", bootstrap, err)
+	}
+	return &Client{entry: bootstrap, storeMode: reply.StoreMode}, nil
+}
+
+// Entry returns the address of the node the client enters the ring through
+func (c *Client) Entry() NodeAddress {
+	return c.entry
+}
+
+// GetState fetches the state of the node at addr
+func GetState(ctx context.Context, addr NodeAddress) (NodeState, error) {
+	var state NodeState
+	err := ChordCallContext(ctx, addr, "Node.GetStateRPC", "", &state)
+	return state, err
+}
+
+// State returns the state of the node the client enters the ring through
+func (c *Client) State(ctx context.Context) (NodeState, error) {
+	return GetState(c.context(ctx), c.entry)
+}
+
+// ContentKey returns the key of value in a ring using the content store mode
+func ContentKey(value []byte) string {
+	return contentChecksum(value)
+}
+
+// newObject packs a key and value into the FileRPC sent over the wire
+func newObject(key string, value []byte) FileRPC {
+	object := FileRPC{Name: key, Content: value, Checksum: contentChecksum(value)}
+	object.Id = StrHash(key)
+	object.Id.Mod(object.Id, hashMod)
+	return object
+}
+
+// Lookup returns the address of the node responsible for key
+func (c *Client) Lookup(ctx context.Context, key string) (NodeAddress, error) {
+	err := ValidateName(key)
+	if err != nil {
+		return "", err
+	}
+	return findContext(c.context(ctx), StrHash(key), c.entry)
+}
+
+/*
+* @description: Store value under key, an existing value is overwritten.
+*				In a ring using the content store mode key must be ContentKey(value).
+ */
+func (c *Client) Put(ctx context.Context, key string, value []byte) error {
+	addr, err := c.Lookup(ctx, key)
+	if err != nil {
+		return err
+	}
+	var reply StoreFileRPCReply
+	return ChordCallContext(c.context(ctx), addr, "Node.PutFileRPC", newObject(key, value), &reply)
+}
+
+// Get returns the value stored under key, ErrNotFound if there is none
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	addr, err := c.Lookup(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	object := newObject(key, nil)
+	var reply FileRPC
+	err = ChordCallContext(c.context(ctx), addr, "Node.GetFileRPC", object, &reply)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Name != key {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	err = reply.verify()
+	if err != nil {
+		return nil, err
+	}
+	return reply.Content, nil
+}
+
+// Delete removes the value stored under key, ErrNotFound if there is none
+func (c *Client) Delete(ctx context.Context, key string) error {
+	addr, err := c.Lookup(ctx, key)
+	if err != nil {
+		return err
+	}
+	var reply DeleteFileRPCReply
+	return ChordCallContext(c.context(ctx), addr, "Node.DeleteFileRPC", newObject(key, nil), &reply)
+}
+
+/*
+* @description: Upload a local file to the ring
+* @return: 		the key to get the file with: the base name of the path, or
+*				ContentKey(content) when the ring uses the content store mode
+ */
+func (c *Client) StoreFile(ctx context.Context, path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	key := filepath.Base(path)
+	if c.storeMode == StoreModeContent {
+		key = ContentKey(content)
+	}
+	addr, err := c.Lookup(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	var reply StoreFileRPCReply
+	err = ChordCallContext(c.context(ctx), addr, "Node.StoreFileRPC", newObject(key, content), &reply)
+	if err != nil {
+		return "", err
+	}
+	if !reply.Success {
+		return "", errors.New("cannot store the file: " + key)
+	}
+	return key, nil
+}
+
+// Download the file stored under key to a local path
+func (c *Client) GetFile(ctx context.Context, key string, path string) error {
+	content, err := c.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if c.storeMode == StoreModeContent && ContentKey(content) != key {
+		return fmt.Errorf("%w: content does not match key %s", ErrCorrupt, key)
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
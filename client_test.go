@@ -0,0 +1,74 @@
+package chord
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDial(t *testing.T) {
+	nodes := startTestRing(t, 2, Config{})
+	ctx := context.Background()
+	client, err := Dial(nodes[1].Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Entry() != nodes[1].Address {
+		t.Fatalf("Entry = %s, want %s", client.Entry(), nodes[1].Address)
+	}
+
+	// The client reads and writes through any member without joining
+	if err := client.Put(ctx, "key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := NewClient(nodes[0]).Get(ctx, "key"); err != nil || string(value) != "value" {
+		t.Fatalf("Get through another member = %q, %v", value, err)
+	}
+	addr, err := client.Lookup(ctx, "key")
+	if err != nil || (addr != nodes[0].Address && addr != nodes[1].Address) {
+		t.Fatalf("Lookup = %s, %v", addr, err)
+	}
+	if err := client.Delete(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(ctx, "key"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get after Delete = %v, want ErrNotFound", err)
+	}
+
+	dir := t.TempDir()
+	upload, download := filepath.Join(dir, "a.txt"), filepath.Join(dir, "copy.txt")
+	os.WriteFile(upload, []byte("file"), 0644)
+	key, err := client.StoreFile(ctx, upload)
+	if err != nil || key != "a.txt" {
+		t.Fatalf("StoreFile = %q, %v", key, err)
+	}
+	if err := client.GetFile(ctx, key, download); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(download); string(content) != "file" {
+		t.Fatalf("GetFile wrote %q", content)
+	}
+	// The ring does not know the client
+	report, err := CrawlRing(ctx, nodes[0].Address)
+	if err != nil || len(report.Members) != 2 {
+		t.Fatalf("ring after the client calls = %+v, %v", report, err)
+	}
+}
+
+func TestDialUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := NodeAddress(listener.Addr().String())
+	listener.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := DialContext(ctx, address); err == nil {
+		t.Fatal("Dial of a closed port succeeded")
+	}
+}
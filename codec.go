@@ -0,0 +1,398 @@
+package chord
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"reflect"
+	"sync"
+)
+
+/*------------------------------------------------------------*/
+/*                    JSON-RPC Codecs Below                   */
+/*------------------------------------------------------------*/
+
+/*
+* The codecs speak the JSON-RPC 1.0 envelope of net/rpc/jsonrpc,
+*	{"method": "Node.FindSuccessorRPC", "params": [...], "id": 1}
+* with an optional "trace" member carrying the SpanContext of the caller and
+* an optional "auth" member carrying its Credentials. Peers using
+* net/rpc/jsonrpc ignore the members, so both sides interoperate.
+ */
+
+// callParams is passed to rpc.Client.Go instead of the bare request to add a trace or credentials to the envelope
+type callParams struct {
+	Trace  *SpanContext
+	Auth   *Credentials
+	Params interface{}
+}
+
+type clientRequest struct {
+	Method string         `json:"method"`
+	Params [1]interface{} `json:"params"`
+	Id     uint64         `json:"id"`
+	Trace  *SpanContext   `json:"trace,omitempty"`
+	Auth   *Credentials   `json:"auth,omitempty"`
+}
+
+type clientResponse struct {
+	Id     uint64           `json:"id"`
+	Result *json.RawMessage `json:"result"`
+	Error  interface{}      `json:"error"`
+}
+
+type jsonClientCodec struct {
+	dec *json.Decoder
+	enc *json.Encoder
+	c   io.Closer
+
+	req  clientRequest
+	resp clientResponse
+
+	// Method of every pending request, by sequence number
+	mutex   sync.Mutex
+	pending map[uint64]string
+}
+
+// newJSONClientCodec returns a client codec for conn, see callParams
+func newJSONClientCodec(conn io.ReadWriteCloser) rpc.ClientCodec {
+	return &jsonClientCodec{
+		dec:     json.NewDecoder(conn),
+		enc:     json.NewEncoder(conn),
+		c:       conn,
+		pending: make(map[uint64]string),
+	}
+}
+
+// WriteRequest is serialized by rpc.Client
+func (c *jsonClientCodec) WriteRequest(r *rpc.Request, param interface{}) error {
+	c.mutex.Lock()
+	c.pending[r.Seq] = r.ServiceMethod
+	c.mutex.Unlock()
+	c.req.Method = r.ServiceMethod
+	c.req.Id = r.Seq
+	c.req.Trace = nil
+	c.req.Auth = nil
+	if call, ok := param.(callParams); ok {
+		c.req.Params[0] = call.Params
+		c.req.Trace = call.Trace
+		c.req.Auth = call.Auth
+	} else {
+		c.req.Params[0] = param
+	}
+	return c.enc.Encode(&c.req)
+}
+
+func (c *jsonClientCodec) ReadResponseHeader(r *rpc.Response) error {
+	c.resp = clientResponse{}
+	if err := c.dec.Decode(&c.resp); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	r.ServiceMethod = c.pending[c.resp.Id]
+	delete(c.pending, c.resp.Id)
+	c.mutex.Unlock()
+
+	r.Error = ""
+	r.Seq = c.resp.Id
+	if c.resp.Error != nil || c.resp.Result == nil {
+		x, ok := c.resp.Error.(string)
+		if !ok {
+			return fmt.Errorf("invalid error %v", c.resp.Error)
+		}
+		if x == "" {
+			x = "unspecified error"
+		}
+		r.Error = x
+	}
+	return nil
+}
+
+func (c *jsonClientCodec) ReadResponseBody(x interface{}) error {
+	if x == nil {
+		return nil
+	}
+	return json.Unmarshal(*c.resp.Result, x)
+}
+
+func (c *jsonClientCodec) Close() error {
+	return c.c.Close()
+}
+
+type serverRequest struct {
+	Method string           `json:"method"`
+	Params *json.RawMessage `json:"params"`
+	Id     *json.RawMessage `json:"id"`
+	Trace  *SpanContext     `json:"trace,omitempty"`
+	Auth   *Credentials     `json:"auth,omitempty"`
+}
+
+type serverResponse struct {
+	Id     *json.RawMessage `json:"id"`
+	Result interface{}      `json:"result"`
+	Error  interface{}      `json:"error"`
+}
+
+type jsonServerCodec struct {
+	dec *json.Decoder
+	enc *json.Encoder
+	c   io.Closer
+
+	// The request being read, requests are read one at a time by rpc.Server
+	req serverRequest
+
+	// net/rpc uses its own sequence numbers, the JSON ids of pending requests are kept here
+	mutex   sync.Mutex
+	seq     uint64
+	pending map[uint64]*json.RawMessage
+}
+
+// newJSONServerCodec returns a server codec for conn, see requestTrace and requestAuth
+func newJSONServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	return &jsonServerCodec{
+		dec:     json.NewDecoder(conn),
+		enc:     json.NewEncoder(conn),
+		c:       conn,
+		pending: make(map[uint64]*json.RawMessage),
+	}
+}
+
+var errMissingParams = errors.New("jsonrpc: request body missing params")
+
+func (c *jsonServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	c.req = serverRequest{}
+	if err := c.dec.Decode(&c.req); err != nil {
+		return err
+	}
+	r.ServiceMethod = c.req.Method
+
+	c.mutex.Lock()
+	c.seq++
+	c.pending[c.seq] = c.req.Id
+	c.req.Id = nil
+	r.Seq = c.seq
+	c.mutex.Unlock()
+	return nil
+}
+
+// requestTrace is the trace sent with the request whose header was read last, nil if none
+func (c *jsonServerCodec) requestTrace() *SpanContext {
+	return c.req.Trace
+}
+
+// requestAuth are the credentials sent with the request whose header was read last, nil if none
+func (c *jsonServerCodec) requestAuth() *Credentials {
+	return c.req.Auth
+}
+
+func (c *jsonServerCodec) ReadRequestBody(x interface{}) error {
+	if x == nil {
+		return nil
+	}
+	if c.req.Params == nil {
+		return errMissingParams
+	}
+	// JSON params is an array value, the argument is its only element
+	var params [1]interface{}
+	params[0] = x
+	return json.Unmarshal(*c.req.Params, &params)
+}
+
+var null = json.RawMessage([]byte("null"))
+
+func (c *jsonServerCodec) WriteResponse(r *rpc.Response, x interface{}) error {
+	c.mutex.Lock()
+	b, ok := c.pending[r.Seq]
+	if !ok {
+		c.mutex.Unlock()
+		return errors.New("invalid sequence number in response")
+	}
+	delete(c.pending, r.Seq)
+	c.mutex.Unlock()
+
+	if b == nil {
+		// Invalid request so no id, use JSON null
+		b = &null
+	}
+	resp := serverResponse{Id: b}
+	if r.Error == "" {
+		resp.Result = x
+	} else {
+		resp.Error = r.Error
+	}
+	return c.enc.Encode(resp)
+}
+
+func (c *jsonServerCodec) Close() error {
+	return c.c.Close()
+}
+
+/*------------------------------------------------------------*/
+/*                       Gob Codecs Below                     */
+/*------------------------------------------------------------*/
+
+/*
+* The codecs frame a call as net/rpc's default gob codec does, a header
+* followed by the body, with the trace and credentials of the caller added to
+* the request header. gob refuses empty structs, so such bodies (the struct{} requests,
+* the body of an error response) travel as gobNone.
+ */
+
+type gobRequestHeader struct {
+	ServiceMethod string
+	Seq           uint64
+	Trace         *SpanContext
+	Auth          *Credentials
+}
+
+// gobNone stands for a body without exported fields
+type gobNone struct {
+	None bool
+}
+
+// noFields reports whether x, or what it points to, is an empty struct such as struct{}
+func noFields(x interface{}) bool {
+	t := indirect(reflect.TypeOf(x))
+	// Types like big.Int have no exported fields but encode themselves
+	return t != nil && t.Kind() == reflect.Struct && t.NumField() == 0
+}
+
+func encodeGobBody(enc *gob.Encoder, body interface{}) error {
+	if noFields(body) {
+		return enc.Encode(gobNone{None: true})
+	}
+	return enc.Encode(body)
+}
+
+// decodeGobBody decodes into x, a nil x discards the body
+func decodeGobBody(dec *gob.Decoder, x interface{}) error {
+	if x != nil && noFields(x) {
+		return dec.Decode(&gobNone{})
+	}
+	return dec.Decode(x)
+}
+
+type gobClientCodec struct {
+	rwc io.ReadWriteCloser
+	dec *gob.Decoder
+	enc *gob.Encoder
+	buf *bufio.Writer
+}
+
+// newGobClientCodec returns a client codec for conn, see callParams
+func newGobClientCodec(conn io.ReadWriteCloser) rpc.ClientCodec {
+	buf := bufio.NewWriter(conn)
+	return &gobClientCodec{rwc: conn, dec: gob.NewDecoder(conn), enc: gob.NewEncoder(buf), buf: buf}
+}
+
+// WriteRequest is serialized by rpc.Client
+func (c *gobClientCodec) WriteRequest(r *rpc.Request, param interface{}) error {
+	header := gobRequestHeader{ServiceMethod: r.ServiceMethod, Seq: r.Seq}
+	if call, ok := param.(callParams); ok {
+		header.Trace = call.Trace
+		header.Auth = call.Auth
+		param = call.Params
+	}
+	err := c.enc.Encode(&header)
+	if err == nil {
+		err = encodeGobBody(c.enc, param)
+	}
+	if err == nil {
+		return c.buf.Flush()
+	}
+	// The stream is out of step once part of a call was encoded
+	c.Close()
+	return err
+}
+
+func (c *gobClientCodec) ReadResponseHeader(r *rpc.Response) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobClientCodec) ReadResponseBody(x interface{}) error {
+	return decodeGobBody(c.dec, x)
+}
+
+func (c *gobClientCodec) Close() error {
+	return c.rwc.Close()
+}
+
+type gobServerCodec struct {
+	rwc io.ReadWriteCloser
+	dec *gob.Decoder
+	enc *gob.Encoder
+	buf *bufio.Writer
+
+	// The trace and credentials of the request being read, requests are read one at a time by rpc.Server
+	trace *SpanContext
+	auth  *Credentials
+
+	// Responses are written concurrently by rpc.Server
+	mutex  sync.Mutex
+	closed bool
+}
+
+// newGobServerCodec returns a server codec for conn, see requestTrace and requestAuth
+func newGobServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	buf := bufio.NewWriter(conn)
+	return &gobServerCodec{rwc: conn, dec: gob.NewDecoder(conn), enc: gob.NewEncoder(buf), buf: buf}
+}
+
+func (c *gobServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	var header gobRequestHeader
+	if err := c.dec.Decode(&header); err != nil {
+		return err
+	}
+	r.ServiceMethod = header.ServiceMethod
+	r.Seq = header.Seq
+	c.trace = header.Trace
+	c.auth = header.Auth
+	return nil
+}
+
+// requestTrace is the trace sent with the request whose header was read last, nil if none
+func (c *gobServerCodec) requestTrace() *SpanContext {
+	return c.trace
+}
+
+// requestAuth are the credentials sent with the request whose header was read last, nil if none
+func (c *gobServerCodec) requestAuth() *Credentials {
+	return c.auth
+}
+
+func (c *gobServerCodec) ReadRequestBody(x interface{}) error {
+	return decodeGobBody(c.dec, x)
+}
+
+func (c *gobServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	err := c.enc.Encode(r)
+	if err == nil {
+		err = encodeGobBody(c.enc, body)
+	}
+	if err == nil {
+		err = c.buf.Flush()
+	}
+	if err != nil {
+		// The stream is out of step, the client sees the connection fail
+		c.closed = true
+		c.rwc.Close()
+	}
+	return err
+}
+
+func (c *gobServerCodec) Close() error {
+	c.mutex.Lock()
+	c.closed = true
+	c.mutex.Unlock()
+	return c.rwc.Close()
+}
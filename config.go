@@ -0,0 +1,381 @@
+package chord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/*------------------------------------------------------------*/
+/*                   Node Configuration Below                 */
+/*------------------------------------------------------------*/
+
+/*
+* @description: Config describes a node started by Start. Zero values select
+*				the defaults of the command line (see GetCmdArgs), except that
+*				Port is required unless Listener is given.
+ */
+type Config struct {
+	// Address the other nodes reach the node at: "localhost" or "127.0.0.1" as
+	// they are, "0.0.0.0" for the public address, any other IP for the local
+	// address of the default route. Default "localhost".
+	Address  string
+	Port     int          // Port listened on, taken from Listener if 0
+	Listener net.Listener // Serve on this listener instead of listening on Address:Port
+	Join     NodeAddress  // "IP:Port" of any member of the ring to join, empty to create a ring
+	Name     string       // Name the identifier is hashed from, default "IP:Port"
+
+	Stabilize        time.Duration // Time between invocations of stabilize, default 3s
+	FixFingers       time.Duration // Time between invocations of fix_fingers, default 1s
+	CheckPredecessor time.Duration // Time between invocations of check_predecessor, default 3s
+	Successors       int           // Number of successors to maintain, default 3
+	VNodes           int           // Ring positions taken by the node, see newVirtualNode, default 1
+
+	Storage   string // Storage backend for bucket and backup: "file" (default) or "memory"
+	DataDir   string // Root folder of the node folders, default "tmp"
+	StoreMode string // StoreModeName (default) or StoreModeContent
+
+	HTTPAddress    string // Address of the HTTP gateway, empty to disable it
+	HTTPToken      string // Bearer token of the PUT and DELETE requests of the gateway, required on a ring with a secret
+	MetricsAddress string // Address of the /metrics endpoint, empty to disable it
+	TraceFile      string // File the spans are appended to, empty to disable it
+	TraceURL       string // OTLP/HTTP collector the spans are sent to, empty to disable it
+	Wire           string // Format of the calls the node dials, WireJSON (default) or WireGob
+
+	// Optional protocol features offered when joining, default SupportedFeatures(), see protocol.go
+	Features []string
+
+	// ID and shared secret of the ring, the calls changing the node need both, see auth.go.
+	// A ring without either is open to every caller.
+	Ring   string
+	Secret string
+
+	// Secret of the administrative RPCs such as SetPredecessorRPC, empty to disable them, see SetAdminSecret
+	AdminSecret string
+
+	// Limits of the RPC server, see limits.go
+	MaxConns       int     // Connections served at once, default 256
+	RateLimit      float64 // Requests per second of a peer IP on average, 0 (default) for no limit
+	RateBurst      int     // Requests a peer IP may send at once, default RateLimit rounded up
+	MaxFileSize    int64   // Bytes of the content of a file, default 16 MiB
+	MaxMessageSize int64   // Bytes of a request, default twice MaxFileSize plus 1 MiB
+	MaxMemory      int64   // Bytes held by the requests of all connections at once, default 256 MiB or twice MaxMessageSize if larger
+
+	Logger Logger // Logger of the node, default the one set with SetLogger
+}
+
+// ErrInvalidConfig is wrapped by the errors of Config.Validate
+var ErrInvalidConfig = errors.New("invalid config")
+
+// Bounds of the periodic task intervals
+const (
+	minTaskInterval = time.Millisecond
+	maxTaskInterval = time.Minute
+)
+
+// withDefaults returns cfg with the zero values replaced by the defaults
+func (cfg Config) withDefaults() Config {
+	if cfg.Address == "" {
+		cfg.Address = "localhost"
+	}
+	if cfg.Port == 0 && cfg.Listener != nil {
+		if addr, ok := cfg.Listener.Addr().(*net.TCPAddr); ok {
+			cfg.Port = addr.Port
+		}
+	}
+	if cfg.Stabilize == 0 {
+		cfg.Stabilize = 3 * time.Second
+	}
+	if cfg.FixFingers == 0 {
+		cfg.FixFingers = time.Second
+	}
+	if cfg.CheckPredecessor == 0 {
+		cfg.CheckPredecessor = 3 * time.Second
+	}
+	if cfg.Successors == 0 {
+		cfg.Successors = 3
+	}
+	if cfg.VNodes == 0 {
+		cfg.VNodes = 1
+	}
+	if cfg.Storage == "" {
+		cfg.Storage = "file"
+	}
+	if cfg.DataDir == "" {
+		cfg.DataDir = "tmp"
+	}
+	if cfg.StoreMode == "" {
+		cfg.StoreMode = StoreModeName
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = defaultLogger
+	}
+	if cfg.Wire == "" {
+		cfg.Wire = WireJSON
+	}
+	if cfg.Features == nil {
+		cfg.Features = SupportedFeatures()
+	}
+	if cfg.MaxConns == 0 {
+		cfg.MaxConns = defaultMaxConns
+	}
+	if cfg.RateBurst == 0 {
+		cfg.RateBurst = int(math.Ceil(cfg.RateLimit))
+	}
+	if cfg.MaxFileSize == 0 {
+		cfg.MaxFileSize = defaultMaxFileSize
+	}
+	if cfg.MaxMessageSize == 0 {
+		// Room for the file as base64 in JSON
+		cfg.MaxMessageSize = 2*cfg.MaxFileSize + 1<<20
+	}
+	if cfg.MaxMemory == 0 {
+		// Room for two of the largest requests at once
+		cfg.MaxMemory = defaultMaxMemory
+		if 2*cfg.MaxMessageSize > cfg.MaxMemory {
+			cfg.MaxMemory = 2 * cfg.MaxMessageSize
+		}
+	}
+	return cfg
+}
+
+// validHost reports whether host is "localhost" or an IP address
+func validHost(host string) bool {
+	return host == "localhost" || net.ParseIP(host) != nil
+}
+
+func validPort(port int) bool {
+	return port >= 1024 && port <= 65535
+}
+
+func invalidConfig(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidConfig, fmt.Sprintf(format, args...))
+}
+
+/*
+* @description: Check a config after defaults were applied
+* @return: 		an error wrapping ErrInvalidConfig naming the first invalid field
+ */
+func (cfg Config) Validate() error {
+	// Check if Ip address is valid or not
+	if !validHost(cfg.Address) {
+		return invalidConfig("address %q is not an IP address", cfg.Address)
+	}
+	// Check if port is valid
+	if !validPort(cfg.Port) {
+		return invalidConfig("port %d is not in [1024, 65535]", cfg.Port)
+	}
+
+	// Check if joining address and port is valid or not
+	if cfg.Join != "" {
+		host, port, err := net.SplitHostPort(string(cfg.Join))
+		if err != nil {
+			return invalidConfig("join address %q: %v", cfg.Join, err)
+		}
+		if !validHost(host) {
+			return invalidConfig("join address %q is not an IP address", host)
+		}
+		joinPort, err := strconv.Atoi(port)
+		if err != nil || !validPort(joinPort) {
+			return invalidConfig("join port %q is not in [1024, 65535]", port)
+		}
+	}
+
+	// Check if durations are valid
+	for _, task := range []struct {
+		name     string
+		interval time.Duration
+	}{{"stabilize", cfg.Stabilize}, {"fix_fingers", cfg.FixFingers}, {"check_predecessor", cfg.CheckPredecessor}} {
+		if task.interval < minTaskInterval || task.interval > maxTaskInterval {
+			return invalidConfig("%s interval %v is not in [%v, %v]", task.name, task.interval, minTaskInterval, maxTaskInterval)
+		}
+	}
+
+	// Check if number of successors is valid
+	if cfg.Successors < 1 || cfg.Successors > 32 {
+		return invalidConfig("successors %d is not in [1, 32]", cfg.Successors)
+	}
+
+	// Check if number of virtual nodes is valid
+	if cfg.VNodes < 1 || cfg.VNodes > maxVirtualNodes {
+		return invalidConfig("virtual nodes %d is not in [1, %d]", cfg.VNodes, maxVirtualNodes)
+	}
+
+	// Check if storage backend and store mode are known
+	if cfg.Storage != "file" && cfg.Storage != "memory" {
+		return invalidConfig("unknown storage %q", cfg.Storage)
+	}
+	if cfg.StoreMode != StoreModeName && cfg.StoreMode != StoreModeContent {
+		return invalidConfig("unknown store mode %q", cfg.StoreMode)
+	}
+
+	// Check if wire format is known
+	if !validWireFormat(cfg.Wire) {
+		return invalidConfig("unknown wire format %q", cfg.Wire)
+	}
+
+	// Check if protocol features are known
+	for _, feature := range cfg.Features {
+		if !(Protocol{Features: SupportedFeatures()}).Has(feature) {
+			return invalidConfig("unknown protocol feature %q", feature)
+		}
+	}
+
+	// Check if server limits are valid
+	if cfg.MaxConns < 1 {
+		return invalidConfig("max connections %d is not positive", cfg.MaxConns)
+	}
+	if cfg.RateLimit < 0 || (cfg.RateLimit > 0 && cfg.RateBurst < 1) {
+		return invalidConfig("rate limit %g with burst %d is not a valid rate", cfg.RateLimit, cfg.RateBurst)
+	}
+	if cfg.MaxFileSize < 1 {
+		return invalidConfig("max file size %d is not positive", cfg.MaxFileSize)
+	}
+	if cfg.MaxMessageSize <= cfg.MaxFileSize {
+		return invalidConfig("max message size %d is not larger than max file size %d", cfg.MaxMessageSize, cfg.MaxFileSize)
+	}
+	if cfg.MaxMemory < cfg.MaxMessageSize {
+		return invalidConfig("max memory %d is smaller than max message size %d", cfg.MaxMemory, cfg.MaxMessageSize)
+	}
+
+	// Check if the gateway of a closed ring has access control of its own, it calls with the credentials of the node
+	if cfg.HTTPAddress != "" && (cfg.Ring != "" || cfg.Secret != "") && cfg.HTTPToken == "" {
+		return invalidConfig("the HTTP gateway of ring %q needs an HTTP token", cfg.Ring)
+	}
+
+	// Check if at most one span exporter is given
+	if cfg.TraceFile != "" && cfg.TraceURL != "" {
+		return invalidConfig("only one of trace file and trace collector can be given")
+	}
+	return nil
+}
+
+/*
+* @description: Start a node: listen (unless cfg.Listener is given) and serve
+*				the RPCs and HTTP endpoints, join the ring at cfg.Join or create a
+*				new one, then start the periodic tasks. Nothing is printed and the
+*				process is never exited, so several nodes can run in one process
+*				(see package chordtest).
+* @param: 		ctx: bounds listening and joining, the running node does not depend on it
+* @return: 		the running node, stopped with Quit; on error everything opened
+*				is closed again, cfg.Listener included
+ */
+func Start(ctx context.Context, cfg Config) (*Node, error) {
+	cfg = cfg.withDefaults()
+	err := cfg.Validate()
+	if err != nil {
+		if cfg.Listener != nil {
+			cfg.Listener.Close()
+		}
+		return nil, err
+	}
+
+	listener := cfg.Listener
+	if listener == nil {
+		listener, err = currentTransport().Listen(ctx, fmt.Sprintf("%s:%d", cfg.Address, cfg.Port))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Create new Node
+	node, err := NewNode(cfg)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	// Start tracing before the first RPC
+	if cfg.TraceFile != "" {
+		exporter, err := NewFileSpanExporter(cfg.TraceFile)
+		if err != nil {
+			listener.Close()
+			node.abortStart()
+			return nil, fmt.Errorf("open trace file: %w", err)
+		}
+		node.traceExporter = exporter
+	} else if cfg.TraceURL != "" {
+		node.traceExporter = NewCollectorSpanExporter(cfg.TraceURL, node.Name)
+	}
+	if node.traceExporter != nil {
+		installSpanExporter(node.traceExporter)
+	}
+
+	err = node.createVirtualNodes(cfg)
+	if err == nil {
+		err = node.registerRPC()
+	}
+	if err != nil {
+		listener.Close()
+		node.abortStart()
+		return nil, err
+	}
+	node.logger().Info("Local node listening", F("address", listener.Addr()))
+	// Use a separate goroutine to accept connection
+	node.serve(listener)
+
+	// Bind the HTTP gateway and the metrics endpoint (the gateway serves /metrics
+	// as well) before joining, so that a busy address does not touch the ring
+	if cfg.HTTPAddress != "" {
+		node.httpServer, err = node.serveHTTP("HTTP gateway", cfg.HTTPAddress, NewGateway(node))
+	}
+	if err == nil && cfg.MetricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", MetricsHandler(node))
+		node.metricsServer, err = node.serveHTTP("Metrics endpoint", cfg.MetricsAddress, mux)
+	}
+	if err != nil {
+		node.abortStart()
+		return nil, err
+	}
+
+	if cfg.Join != "" {
+		// Join exsiting chord
+		node.logger().Info("Connecting to the remote node", F(FieldPeer, cfg.Join))
+		err := node.joinChord(ctx, cfg.Join)
+		if err != nil {
+			node.abortStart()
+			return nil, fmt.Errorf("join %s: %w", cfg.Join, err)
+		}
+		node.logger().Info("Join RPC call success", F(FieldPeer, cfg.Join))
+	} else {
+		// Create new chord
+		node.CreateChord()
+	}
+	// Virtual nodes join through their host, which is already on the ring
+	for _, vnode := range node.VNodes {
+		err := vnode.joinChord(ctx, node.Address)
+		if err != nil {
+			node.abortStart()
+			return nil, fmt.Errorf("join virtual node %s: %w", vnode.Name, err)
+		}
+	}
+
+	// Start periodic tasks
+	for _, local := range node.LocalNodes() {
+		local.startPeriodicTasks(cfg)
+	}
+	return node, nil
+}
+
+// serveHTTP serves handler on addr in the background, the address is bound when it returns
+func (node *Node) serveHTTP(what string, addr string, handler http.Handler) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", what, err)
+	}
+	server := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			node.logger().Error(what+" failed", Err(err))
+		}
+	}()
+	node.logger().Info(what+" listening", F("address", addr))
+	return server, nil
+}
@@ -0,0 +1,111 @@
+package chord
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMaxMemoryDefault(t *testing.T) {
+	cases := []struct {
+		cfg  Config
+		want int64
+	}{
+		{Config{}, defaultMaxMemory},
+		{Config{MaxFileSize: 128 << 20}, 2 * (2*128<<20 + 1<<20)},
+		{Config{MaxMessageSize: 200 << 20}, 400 << 20},
+		{Config{MaxFileSize: 128 << 20, MaxMemory: 1 << 30}, 1 << 30},
+	}
+	for _, c := range cases {
+		c.cfg.Port = 4000
+		cfg := c.cfg.withDefaults()
+		if cfg.MaxMemory != c.want {
+			t.Errorf("MaxMemory of %+v = %d, want %d", c.cfg, cfg.MaxMemory, c.want)
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("defaults of %+v: %v", c.cfg, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		change func(cfg *Config)
+	}{
+		{"address", func(cfg *Config) { cfg.Address = "not an address" }},
+		{"port", func(cfg *Config) { cfg.Port = 80 }},
+		{"join address", func(cfg *Config) { cfg.Join = "4000" }},
+		{"join port", func(cfg *Config) { cfg.Join = "127.0.0.1:70000" }},
+		{"stabilize interval", func(cfg *Config) { cfg.Stabilize = time.Nanosecond }},
+		{"successors", func(cfg *Config) { cfg.Successors = 33 }},
+		{"virtual nodes", func(cfg *Config) { cfg.VNodes = -1 }},
+		{"storage", func(cfg *Config) { cfg.Storage = "tape" }},
+		{"store mode", func(cfg *Config) { cfg.StoreMode = "hash" }},
+		{"wire format", func(cfg *Config) { cfg.Wire = "xml" }},
+		{"feature", func(cfg *Config) { cfg.Features = []string{"teleport"} }},
+		{"rate burst", func(cfg *Config) { cfg.RateLimit, cfg.RateBurst = 10, -1 }},
+		{"message size", func(cfg *Config) { cfg.MaxMessageSize = cfg.MaxFileSize }},
+		{"memory", func(cfg *Config) { cfg.MaxMemory = cfg.MaxMessageSize - 1 }},
+		{"gateway token", func(cfg *Config) { cfg.HTTPAddress, cfg.Secret = "127.0.0.1:8080", "secret" }},
+		{"span exporters", func(cfg *Config) { cfg.TraceFile, cfg.TraceURL = "trace.jsonl", "http://127.0.0.1:9411" }},
+	}
+	valid := Config{Port: 4000}.withDefaults()
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("defaults: %v", err)
+	}
+	for _, c := range cases {
+		cfg := valid
+		c.change(&cfg)
+		if err := cfg.Validate(); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("invalid %s: %v, want ErrInvalidConfig", c.name, err)
+		}
+	}
+}
+
+func TestStartReturnsErrors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := func(cfg Config) error {
+		t.Helper()
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Address, cfg.Listener, cfg.DataDir, cfg.Logger = "127.0.0.1", listener, t.TempDir(), NopLogger()
+		node, err := Start(ctx, cfg)
+		if err == nil {
+			node.Quit()
+			t.Fatal("Start succeeded")
+		}
+		// Whatever failed, the listener is given back
+		if _, acceptErr := listener.Accept(); acceptErr == nil {
+			t.Fatal("listener left open")
+		}
+		return err
+	}
+
+	if err := start(Config{Successors: 100}); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("invalid config: %v, want ErrInvalidConfig", err)
+	}
+	// Nothing listens on the port of a closed listener
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	if err := start(Config{Join: NodeAddress(closed.Addr().String())}); err == nil || errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("join of an unreachable node: %v", err)
+	}
+	// A busy HTTP address fails before the ring is joined
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	if err := start(Config{HTTPAddress: busy.Addr().String()}); err == nil {
+		t.Fatal("Start with a busy HTTP address succeeded")
+	}
+}
@@ -0,0 +1,188 @@
+package chord
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*------------------------------------------------------------*/
+/*                     HTTP Gateway Below                     */
+/*------------------------------------------------------------*/
+
+/*
+* @description: HTTP/REST gateway to the ring, served by a node
+*				GET    /lookup/{key}   address of the node responsible for key
+*				PUT    /objects/{key}  store the request body under key
+*				GET    /objects/{key}  the value of key as response body
+*				DELETE /objects/{key}  delete key
+*				GET    /state          NodeState of the serving node
+*				GET    /metrics        metrics in the Prometheus text format
+*				Values are sent as raw bodies, everything else as JSON. A value
+*				travels in a single FileRPC, so bodies are read whole and limited
+*				to the file size limit of the node (413 beyond it).
+*				The gateway calls the ring with the credentials of the node, so
+*				PUT and DELETE need "Authorization: Bearer <Config.HTTPToken>"
+*				if the node has a token, and are refused (401) on a node of a
+*				ring with a secret that has none. Reads stay open, as on the ring.
+ */
+type Gateway struct {
+	node   *Node
+	client *Client
+}
+
+func NewGateway(node *Node) *Gateway {
+	return &Gateway{node: node, client: NewClient(node)}
+}
+
+type lookupResponse struct {
+	Key     string
+	Address NodeAddress
+}
+
+type errorResponse struct {
+	Error string
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/lookup/"):
+		g.lookup(w, r, strings.TrimPrefix(r.URL.Path, "/lookup/"))
+	case strings.HasPrefix(r.URL.Path, "/objects/"):
+		g.object(w, r, strings.TrimPrefix(r.URL.Path, "/objects/"))
+	case r.URL.Path == "/state":
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, http.MethodGet)
+			return
+		}
+		writeJSON(w, http.StatusOK, g.node.State())
+	case r.URL.Path == "/metrics":
+		MetricsHandler(g.node).ServeHTTP(w, r)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("no such endpoint: "+r.URL.Path))
+	}
+}
+
+func (g *Gateway) lookup(w http.ResponseWriter, r *http.Request, key string) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	addr, err := g.client.Lookup(r.Context(), key)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, lookupResponse{Key: key, Address: addr})
+}
+
+/*
+* @description: Check the bearer token of a request changing the ring
+* @return: 		an error wrapping ErrUnauthorized if the request may not change it
+ */
+func (g *Gateway) authorize(r *http.Request) error {
+	if g.node.httpToken == "" {
+		if g.node.secrets.closed() {
+			return fmt.Errorf("%w: the gateway of ring %q has no HTTP token, it only serves reads", ErrUnauthorized, g.node.secrets.ring)
+		}
+		return nil
+	}
+	header := r.Header.Get("Authorization")
+	presented := strings.TrimPrefix(header, "Bearer ")
+	if presented == header || subtle.ConstantTimeCompare([]byte(presented), []byte(g.node.httpToken)) != 1 {
+		return fmt.Errorf("%w: %s needs the bearer token of the gateway", ErrUnauthorized, r.Method)
+	}
+	return nil
+}
+
+func (g *Gateway) object(w http.ResponseWriter, r *http.Request, key string) {
+	if r.Method == http.MethodPut || r.Method == http.MethodDelete {
+		if err := g.authorize(r); err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+	}
+	switch r.Method {
+	case http.MethodPut:
+		value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, g.maxValueSize()))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("%w: the body exceeds %d bytes", ErrTooLarge, tooLarge.Limit))
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		err = g.client.Put(r.Context(), key, value)
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		value, err := g.client.Get(r.Context(), key)
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(value)
+	case http.MethodDelete:
+		err := g.client.Delete(r.Context(), key)
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// maxValueSize is the largest value the serving node stores, see Config.MaxFileSize
+func (g *Gateway) maxValueSize() int64 {
+	if g.node.limits.maxFileSize > 0 {
+		return g.node.limits.maxFileSize
+	}
+	return defaultMaxFileSize
+}
+
+// statusOf maps an error of the ring to an HTTP status code
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrInvalidName):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusForbidden
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+}
@@ -0,0 +1,145 @@
+package chord
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// collidingKey is a key other than key with the same identifier
+func collidingKey(key string) string {
+	for i := 0; ; i++ {
+		other := fmt.Sprintf("other-%d", i)
+		if Identifier(other).Cmp(Identifier(key)) == 0 {
+			return other
+		}
+	}
+}
+
+// gatewayRequest sends a request to a gateway, with the bearer token unless it is empty
+func gatewayRequest(t *testing.T, url, method, path, token string, body []byte) (int, string) {
+	t.Helper()
+	request, err := http.NewRequest(method, url+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	content, _ := io.ReadAll(response.Body)
+	return response.StatusCode, string(content)
+}
+
+func TestGateway(t *testing.T) {
+	node := startTestNode(t, Config{MaxFileSize: 1 << 10})
+	server := httptest.NewServer(NewGateway(node))
+	defer server.Close()
+	do := func(method, path string, body []byte) (int, string) {
+		t.Helper()
+		return gatewayRequest(t, server.URL, method, path, "", body)
+	}
+
+	if status, _ := do(http.MethodPut, "/objects/key", []byte("value")); status != http.StatusNoContent {
+		t.Fatalf("PUT = %d", status)
+	}
+	if status, body := do(http.MethodGet, "/objects/key", nil); status != http.StatusOK || body != "value" {
+		t.Fatalf("GET = %d %q", status, body)
+	}
+	// Putting again overwrites the value
+	do(http.MethodPut, "/objects/key", []byte("new value"))
+	if status, body := do(http.MethodGet, "/objects/key", nil); status != http.StatusOK || body != "new value" {
+		t.Fatalf("GET after overwrite = %d %q", status, body)
+	}
+	if status, body := do(http.MethodGet, "/lookup/key", nil); status != http.StatusOK || !strings.Contains(body, string(node.Address)) {
+		t.Fatalf("lookup = %d %s", status, body)
+	}
+
+	refused := []struct {
+		method, path string
+		body         []byte
+		status       int
+	}{
+		{http.MethodGet, "/objects/missing", nil, http.StatusNotFound},
+		{http.MethodPut, "/objects/..", []byte("x"), http.StatusBadRequest},
+		{http.MethodPut, "/objects/large", make([]byte, 1<<10+1), http.StatusRequestEntityTooLarge},
+		{http.MethodPost, "/objects/key", nil, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/nowhere", nil, http.StatusNotFound},
+	}
+	for _, e := range refused {
+		status, body := do(e.method, e.path, e.body)
+		if status != e.status || !strings.Contains(body, `"Error"`) {
+			t.Errorf("%s %s = %d %s, want %d", e.method, e.path, status, body, e.status)
+		}
+	}
+	// A key with the same identifier is stored next to it
+	other := collidingKey("key")
+	if status, _ := do(http.MethodPut, "/objects/"+other, []byte("other value")); status != http.StatusNoContent {
+		t.Fatalf("PUT of a colliding key = %d", status)
+	}
+	if status, body := do(http.MethodGet, "/objects/key", nil); status != http.StatusOK || body != "new value" {
+		t.Fatalf("GET after a colliding PUT = %d %q", status, body)
+	}
+	if status, body := do(http.MethodGet, "/objects/"+other, nil); status != http.StatusOK || body != "other value" {
+		t.Fatalf("GET of the colliding key = %d %q", status, body)
+	}
+
+	if status, _ := do(http.MethodDelete, "/objects/key", nil); status != http.StatusNoContent {
+		t.Fatalf("DELETE = %d", status)
+	}
+	if status, _ := do(http.MethodGet, "/objects/key", nil); status != http.StatusNotFound {
+		t.Fatalf("GET after DELETE = %d", status)
+	}
+}
+
+func TestGatewayToken(t *testing.T) {
+	ring := Config{Ring: "ring", Secret: "secret"}
+	withToken := ring
+	withToken.HTTPToken = "token"
+	node := startTestNode(t, withToken)
+	server := httptest.NewServer(NewGateway(node))
+	defer server.Close()
+
+	for _, token := range []string{"", "guess"} {
+		for _, method := range []string{http.MethodPut, http.MethodDelete} {
+			if status, _ := gatewayRequest(t, server.URL, method, "/objects/key", token, []byte("x")); status != http.StatusUnauthorized {
+				t.Errorf("%s with token %q = %d, want 401", method, token, status)
+			}
+		}
+	}
+	if status, body := gatewayRequest(t, server.URL, http.MethodPut, "/objects/key", "token", []byte("value")); status != http.StatusNoContent {
+		t.Fatalf("PUT with the token = %d %s", status, body)
+	}
+	// Reads stay open
+	if status, body := gatewayRequest(t, server.URL, http.MethodGet, "/objects/key", "", nil); status != http.StatusOK || body != "value" {
+		t.Fatalf("GET without token = %d %q", status, body)
+	}
+	if status, _ := gatewayRequest(t, server.URL, http.MethodDelete, "/objects/key", "token", nil); status != http.StatusNoContent {
+		t.Fatalf("DELETE with the token = %d", status)
+	}
+
+	// A gateway of a ring with a secret without token only serves reads
+	open := httptest.NewServer(NewGateway(startTestNode(t, ring)))
+	defer open.Close()
+	if status, _ := gatewayRequest(t, open.URL, http.MethodPut, "/objects/key", "anything", []byte("x")); status != http.StatusUnauthorized {
+		t.Fatalf("PUT through a gateway without token = %d, want 401", status)
+	}
+	if status, _ := gatewayRequest(t, open.URL, http.MethodGet, "/lookup/key", "", nil); status != http.StatusOK {
+		t.Fatalf("lookup through a gateway without token = %d", status)
+	}
+
+	// Nor does a node of such a ring start one
+	ring.Port, ring.HTTPAddress = 4000, "127.0.0.1:0"
+	if err := ring.withDefaults().Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("gateway of a ring with a secret without token: %v, want ErrInvalidConfig", err)
+	}
+}
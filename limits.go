@@ -0,0 +1,283 @@
+package chord
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+/*------------------------------------------------------------*/
+/*                  RPC Server Limits Below                   */
+/*------------------------------------------------------------*/
+
+/*
+* The RPC server of a node bounds what a peer can make it hold or do:
+*	- connections beyond Config.MaxConns are refused in the handshake (ErrBusy)
+*	  by at most maxRefusers goroutines, beyond them they are closed at once
+*	- requests of a peer IP beyond Config.RateLimit per second are answered
+*	  with ErrRateLimited without being served, except on the connections of
+*	  a member of a ring with a secret that proved its address (see sayHello).
+*	  A node takes ErrRateLimited and ErrBusy from a neighbour as a sign of
+*	  life, see answered.
+*	- files larger than Config.MaxFileSize are answered with ErrTooLarge
+*	- a request larger than Config.MaxMessageSize closes its connection, as
+*	  the stream cannot be resynchronized without reading it whole
+*	- the requests being read or served on all connections hold at most
+*	  Config.MaxMemory bytes; a connection that waits longer than memoryWait
+*	  for room is closed (ErrBusy). Files are checked against MaxFileSize only
+*	  once decoded, so this bounds what large requests can make a node hold.
+* Every rejection is counted in chord_rpc_rejected_total by reason.
+ */
+
+// Errors of rejected connections and requests, see remoteErrors
+var (
+	ErrBusy        = errors.New("server busy")
+	ErrRateLimited = errors.New("rate limited")
+	ErrTooLarge    = errors.New("too large")
+)
+
+// Defaults of the limits
+const (
+	defaultMaxConns    = 256
+	defaultMaxFileSize = 16 << 20
+	defaultMaxMemory   = 256 << 20
+)
+
+// How many idle peers the rate limiter keeps before forgetting them
+const maxRatePeers = 4096
+
+// How many connections are refused at once, see refuseConn
+const maxRefusers = 16
+
+// How long a request waits for room in the memory budget before its connection is closed
+const memoryWait = 5 * time.Second
+
+// serverLimits are the limits of the RPC server of a node, zero fields do not limit
+type serverLimits struct {
+	maxConns       int
+	maxFileSize    int64
+	maxMessageSize int64
+	rate           *rateLimiter // nil without rate limit
+	memory         *memoryBudget
+	refusers       chan struct{} // A slot per connection being refused
+
+	// Clock of the rate limiter, the virtual clock in a SimNetwork
+	now func() time.Time
+}
+
+func newServerLimits(cfg Config) serverLimits {
+	limits := serverLimits{
+		maxConns:       cfg.MaxConns,
+		maxFileSize:    cfg.MaxFileSize,
+		maxMessageSize: cfg.MaxMessageSize,
+		memory:         &memoryBudget{max: cfg.MaxMemory, freed: make(chan struct{})},
+		refusers:       make(chan struct{}, maxRefusers),
+		now:            time.Now,
+	}
+	if cfg.RateLimit > 0 {
+		limits.rate = &rateLimiter{rate: cfg.RateLimit, burst: float64(cfg.RateBurst), peers: make(map[string]*tokenBucket)}
+	}
+	return limits
+}
+
+/*
+* @description: Token bucket per peer IP: a peer may send burst requests at
+*				once and rate requests per second on average. The virtual nodes
+*				of a process share its IP, hence its bucket.
+ */
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	mutex sync.Mutex
+	peers map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token of peer, false if it has none left
+func (l *rateLimiter) allow(peer string, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	bucket, ok := l.peers[peer]
+	if !ok {
+		if len(l.peers) >= maxRatePeers {
+			l.forgetIdle(now)
+		}
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.peers[peer] = bucket
+	}
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// forgetIdle drops the buckets that are full again, a new bucket of the peer would be the same
+func (l *rateLimiter) forgetIdle(now time.Time) {
+	for peer, bucket := range l.peers {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.peers, peer)
+		}
+	}
+}
+
+/*
+* @description: Bytes the requests of all the connections of a node may hold
+*				at once. A connection takes bytes before it reads them and gives
+*				them back once the request they belong to is answered.
+ */
+type memoryBudget struct {
+	mutex sync.Mutex
+	used  int64
+	max   int64
+	freed chan struct{} // Closed and replaced whenever bytes are given back
+}
+
+// acquire takes n bytes, waiting at most wait for them, false if there was no room
+func (b *memoryBudget) acquire(n int64, wait time.Duration) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		b.mutex.Lock()
+		if b.used+n <= b.max {
+			b.used += n
+			b.mutex.Unlock()
+			return true
+		}
+		freed := b.freed
+		b.mutex.Unlock()
+		select {
+		case <-freed:
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+// release gives n bytes back
+func (b *memoryBudget) release(n int64) {
+	if n == 0 {
+		return
+	}
+	b.mutex.Lock()
+	b.used -= n
+	close(b.freed)
+	b.freed = make(chan struct{})
+	b.mutex.Unlock()
+}
+
+// peerIP is the IP of the remote side of conn, the key of the rate limiter
+func peerIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+/*
+* @description: Connection counting the bytes read for the request being
+*				read, see startRequest. Reading beyond max fails with ErrTooLarge,
+*				and failing to take the bytes from the memory budget of the node
+*				with ErrBusy, both close the connection. Reads are made by the
+*				one goroutine reading requests.
+ */
+type limitedConn struct {
+	net.Conn
+	node *Node
+	max  int64
+	read int64 // Bytes read since startRequest
+	held int64 // Bytes of the budget taken since the last take
+}
+
+// startRequest resets the count before the header of a request is read
+func (c *limitedConn) startRequest() {
+	c.read = 0
+}
+
+// take hands over the bytes of the budget read since the last take, see gateServerCodec.settle
+func (c *limitedConn) take() int64 {
+	held := c.held
+	c.held = 0
+	return held
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	if c.read >= c.max {
+		rpcRejected.inc("message_size")
+		err := fmt.Errorf("%w: request exceeds %d bytes", ErrTooLarge, c.max)
+		c.node.logger().Warn("Close connection", F(FieldPeer, c.RemoteAddr()), Err(err))
+		return 0, err
+	}
+	if int64(len(p)) > c.max-c.read {
+		p = p[:c.max-c.read]
+	}
+	budget := c.node.limits.memory
+	if !budget.acquire(int64(len(p)), memoryWait) {
+		rpcRejected.inc("memory")
+		err := fmt.Errorf("%w: the memory limit of %d bytes is reached", ErrBusy, budget.max)
+		c.node.logger().Warn("Close connection", F(FieldPeer, c.RemoteAddr()), Err(err))
+		return 0, err
+	}
+	n, err := c.Conn.Read(p)
+	budget.release(int64(len(p) - n))
+	c.read += int64(n)
+	c.held += int64(n)
+	return n, err
+}
+
+// answered reports whether a call failed because a live node refused it, not because it was not reached
+func answered(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrBusy)
+}
+
+/*
+* @description: Check a request against the rate limit of its peer
+* @return: 		an error wrapping ErrRateLimited if the peer sent too many
+ */
+func (node *Node) admit(peer string) error {
+	if node.limits.rate == nil || node.limits.rate.allow(peer, node.limits.now()) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s sends more than %g requests/s", ErrRateLimited, peer, node.limits.rate.rate)
+}
+
+// checkFileSize rejects a file larger than the limit of node
+func (node *Node) checkFileSize(f *FileRPC) error {
+	if node.limits.maxFileSize > 0 && int64(len(f.Content)) > node.limits.maxFileSize {
+		return fmt.Errorf("%w: file %s has %d bytes, the limit is %d", ErrTooLarge, f.Name, len(f.Content), node.limits.maxFileSize)
+	}
+	return nil
+}
+
+/*
+* @description: Refuse a connection beyond the connection limit in the
+*				background, telling a client that sent a handshake that the node
+*				is busy. Beyond maxRefusers connections being refused, the
+*				connection is closed at once.
+ */
+func (node *Node) refuseConn(conn net.Conn) {
+	rpcRejected.inc("connections")
+	err := fmt.Errorf("%w: the limit of %d connections is reached", ErrBusy, node.limits.maxConns)
+	node.logger().Warn("Refused connection", F(FieldPeer, conn.RemoteAddr()), Err(err))
+	select {
+	case node.limits.refusers <- struct{}{}:
+	default:
+		conn.Close()
+		return
+	}
+	go func() {
+		defer func() { <-node.limits.refusers }()
+		refuseHandshake(conn, err)
+		conn.Close()
+	}()
+}
@@ -0,0 +1,188 @@
+package chord
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := &rateLimiter{rate: 2, burst: 3, peers: make(map[string]*tokenBucket)}
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if !l.allow("a", now) {
+			t.Fatalf("request %d of the burst refused", i)
+		}
+	}
+	if l.allow("a", now) {
+		t.Fatal("request beyond the burst allowed")
+	}
+	// Peers have a bucket each
+	if !l.allow("b", now) {
+		t.Fatal("another peer refused")
+	}
+	// A token comes back every 1/rate seconds, at most burst of them
+	if l.allow("a", now.Add(400*time.Millisecond)) {
+		t.Fatal("request allowed before a token came back")
+	}
+	if !l.allow("a", now.Add(500*time.Millisecond)) {
+		t.Fatal("request refused after a token came back")
+	}
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !l.allow("a", later) {
+			t.Fatalf("request %d after an idle hour refused", i)
+		}
+	}
+	if l.allow("a", later) {
+		t.Fatal("tokens piled up beyond the burst")
+	}
+
+	// Only the buckets that are full again are forgotten
+	l.forgetIdle(later)
+	if _, ok := l.peers["b"]; ok {
+		t.Fatal("idle peer kept")
+	}
+	if _, ok := l.peers["a"]; !ok {
+		t.Fatal("busy peer forgotten")
+	}
+}
+
+// used is the number of bytes taken from b
+func used(b *memoryBudget) int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.used
+}
+
+func TestMemoryBudget(t *testing.T) {
+	b := &memoryBudget{max: 10, freed: make(chan struct{})}
+	if !b.acquire(8, time.Millisecond) {
+		t.Fatal("acquire within the budget failed")
+	}
+	if b.acquire(4, 10*time.Millisecond) {
+		t.Fatal("acquire beyond the budget succeeded")
+	}
+	done := make(chan bool)
+	go func() { done <- b.acquire(4, 5*time.Second) }()
+	time.Sleep(10 * time.Millisecond)
+	b.release(8)
+	if !<-done {
+		t.Fatal("acquire waiting for released bytes failed")
+	}
+	b.release(4)
+	if used(b) != 0 {
+		t.Fatalf("%d bytes used after every release", used(b))
+	}
+}
+
+func TestCheckFileSize(t *testing.T) {
+	node := &Node{limits: serverLimits{maxFileSize: 4}}
+	if err := node.checkFileSize(&FileRPC{Name: "a", Content: []byte("1234")}); err != nil {
+		t.Fatalf("file at the limit: %v", err)
+	}
+	if err := node.checkFileSize(&FileRPC{Name: "a", Content: []byte("12345")}); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("file beyond the limit: %v, want ErrTooLarge", err)
+	}
+}
+
+func TestSizeLimits(t *testing.T) {
+	node := startTestNode(t, Config{MaxFileSize: 1 << 10, MaxMessageSize: 4 << 10, MaxMemory: 8 << 10})
+	store := func(client *pooledClient, size int) error {
+		f := FileRPC{Id: Identifier("a.txt"), Name: "a.txt", Content: bytes.Repeat([]byte("a"), size)}
+		f.Checksum = contentChecksum(f.Content)
+		return remoteError(client.Call("Node.StoreFileRPC", callParams{Params: f}, &StoreFileRPCReply{}))
+	}
+
+	client := dialTestNode(t, node)
+	if err := store(client, 1<<10); err != nil {
+		t.Fatalf("file at the limit: %v", err)
+	}
+	// A file beyond the limit is answered, the connection stays usable
+	if err := store(client, 2<<10); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("file beyond the limit: %v, want ErrTooLarge", err)
+	}
+	if err := store(client, 10); err != nil {
+		t.Fatalf("call after a file beyond the limit: %v", err)
+	}
+	// A request beyond the message size closes the connection
+	err := store(client, 8<<10)
+	if err == nil {
+		t.Fatal("request beyond the message size served")
+	}
+	if _, ok := err.(rpc.ServerError); ok || errors.Is(err, ErrTooLarge) {
+		t.Fatalf("request beyond the message size answered with %v", err)
+	}
+	other := dialTestNode(t, node)
+	if err := store(other, 10); err != nil {
+		t.Fatalf("call on a new connection: %v", err)
+	}
+
+	// The memory of closed connections goes back to the budget
+	client.Close()
+	other.Close()
+	pool.closeAll()
+	deadline := time.Now().Add(5 * time.Second)
+	for used(node.limits.memory) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d bytes held after the connections closed", used(node.limits.memory))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRateLimitExemptsProvenMembers(t *testing.T) {
+	secret := Config{Ring: "ring", Secret: "secret", Stabilize: time.Minute, CheckPredecessor: time.Minute}
+	a := startTestNode(t, secret)
+	limited := secret
+	limited.RateLimit, limited.RateBurst = 0.001, 20
+	b := startTestNode(t, limited)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Beyond the burst, on the connection a proved its address on
+	for i := 0; i < 30; i++ {
+		var reply GetNameRPCReply
+		if err := ChordCallContext(contextWithNode(ctx, a), b.Address, "Node.GetNameRPC", "", &reply); err != nil {
+			t.Fatalf("call %d of a member: %v", i, err)
+		}
+	}
+	// Anonymous connections from the same IP share the rest of its burst
+	client := dialTestNode(t, b)
+	var err error
+	for i := 0; i < 30 && err == nil; i++ {
+		err = remoteError(client.Call("Node.GetNameRPC", callParams{Params: ""}, &GetNameRPCReply{}))
+	}
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("anonymous calls beyond the burst: %v, want ErrRateLimited", err)
+	}
+	if !answered(err) {
+		t.Fatal("a rate limited call is not taken as answered")
+	}
+}
+
+func TestConnectionLimit(t *testing.T) {
+	node := startTestNode(t, Config{MaxConns: 1})
+	process, _ := splitAddress(node.Address)
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		var conn net.Conn
+		conn, err = net.Dial("tcp", process)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_, err = newClientCodec(context.Background(), conn, WireJSON)
+	}
+	if !errors.Is(err, ErrBusy) {
+		t.Fatalf("connections beyond the limit: %v, want ErrBusy", err)
+	}
+	if !answered(fmt.Errorf("dial: %w", err)) {
+		t.Fatal("a busy node is not taken as answered")
+	}
+}
@@ -0,0 +1,126 @@
+package chord
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+/*------------------------------------------------------------*/
+/*                Ring Load Report Below                      */
+/*------------------------------------------------------------*/
+
+// LoadStats describes how evenly a quantity is spread over the nodes
+type LoadStats struct {
+	Min       float64
+	Max       float64
+	Mean      float64
+	StdDev    float64
+	Imbalance float64 // Max / Mean, 1 means perfectly even
+}
+
+// MemberLoad is the load of one node in a LoadReport
+type MemberLoad struct {
+	Address NodeAddress
+	Name    string
+	Id      *big.Int
+	Load    NodeLoad
+}
+
+// LoadReport is the result of RingLoad
+type LoadReport struct {
+	Members       []MemberLoad // Sorted by id
+	Complete      bool         // Every member of the ring was found
+	TotalKeys     int
+	TotalBytes    int64
+	Keys          LoadStats
+	Bytes         LoadStats
+	OwnedFraction LoadStats
+}
+
+/*
+* @description: Crawl the ring from start and aggregate the load of every member.
+*				The owned fraction of a member is computed from the crawled
+*				membership (previous member id to own id), not from its own
+*				predecessor pointer, so it is correct even while the ring stabilizes.
+ */
+func RingLoad(ctx context.Context, start NodeAddress) (*LoadReport, error) {
+	ring, err := CrawlRing(ctx, start)
+	if err != nil {
+		return nil, err
+	}
+	report := &LoadReport{Complete: ring.Complete}
+	for _, member := range ring.Members {
+		report.Members = append(report.Members, MemberLoad{Address: member.Address, Name: member.Name, Id: member.Id, Load: member.Load})
+	}
+	sort.Slice(report.Members, func(i, j int) bool {
+		return report.Members[i].Id.Cmp(report.Members[j].Id) < 0
+	})
+	count := len(report.Members)
+	keys := make([]float64, count)
+	bytes := make([]float64, count)
+	fractions := make([]float64, count)
+	for i := range report.Members {
+		member := &report.Members[i]
+		previous := report.Members[(i+count-1)%count]
+		member.Load.OwnedFraction = ownedFraction(previous.Id, member.Id)
+		report.TotalKeys += member.Load.Keys
+		report.TotalBytes += member.Load.Bytes
+		keys[i] = float64(member.Load.Keys)
+		bytes[i] = float64(member.Load.Bytes)
+		fractions[i] = member.Load.OwnedFraction
+	}
+	report.Keys = loadStats(keys)
+	report.Bytes = loadStats(bytes)
+	report.OwnedFraction = loadStats(fractions)
+	return report, nil
+}
+
+func loadStats(values []float64) LoadStats {
+	if len(values) == 0 {
+		return LoadStats{}
+	}
+	stats := LoadStats{Min: values[0], Max: values[0]}
+	sum := 0.0
+	for _, value := range values {
+		stats.Min = math.Min(stats.Min, value)
+		stats.Max = math.Max(stats.Max, value)
+		sum += value
+	}
+	stats.Mean = sum / float64(len(values))
+	variance := 0.0
+	for _, value := range values {
+		variance += (value - stats.Mean) * (value - stats.Mean)
+	}
+	stats.StdDev = math.Sqrt(variance / float64(len(values)))
+	if stats.Mean > 0 {
+		stats.Imbalance = stats.Max / stats.Mean
+	}
+	return stats
+}
+
+// Text renders the report as a table followed by the statistics
+func (r *LoadReport) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-24s %-16s %6s %6s %12s %8s\n", "ADDRESS", "NAME", "ID", "KEYS", "BYTES", "OWNED")
+	for _, member := range r.Members {
+		fmt.Fprintf(&b, "%-24s %-16s %6s %6d %12d %7.2f%%\n", member.Address, member.Name, member.Id,
+			member.Load.Keys, member.Load.Bytes, member.Load.OwnedFraction*100)
+	}
+	fmt.Fprintf(&b, "Total: %d keys, %d bytes on %d nodes", r.TotalKeys, r.TotalBytes, len(r.Members))
+	if !r.Complete {
+		b.WriteString(" (ring walk incomplete)")
+	}
+	b.WriteString("\n")
+	for _, row := range []struct {
+		name  string
+		stats LoadStats
+	}{{"keys", r.Keys}, {"bytes", r.Bytes}, {"owned", r.OwnedFraction}} {
+		fmt.Fprintf(&b, "%-6s min %.4g  max %.4g  mean %.4g  stddev %.4g  max/mean %.3g\n",
+			row.name, row.stats.Min, row.stats.Max, row.stats.Mean, row.stats.StdDev, row.stats.Imbalance)
+	}
+	return b.String()
+}
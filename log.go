@@ -0,0 +1,188 @@
+package chord
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*------------------------------------------------------------*/
+/*                   Structured Logging Below                 */
+/*------------------------------------------------------------*/
+
+// Level of a log entry, entries below the level of a logger are dropped
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelOff // Drop everything
+)
+
+var levelNames = []string{"debug", "info", "warn", "error", "off"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelOff {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses "debug", "info", "warn", "error" or "off"
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return LevelOff, fmt.Errorf("unknown log level %q", name)
+}
+
+// Log formats
+const (
+	LogFormatText = "text" // time LEVEL message key=value ...
+	LogFormatJSON = "json" // One JSON object per line
+)
+
+// Field is a key/value pair attached to a log entry
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Common field keys
+const (
+	FieldNode   = "node"   // Name of the logging node
+	FieldMethod = "method" // RPC method
+	FieldPeer   = "peer"   // Address of the remote node
+	FieldError  = "error"
+)
+
+// F makes a Field
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err makes the "error" Field
+func Err(err error) Field {
+	return Field{Key: FieldError, Value: err}
+}
+
+/*
+* @description: Leveled, structured logger used by nodes and the package.
+*				With returns a logger that adds fields to every entry, e.g. a
+*				node logs through Logger.With(F(FieldNode, name)).
+ */
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+	With(fields ...Field) Logger
+}
+
+// streamLogger writes entries of at least level to out
+type streamLogger struct {
+	mutex  *sync.Mutex // Shared by loggers derived with With
+	out    io.Writer
+	level  Level
+	format string
+	fields []Field
+}
+
+/*
+* @description: Create a logger writing to out
+* @param: 		level: minimum level written
+* @param: 		format: LogFormatText or LogFormatJSON
+ */
+func NewLogger(out io.Writer, level Level, format string) Logger {
+	return &streamLogger{mutex: &sync.Mutex{}, out: out, level: level, format: format}
+}
+
+// NopLogger drops every entry
+func NopLogger() Logger {
+	return NewLogger(io.Discard, LevelOff, LogFormatText)
+}
+
+func (l *streamLogger) Debug(msg string, fields ...Field) { l.log(LevelDebug, msg, fields) }
+func (l *streamLogger) Info(msg string, fields ...Field)  { l.log(LevelInfo, msg, fields) }
+func (l *streamLogger) Warn(msg string, fields ...Field)  { l.log(LevelWarn, msg, fields) }
+func (l *streamLogger) Error(msg string, fields ...Field) { l.log(LevelError, msg, fields) }
+
+func (l *streamLogger) With(fields ...Field) Logger {
+	derived := *l
+	derived.fields = append(append([]Field(nil), l.fields...), fields...)
+	return &derived
+}
+
+// fieldValue makes errors and Stringers readable in both formats
+func fieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+func (l *streamLogger) log(level Level, msg string, fields []Field) {
+	if level < l.level || l.level == LevelOff {
+		return
+	}
+	all := append(append([]Field(nil), l.fields...), fields...)
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	var line []byte
+	if l.format == LogFormatJSON {
+		entry := map[string]interface{}{"time": now, "level": level.String(), "msg": msg}
+		for _, field := range all {
+			entry[field.Key] = fieldValue(field.Value)
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			data, _ = json.Marshal(map[string]interface{}{"time": now, "level": level.String(), "msg": msg, "logError": err.Error()})
+		}
+		line = append(data, '\n')
+	} else {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s %-5s %s", now, strings.ToUpper(level.String()), msg)
+		for _, field := range all {
+			fmt.Fprintf(&b, " %s=%s", field.Key, quoteValue(fmt.Sprint(fieldValue(field.Value))))
+		}
+		b.WriteByte('\n')
+		line = []byte(b.String())
+	}
+	l.mutex.Lock()
+	l.out.Write(line)
+	l.mutex.Unlock()
+}
+
+// quoteValue quotes values that would break the key=value text format
+func quoteValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		return fmt.Sprintf("%q", value)
+	}
+	return value
+}
+
+// Logger of code without a node (ChordCall, find, clients), warnings and errors to stderr by default
+var defaultLogger = NewLogger(os.Stderr, LevelWarn, LogFormatText)
+
+// SetLogger replaces the logger used by code without a node
+func SetLogger(logger Logger) {
+	defaultLogger = logger
+}
+
+// logger returns the logger of the node, or the package logger if none was injected
+func (node *Node) logger() Logger {
+	if node.Logger == nil {
+		return defaultLogger.With(F(FieldNode, node.Name))
+	}
+	return node.Logger
+}
@@ -0,0 +1,308 @@
+package chord
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+/*------------------------------------------------------------*/
+/*                Crash-safe Object Metadata Below            */
+/*------------------------------------------------------------*/
+
+// Object roles recorded in the metadata log
+const (
+	RolePrimary = "primary" // Object is stored in the node's bucket
+	RoleReplica = "replica" // Object is a backup of the predecessor's bucket
+)
+
+// ObjectMeta is the metadata recorded for every stored object
+type ObjectMeta struct {
+	Name     string
+	Id       *big.Int
+	Role     string
+	Version  uint64
+	Checksum string // Hex encoded SHA-256 of the content
+}
+
+// metaRecord is a single line of the metadata log
+type metaRecord struct {
+	Op   string // "put" or "delete"
+	Meta ObjectMeta
+}
+
+/*
+* @description: Write-ahead log of object metadata. Every change is appended as
+*				"<crc32> <json>\n" and synced before the call returns, so after a
+*				crash the log is replayed up to the last complete record and any
+*				torn tail is discarded. The log is compacted into a snapshot on
+*				open and whenever dead records outnumber live ones.
+ */
+type MetaLog struct {
+	mutex   sync.Mutex
+	path    string
+	file    *os.File
+	entries map[string]ObjectMeta // role/name -> metadata
+	records int                   // Number of records currently in the log file
+	size    int64                 // Bytes of complete records in the log file, the next one is appended there
+	fresh   bool                  // The log file did not exist when opened
+}
+
+func metaKey(role string, name string) string {
+	return role + "/" + name
+}
+
+func contentChecksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func encodeMetaRecord(record metaRecord) ([]byte, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	line := fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(data), data)
+	return []byte(line), nil
+}
+
+func decodeMetaRecord(line []byte) (metaRecord, error) {
+	var record metaRecord
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	if len(line) < 10 || line[8] != ' ' {
+		return record, errors.New("malformed metadata record")
+	}
+	var crc uint32
+	_, err := fmt.Sscanf(string(line[:8]), "%08x", &crc)
+	if err != nil {
+		return record, err
+	}
+	data := line[9:]
+	if crc32.ChecksumIEEE(data) != crc {
+		return record, errors.New("metadata record checksum mismatch")
+	}
+	err = json.Unmarshal(data, &record)
+	return record, err
+}
+
+/*
+* @description: Open the metadata log at path, creating it if missing
+* @return: 		the log with all committed records replayed
+ */
+func OpenMetaLog(path string) (*MetaLog, error) {
+	l := &MetaLog{path: path, entries: make(map[string]ObjectMeta)}
+	file, err := os.Open(path)
+	if err == nil {
+		reader := bufio.NewReader(file)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err == io.EOF {
+				// A line without newline is a torn write, ignore it
+				break
+			}
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			record, err := decodeMetaRecord(line)
+			if err != nil {
+				// Everything after a corrupt record is untrusted
+				break
+			}
+			l.apply(record)
+		}
+		file.Close()
+	} else if os.IsNotExist(err) {
+		l.fresh = true
+	} else {
+		return nil, err
+	}
+	// Rewrite the log as a snapshot of the live entries
+	err = l.compact()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Fresh reports whether the log was created by OpenMetaLog rather than reopened
+func (l *MetaLog) Fresh() bool {
+	return l.fresh
+}
+
+func (l *MetaLog) apply(record metaRecord) {
+	key := metaKey(record.Meta.Role, record.Meta.Name)
+	if record.Op == "delete" {
+		delete(l.entries, key)
+	} else {
+		l.entries[key] = record.Meta
+	}
+}
+
+// compact writes the live entries to a new file and atomically replaces the log
+func (l *MetaLog) compact() error {
+	tmpPath := l.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	var size int64
+	for _, meta := range l.sortedEntries("") {
+		line, err := encodeMetaRecord(metaRecord{Op: "put", Meta: meta})
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		writer.Write(line)
+		size += int64(len(line))
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	err = os.Rename(tmpPath, l.path)
+	if err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(l.path))
+	l.file, err = os.OpenFile(l.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	l.records = len(l.entries)
+	l.size = size
+	return nil
+}
+
+/*
+* @description: Write a record to the log and sync it to disk. A write that
+*				fails partway is cut off again, replay stops at the first torn
+*				record and would drop every record appended after it. If the cut
+*				fails too the log is closed, so nothing is appended after it.
+ */
+func (l *MetaLog) append(record metaRecord) error {
+	if l.file == nil {
+		return errors.New("metadata log is closed")
+	}
+	line, err := encodeMetaRecord(record)
+	if err != nil {
+		return err
+	}
+	_, err = l.file.Write(line)
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		if cut := l.file.Truncate(l.size); cut != nil {
+			l.file.Close()
+			l.file = nil
+			return fmt.Errorf("%v, metadata log closed: cannot remove the torn record: %v", err, cut)
+		}
+		return err
+	}
+	l.apply(record)
+	l.records++
+	l.size += int64(len(line))
+	if l.records > 2*len(l.entries)+256 {
+		return l.compact()
+	}
+	return nil
+}
+
+// Put records the metadata of a stored object, the version is assigned by the log
+func (l *MetaLog) Put(meta ObjectMeta) (ObjectMeta, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	meta.Id = new(big.Int).Set(meta.Id)
+	meta.Version = 1
+	if old, ok := l.entries[metaKey(meta.Role, meta.Name)]; ok {
+		meta.Version = old.Version + 1
+	}
+	return meta, l.append(metaRecord{Op: "put", Meta: meta})
+}
+
+// Delete records the removal of an object
+func (l *MetaLog) Delete(role string, name string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	meta, ok := l.entries[metaKey(role, name)]
+	if !ok {
+		return nil
+	}
+	return l.append(metaRecord{Op: "delete", Meta: meta})
+}
+
+// Get returns the metadata of an object
+func (l *MetaLog) Get(role string, name string) (ObjectMeta, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	meta, ok := l.entries[metaKey(role, name)]
+	return meta, ok
+}
+
+// Entries returns the metadata of all objects with the given role ("" for all), sorted by id and name
+func (l *MetaLog) Entries(role string) []ObjectMeta {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.sortedEntries(role)
+}
+
+func (l *MetaLog) sortedEntries(role string) []ObjectMeta {
+	entries := make([]ObjectMeta, 0, len(l.entries))
+	for _, meta := range l.entries {
+		if role == "" || meta.Role == role {
+			entries = append(entries, meta)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Role != entries[j].Role {
+			return entries[i].Role < entries[j].Role
+		}
+		if c := entries[i].Id.Cmp(entries[j].Id); c != 0 {
+			return c < 0
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+func (l *MetaLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// syncDir flushes directory entries (renames, creates) to disk, best effort
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
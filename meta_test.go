@@ -0,0 +1,158 @@
+package chord
+
+import (
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMetaLogReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.log")
+	l, err := OpenMetaLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.Fresh() {
+		t.Fatal("a new log is not fresh")
+	}
+	// Objects are recorded by name, b shares the id of a
+	for _, object := range []struct {
+		name string
+		id   int64
+	}{{"b", 1}, {"a", 1}, {"c", 2}} {
+		if _, err := l.Put(ObjectMeta{Name: object.name, Id: big.NewInt(object.id), Role: RolePrimary, Checksum: contentChecksum([]byte(object.name))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	meta, err := l.Put(ObjectMeta{Name: "a", Id: big.NewInt(1), Role: RolePrimary})
+	if err != nil || meta.Version != 2 {
+		t.Fatalf("Put over an object = version %d, %v, want version 2", meta.Version, err)
+	}
+	if err := l.Delete(RolePrimary, "c"); err != nil {
+		t.Fatal(err)
+	}
+	l.Put(ObjectMeta{Name: "a", Id: big.NewInt(1), Role: RoleReplica})
+	l.Close()
+
+	l, err = OpenMetaLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.Fresh() {
+		t.Fatal("a reopened log is fresh")
+	}
+	primary := l.Entries(RolePrimary)
+	// Sorted by id, then by name
+	if len(primary) != 2 || primary[0].Name != "a" || primary[0].Version != 2 || primary[1].Name != "b" {
+		t.Fatalf("primary entries after reopen = %+v", primary)
+	}
+	if _, ok := l.Get(RolePrimary, "c"); ok {
+		t.Fatal("deleted entry replayed")
+	}
+	if meta, ok := l.Get(RoleReplica, "a"); !ok || meta.Id.Int64() != 1 {
+		t.Fatalf("replica entry = %+v, %v", meta, ok)
+	}
+}
+
+func TestMetaLogDropsTornAndCorruptTail(t *testing.T) {
+	for _, tail := range []string{
+		"0000", // Torn write, no newline
+		"00000000 {\"Op\":\"put\",\"Meta\":{\"Name\":\"x\",\"Id\":9,\"Role\":\"primary\"}}\n", // Wrong checksum
+	} {
+		path := filepath.Join(t.TempDir(), "metadata.log")
+		l, err := OpenMetaLog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Put(ObjectMeta{Name: "a", Id: big.NewInt(1), Role: RolePrimary})
+		l.Close()
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.WriteString(tail)
+		file.Close()
+
+		l, err = OpenMetaLog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entries := l.Entries(""); len(entries) != 1 || entries[0].Name != "a" {
+			t.Fatalf("entries after tail %q = %+v", tail, entries)
+		}
+		// Records appended after the tail was dropped are replayed
+		l.Put(ObjectMeta{Name: "b", Id: big.NewInt(2), Role: RolePrimary})
+		l.Close()
+		l, err = OpenMetaLog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entries := l.Entries(""); len(entries) != 2 {
+			t.Fatalf("entries appended after tail %q = %+v", tail, entries)
+		}
+		l.Close()
+	}
+}
+
+func TestMetaLogClosesWhenATornRecordCannotBeCut(t *testing.T) {
+	l, err := OpenMetaLog(filepath.Join(t.TempDir(), "metadata.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Writing and cutting both fail on a closed file
+	l.file.Close()
+	if _, err := l.Put(ObjectMeta{Name: "a", Id: big.NewInt(1), Role: RolePrimary}); err == nil || !strings.Contains(err.Error(), "metadata log closed") {
+		t.Fatalf("Put on a failing file = %v", err)
+	}
+	if _, err := l.Put(ObjectMeta{Name: "a", Id: big.NewInt(1), Role: RolePrimary}); err == nil {
+		t.Fatal("Put after the log was closed succeeded")
+	}
+	if _, ok := l.Get(RolePrimary, "a"); ok {
+		t.Fatal("a failed Put was applied")
+	}
+}
+
+func TestFileStoreRecovery(t *testing.T) {
+	dir := t.TempDir()
+	open := func() (*MetaLog, *FileStore) {
+		meta, err := OpenMetaLog(filepath.Join(dir, "metadata.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		store, err := NewFileStore(filepath.Join(dir, "bucket"), meta, RolePrimary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return meta, store
+	}
+	meta, store := open()
+	store.Put(big.NewInt(1), "kept", []byte("kept"))
+	store.Put(big.NewInt(2), "committed", []byte("committed"))
+	meta.Close()
+
+	bucket := filepath.Join(dir, "bucket")
+	// Crash after the metadata of "committed" was logged, before its rename
+	os.Rename(filepath.Join(bucket, diskName("committed")), filepath.Join(bucket, tempFileName("committed")))
+	// Crash before the metadata of "uncommitted" was logged
+	os.WriteFile(filepath.Join(bucket, tempFileName("uncommitted")), []byte("uncommitted"), 0644)
+
+	meta, store = open()
+	defer meta.Close()
+	if content, err := store.Get("committed"); err != nil || string(content) != "committed" {
+		t.Fatalf("committed write = %q, %v", content, err)
+	}
+	files, _ := os.ReadDir(bucket)
+	if len(files) != 2 {
+		t.Fatalf("%d files left in the bucket, want 2", len(files))
+	}
+
+	// Content changed behind the store is never handed out
+	os.WriteFile(filepath.Join(bucket, diskName("kept")), []byte("changed"), 0644)
+	if _, err := store.Get("kept"); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Get of changed content = %v, want ErrCorrupt", err)
+	}
+}
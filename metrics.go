@@ -0,0 +1,382 @@
+package chord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"net/rpc"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*------------------------------------------------------------*/
+/*                       Metrics Below                        */
+/*------------------------------------------------------------*/
+
+// Upper bounds of the latency histograms, in seconds
+var durationBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Upper bounds of the lookup hop histogram, a lookup gives up after 10 steps
+var hopBuckets = []float64{1, 2, 3, 4, 5, 6, 8, 10}
+
+// A metric exported by the registry
+type metric interface {
+	write(w io.Writer)
+}
+
+// registry holds the metrics of the process, vnodes and clients share it
+type registry struct {
+	mutex   sync.Mutex
+	metrics []metric
+}
+
+var metrics = &registry{}
+
+func (r *registry) add(m metric) {
+	r.mutex.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mutex.Unlock()
+}
+
+func (r *registry) write(w io.Writer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, m := range r.metrics {
+		m.write(w)
+	}
+}
+
+// labelString renders {name="value",...}, values are escaped as the text format requires
+func labelString(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Label values are joined into a single map key
+const labelSeparator = "\xff"
+
+// counterVec is a family of counters partitioned by labels
+type counterVec struct {
+	mutex  sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]float64
+}
+
+func newCounterVec(name string, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	metrics.add(c)
+	return c
+}
+
+func (c *counterVec) inc(values ...string) {
+	c.mutex.Lock()
+	c.values[strings.Join(values, labelSeparator)]++
+	c.mutex.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelString(c.labels, splitLabels(key, len(c.labels))), formatFloat(c.values[key]))
+	}
+}
+
+func splitLabels(key string, n int) []string {
+	if n == 0 {
+		return nil
+	}
+	return strings.Split(key, labelSeparator)
+}
+
+// histogram is a single series of a histogramVec
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// histogramVec is a family of histograms partitioned by labels
+type histogramVec struct {
+	mutex   sync.Mutex
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	series  map[string]*histogram
+}
+
+func newHistogramVec(name string, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+	metrics.add(h)
+	return h
+}
+
+func (h *histogramVec) observe(value float64, values ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	key := strings.Join(values, labelSeparator)
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+			break
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	names := append(append([]string(nil), h.labels...), "le")
+	for _, key := range keys {
+		series := h.series[key]
+		values := append(splitLabels(key, len(h.labels)), "")
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += series.counts[i]
+			values[len(values)-1] = formatFloat(bound)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(names, values), cumulative)
+		}
+		values[len(values)-1] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(names, values), series.count)
+		labels := labelString(h.labels, values[:len(values)-1])
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatFloat(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, series.count)
+	}
+}
+
+// Metrics of the process
+var (
+	rpcClientRequests = newCounterVec("chord_rpc_client_requests_total",
+		"RPC calls made by ChordCall, by method and result.", "method", "result")
+	rpcClientDuration = newHistogramVec("chord_rpc_client_duration_seconds",
+		"Duration of RPC calls made by ChordCall.", durationBuckets, "method")
+	rpcServerRequests = newCounterVec("chord_rpc_server_requests_total",
+		"RPC requests served, by method and result.", "method", "result")
+	rpcServerDuration = newHistogramVec("chord_rpc_server_duration_seconds",
+		"Duration of served RPC requests, from reading the request to writing the response.", durationBuckets, "method")
+	lookupRequests = newCounterVec("chord_lookup_total",
+		"Lookups made by find, by result.", "result")
+	lookupHops = newHistogramVec("chord_lookup_hops",
+		"FindSuccessorRPC steps taken by successful lookups.", hopBuckets)
+	lookupDuration = newHistogramVec("chord_lookup_duration_seconds",
+		"Duration of lookups made by find.", durationBuckets)
+	taskDuration = newHistogramVec("chord_task_duration_seconds",
+		"Duration of the periodic tasks (stabilize, fix_fingers, check_predecessor).", durationBuckets, "node", "task")
+	taskErrors = newCounterVec("chord_task_errors_total",
+		"Periodic task runs that returned an error.", "node", "task")
+	rpcRejected = newCounterVec("chord_rpc_rejected_total",
+		"Connections and requests rejected by the limits of the RPC server, by reason (connections, rate, file_size, message_size, memory).", "reason")
+)
+
+// resultLabel is the "result" label of a call returning err
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// methodLabel drops the service of a method, "Node/1.GetNameRPC" -> "GetNameRPC",
+// so that virtual nodes do not multiply the series
+func methodLabel(method string) string {
+	if dot := strings.LastIndex(method, "."); dot >= 0 {
+		return method[dot+1:]
+	}
+	return method
+}
+
+// observeTask runs one periodic task of node and records its duration, error and span
+func (node *Node) observeTask(task string, run func(ctx context.Context) error) {
+	ctx, span := StartSpan(context.Background(), task, SpanKindInternal, node.Name)
+	start := time.Now()
+	err := run(ctx)
+	taskDuration.observe(time.Since(start).Seconds(), node.Name, task)
+	if err != nil {
+		taskErrors.inc(node.Name, task)
+	}
+	span.Finish(err)
+}
+
+/*
+* @description: rpc.ServerCodec that records metrics and a server span for
+*				every served request. net/rpc reads a request header, then its
+*				body, and later writes the response with the same sequence
+*				number, possibly concurrently with other requests of the connection.
+ */
+type observedServerCodec struct {
+	rpc.ServerCodec
+	node    *Node // Node owning the listener, see serviceNodeName
+	mutex   sync.Mutex
+	last    uint64 // Sequence number of the request whose header was read last
+	pending map[uint64]*servedRequest
+}
+
+type servedRequest struct {
+	start time.Time
+	span  *Span
+	arg   interface{} // Key in requestSpans
+}
+
+func newObservedServerCodec(codec rpc.ServerCodec, node *Node) rpc.ServerCodec {
+	return &observedServerCodec{ServerCodec: codec, node: node, pending: make(map[uint64]*servedRequest)}
+}
+
+// serviceNodeName is the name of the node serving a method, "Node/1.X" is served by "<name>#1"
+func serviceNodeName(node *Node, serviceMethod string) string {
+	service := serviceMethod
+	if dot := strings.LastIndex(service, "."); dot >= 0 {
+		service = service[:dot]
+	}
+	if _, index, found := strings.Cut(service, "/"); found {
+		return node.Name + "#" + index
+	}
+	return node.Name
+}
+
+func (c *observedServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	err := c.ServerCodec.ReadRequestHeader(r)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if traced, ok := c.ServerCodec.(interface{ requestTrace() *SpanContext }); ok && traced.requestTrace() != nil {
+		ctx = ContextWithRemoteSpan(ctx, *traced.requestTrace())
+	}
+	_, span := StartSpan(ctx, methodLabel(r.ServiceMethod), SpanKindServer, serviceNodeName(c.node, r.ServiceMethod))
+	c.mutex.Lock()
+	c.last = r.Seq
+	c.pending[r.Seq] = &servedRequest{start: time.Now(), span: span}
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *observedServerCodec) ReadRequestBody(x interface{}) error {
+	err := c.ServerCodec.ReadRequestBody(x)
+	if err == nil && x != nil {
+		c.mutex.Lock()
+		request := c.pending[c.last]
+		if request != nil && request.span != nil {
+			request.arg = x
+			requestSpans.Store(x, request.span)
+		}
+		c.mutex.Unlock()
+	}
+	return err
+}
+
+func (c *observedServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.mutex.Lock()
+	request, ok := c.pending[r.Seq]
+	delete(c.pending, r.Seq)
+	c.mutex.Unlock()
+	if ok {
+		method := methodLabel(r.ServiceMethod)
+		result := "ok"
+		var err error
+		if r.Error != "" {
+			result = "error"
+			err = errors.New(r.Error)
+		}
+		rpcServerRequests.inc(method, result)
+		rpcServerDuration.observe(time.Since(request.start).Seconds(), method)
+		if request.arg != nil {
+			requestSpans.Delete(request.arg)
+		}
+		request.span.Finish(err)
+	}
+	return c.ServerCodec.WriteResponse(r, body)
+}
+
+// storeSize sums the sizes of all objects of a store
+func storeSize(store Store) int64 {
+	var total int64
+	all := new(big.Int)
+	store.Range(all, all, func(id *big.Int, name string) bool {
+		size, err := store.Size(name)
+		if err == nil {
+			total += size
+		}
+		return true
+	})
+	return total
+}
+
+/*
+* @description: Write the metrics of the process in the Prometheus text format,
+*				followed by the bucket and backup sizes of node and its virtual nodes
+ */
+func (node *Node) WriteMetrics(w io.Writer) {
+	metrics.write(w)
+	type gauge struct {
+		name string
+		help string
+		get  func(local *Node) float64
+	}
+	gauges := []gauge{
+		{"chord_bucket_objects", "Objects in the bucket of a node.", func(local *Node) float64 { return float64(local.Bucket.Len()) }},
+		{"chord_bucket_bytes", "Bytes stored in the bucket of a node.", func(local *Node) float64 { return float64(storeSize(local.Bucket)) }},
+		{"chord_backup_objects", "Objects in the backup of a node.", func(local *Node) float64 { return float64(local.Backup.Len()) }},
+		{"chord_backup_bytes", "Bytes stored in the backup of a node.", func(local *Node) float64 { return float64(storeSize(local.Backup)) }},
+	}
+	for _, g := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+		for _, local := range node.LocalNodes() {
+			fmt.Fprintf(w, "%s%s %s\n", g.name, labelString([]string{"node"}, []string{local.Name}), formatFloat(g.get(local)))
+		}
+	}
+}
+
+// MetricsHandler serves node.WriteMetrics, e.g. on /metrics
+func MetricsHandler(node *Node) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, http.MethodGet)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		node.WriteMetrics(w)
+	})
+}
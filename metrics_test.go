@@ -0,0 +1,113 @@
+package chord
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsTextFormat(t *testing.T) {
+	counter := &counterVec{name: "test_total", help: "Test counter.", labels: []string{"method"}, values: make(map[string]float64)}
+	counter.inc("b")
+	counter.inc("a\"\n")
+	counter.inc("b")
+	var out bytes.Buffer
+	counter.write(&out)
+	want := "# HELP test_total Test counter.\n# TYPE test_total counter\n" +
+		"test_total{method=\"a\\\"\\n\"} 1\n" +
+		"test_total{method=\"b\"} 2\n"
+	if out.String() != want {
+		t.Fatalf("counter:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	histogram := &histogramVec{name: "test_seconds", help: "Test histogram.", buckets: []float64{1, 2}, series: make(map[string]*histogram)}
+	histogram.observe(0.5)
+	histogram.observe(1.5)
+	histogram.observe(3)
+	out.Reset()
+	histogram.write(&out)
+	want = "# HELP test_seconds Test histogram.\n# TYPE test_seconds histogram\n" +
+		"test_seconds_bucket{le=\"1\"} 1\n" +
+		"test_seconds_bucket{le=\"2\"} 2\n" +
+		"test_seconds_bucket{le=\"+Inf\"} 3\n" +
+		"test_seconds_sum 5\n" +
+		"test_seconds_count 3\n"
+	if out.String() != want {
+		t.Fatalf("histogram:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+// scrapeMetrics reads the series of a /metrics endpoint, by name and labels
+func scrapeMetrics(t *testing.T, url string) map[string]float64 {
+	t.Helper()
+	status, body := gatewayRequest(t, url, http.MethodGet, "/metrics", "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("GET /metrics = %d", status)
+	}
+	series := make(map[string]float64)
+	for _, line := range strings.Split(body, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		space := strings.LastIndex(line, " ")
+		value, err := strconv.ParseFloat(line[space+1:], 64)
+		if err != nil {
+			t.Fatalf("series %q: %v", line, err)
+		}
+		series[line[:space]] = value
+	}
+	return series
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	// The periodic tasks would add calls of their own
+	node := startTestNode(t, Config{Stabilize: time.Minute, FixFingers: time.Minute, CheckPredecessor: time.Minute})
+	server := httptest.NewServer(MetricsHandler(node))
+	defer server.Close()
+	before := scrapeMetrics(t, server.URL)
+
+	if err := NewClient(node).Put(context.Background(), "key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	node.observeTask("test_task", func(ctx context.Context) error {
+		return errors.New("failed")
+	})
+	after := scrapeMetrics(t, server.URL)
+
+	counters := []string{
+		`chord_rpc_client_requests_total{method="PutFileRPC",result="ok"}`,
+		`chord_rpc_server_requests_total{method="PutFileRPC",result="ok"}`,
+		`chord_rpc_client_duration_seconds_count{method="PutFileRPC"}`,
+		`chord_rpc_server_duration_seconds_count{method="PutFileRPC"}`,
+		`chord_task_duration_seconds_count{node="` + node.Name + `",task="test_task"}`,
+		`chord_task_errors_total{node="` + node.Name + `",task="test_task"}`,
+	}
+	for _, name := range counters {
+		if after[name] != before[name]+1 {
+			t.Errorf("%s went from %v to %v, want one more", name, before[name], after[name])
+		}
+	}
+	if after[`chord_lookup_total{result="ok"}`] <= before[`chord_lookup_total{result="ok"}`] {
+		t.Errorf("lookup of the key not counted")
+	}
+	gauges := map[string]float64{
+		`chord_bucket_objects{node="` + node.Name + `"}`: 1,
+		`chord_bucket_bytes{node="` + node.Name + `"}`:   5,
+		`chord_backup_objects{node="` + node.Name + `"}`: 0,
+	}
+	for name, want := range gauges {
+		if value, ok := after[name]; !ok || value != want {
+			t.Errorf("%s = %v, want %v", name, value, want)
+		}
+	}
+
+	if status, _ := gatewayRequest(t, server.URL, http.MethodPost, "/metrics", "", nil); status != http.StatusMethodNotAllowed {
+		t.Fatalf("POST /metrics = %d, want 405", status)
+	}
+}
@@ -1,15 +1,24 @@
 package chord
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/big"
+	"net"
+	"net/http"
+	"net/rpc"
 	"os"
+	"path/filepath"
+	"regexp"
 	"sync"
 	"time"
 )
@@ -30,6 +39,12 @@ var hashMod = new(big.Int).Exp(big.NewInt(2), big.NewInt(int64(m)), nil)
 
 type Key string // For file
 
+// Store modes, how the key of an uploaded file is chosen
+const (
+	StoreModeName    = "name"    // Key is the file name
+	StoreModeContent = "content" // Key is the hex SHA-256 of the content
+)
+
 type NodeAddress string // For node
 
 // FileAddress: [K]13 store in [N]14
@@ -42,14 +57,16 @@ type fingerEntry struct {
 
 type ScheduledExecutor struct {
 	Delay  time.Duration
-	Ticker time.Ticker
+	Ticker *time.Ticker
 	Quit   chan int
+	tasks  sync.WaitGroup // The run in progress, see Wait
 }
 
 type Node struct {
 	// Node attributes
 	Name       string   // Name: IP:Port or User specified Name. Exp: [N]14
 	Identifier *big.Int // Hash(Address) -> Chord space Identifier
+	DataDir    string   // Root folder of all node folders
 
 	// For Chord search
 	Address     NodeAddress // Address should be "IP:Port"
@@ -59,16 +76,56 @@ type Node struct {
 	// For Chord stabilization
 	Predecessor NodeAddress
 	Successors  []NodeAddress // Multiple successors to handle first succesor node failures
-	mutex       sync.Mutex
+	mutex       sync.Mutex    // Guards FingerTable, Predecessor and Successors, never held across an RPC
 
 	// For Chord data encryption
 	PrivateKey  *rsa.PrivateKey
 	PublicKey   *rsa.PublicKey
 	EncryptFlag bool
 
-	// Create bucket in form of map
-	Bucket map[*big.Int]string
-	Backup map[*big.Int]string
+	// Store mode of the ring, StoreModeName or StoreModeContent
+	StoreMode string
+
+	// Optional protocol features offered to peers, see protocol.go
+	features []string
+	hellos   *helloNonces // Nonces of the hellos being said, shared with the virtual nodes
+
+	// Format of the calls the node dials, see Config.Wire
+	wire string
+
+	// Limits of the RPC server, see limits.go
+	limits serverLimits
+
+	// Secrets the calls changing the node are checked with and its own calls signed with, see auth.go
+	secrets ringSecrets
+	nonces  *nonceCache // Nonces of the tokens taken, shared with the virtual nodes
+
+	// Storage backends for own files and predecessor's backup
+	Bucket Store
+	Backup Store
+	meta   *MetaLog // Metadata log of the file backend, nil for memory storage
+
+	// Logger with the node name attached, see log.go
+	Logger Logger
+
+	// Virtual nodes hosted by this process, see newVirtualNode
+	VNodes []*Node
+
+	// RPC server of the node and its virtual nodes, see server.go
+	rpcServer *rpc.Server
+	listener  net.Listener
+	connMutex sync.Mutex
+	conns     map[net.Conn]struct{} // Connections being served
+	stopped   bool
+	serving   sync.WaitGroup // Accept loop and connections
+
+	// Optional HTTP gateway and metrics endpoint, nil if disabled
+	httpServer    *http.Server
+	metricsServer *http.Server
+	httpToken     string // Bearer token of the mutating gateway requests, see Gateway
+
+	// Span exporter opened by Start, nil if tracing is disabled
+	traceExporter SpanExporter
 
 	// For periodic stabilization
 	Se_stab *ScheduledExecutor
@@ -76,12 +133,12 @@ type Node struct {
 	Se_cp   *ScheduledExecutor
 }
 
-func (node *Node) generateRSAKey(bits int) {
+func (node *Node) generateRSAKey(bits int) error {
 	// GenerateKey函数使用随机数据生成器random生成一对具有指定字位数的RSA密钥
 	// Reader是一个全局、共享的密码用强随机数生成器
 	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	node.PrivateKey = privateKey
 	node.PublicKey = &privateKey.PublicKey
@@ -95,18 +152,20 @@ func (node *Node) generateRSAKey(bits int) {
 
 		Bytes: priDerText,
 	}
-	node_files_folder := "./tmp/" + node.Name
-	privateHandler, err := os.Create(node_files_folder + "/private.pem")
+	privateHandler, err := os.Create(node.path("private.pem"))
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer privateHandler.Close()
-	pem.Encode(privateHandler, &block)
+	err = pem.Encode(privateHandler, &block)
+	if err != nil {
+		return err
+	}
 
 	// Store public key in Node folder
 	pubDerText, err := x509.MarshalPKIXPublicKey(node.PublicKey)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	block = pem.Block{
 		Type: node.Name + "-public Key",
@@ -115,113 +174,159 @@ func (node *Node) generateRSAKey(bits int) {
 
 		Bytes: pubDerText,
 	}
-	publicHandler, err := os.Create(node_files_folder + "/public.pem")
+	publicHandler, err := os.Create(node.path("public.pem"))
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer publicHandler.Close()
-	pem.Encode(publicHandler, &block)
+	return pem.Encode(publicHandler, &block)
+}
+
+// loadRSAKey reads the key pair written by generateRSAKey
+func (node *Node) loadRSAKey() error {
+	privateKeyBuffer, err := ioutil.ReadFile(node.path("private.pem"))
+	if err != nil {
+		return err
+	}
+	priBlock, _ := pem.Decode(privateKeyBuffer)
+	if priBlock == nil {
+		return fmt.Errorf("%s holds no PEM block", node.path("private.pem"))
+	}
+	privateKey, err := x509.ParsePKCS1PrivateKey(priBlock.Bytes)
+	if err != nil {
+		return err
+	}
+	node.PrivateKey = privateKey
+	node.PublicKey = &node.PrivateKey.PublicKey
+	return nil
 }
 
-func NewNode(args Arguments) *Node {
+/*
+* @description: Create a node from cfg: resolve its address, create its folder,
+*				generate or load its keys and open its stores. The node is not
+*				served and not part of a ring yet, see Start.
+ */
+func NewNode(cfg Config) (*Node, error) {
+	cfg = cfg.withDefaults()
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
 	// Create a new node
 	node := &Node{}
 	var localAddress string
-	if args.Address == "localhost" || args.Address == "127.0.0.1" {
-		localAddress = string(args.Address)
-	} else if args.Address == "0.0.0.0" {
-		localAddress = Getip2()
+	if cfg.Address == "localhost" || cfg.Address == "127.0.0.1" {
+		localAddress = cfg.Address
+	} else if cfg.Address == "0.0.0.0" {
+		localAddress, err = Getip2()
 	} else {
-		localAddress = GetLocalAddress()
+		localAddress, err = GetLocalAddress()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("resolve node address: %w", err)
 	}
-	node.Address = NodeAddress(fmt.Sprintf("%s:%d", localAddress, args.Port))
-	fmt.Println("Node address: ", node.Address)
-	if args.ClientName == "Default" {
+	node.Address = NodeAddress(fmt.Sprintf("%s:%d", localAddress, cfg.Port))
+	if cfg.Name == "" {
 		node.Name = string(node.Address)
 	} else {
-		node.Name = args.ClientName
+		node.Name = cfg.Name
 	}
+	node.Logger = cfg.Logger.With(F(FieldNode, node.Name))
+	node.logger().Info("Node address", F("address", node.Address))
 	node.Identifier = StrHash(string(node.Name))
 	node.Identifier.Mod(node.Identifier, hashMod)
 	node.FingerTable = make([]fingerEntry, fingerTableSize+1)
-	node.Bucket = make(map[*big.Int]string)
-	node.Backup = make(map[*big.Int]string)
 	node.next = 0 // start from -1, then use fixFingers() to add 1 -> 0 max: m-1
 	node.Predecessor = ""
-	node.Successors = make([]NodeAddress, args.Successors)
+	node.Successors = make([]NodeAddress, cfg.Successors)
 	node.EncryptFlag = false
+	node.StoreMode = cfg.StoreMode
+	node.features = cfg.Features
+	node.hellos = newHelloNonces()
+	node.wire = cfg.Wire
+	node.secrets = ringSecrets{ring: cfg.Ring, secret: cfg.Secret, admin: cfg.AdminSecret}
+	node.httpToken = cfg.HTTPToken
+	node.nonces = newNonceCache()
+	node.limits = newServerLimits(cfg)
 	node.InitFingerTable()
 	node.InitSuccessors()
 
-	currentDir, err := os.Getwd()
-	if err != nil {
-		fmt.Println(err)
-	}
-	// Create temp file folder in current directory
-	tempErr := os.MkdirAll(currentDir+"/tmp", os.ModePerm)
-	if tempErr != nil {
-		fmt.Println("Create temp file folder failed: " + tempErr.Error())
-		// os.IsNotExist(tempErr)
-	}
-	// Check if ./tmp folder exist
+	node.DataDir = cfg.DataDir
 
-	if _, err := os.Stat(currentDir + "/tmp" + node.Name); os.IsNotExist(err) {
-		err := os.MkdirAll(currentDir+"/tmp/"+node.Name, os.ModePerm)
+	// Create Node folder and its file_upload and file_download folders
+	for _, folder := range []string{"file_upload", "file_download"} {
+		err := os.MkdirAll(node.path(folder), os.ModePerm)
 		if err != nil {
-			fmt.Println("Create Node folder failed: " + err.Error())
-		} else {
-			// Create file_upload folder in Node folder
-			if _, err := os.Stat(currentDir + "/tmp/" + node.Name + "/file_upload"); os.IsNotExist(err) {
-				os.Mkdir("./tmp/"+node.Name+"/file_upload", os.ModePerm)
-			} else {
-				fmt.Println("file_upload folder already exist")
-			}
-			// Create file_download folder in Node folder
-			if _, err := os.Stat(currentDir + "/tmp/" + node.Name + "/file_download"); os.IsNotExist(err) {
-				os.Mkdir("./tmp/"+node.Name+"/file_download", 0777)
-			} else {
-				fmt.Println("file_download folder already exist")
-			}
-			// Create chord_storage folder in Node folder
-			if _, err := os.Stat(currentDir + "/tmp/" + node.Name + "/chord_storage"); os.IsNotExist(err) {
-				os.Mkdir(currentDir+"/tmp/"+node.Name+"/chord_storage", 0777)
-			} else {
-				fmt.Println("chord_storage folder already exist")
-			}
+			return nil, err
 		}
-		node.generateRSAKey(2048)
-	} else {
-		fmt.Println("Node folder already exist")
-		// Init bucket
-		// Read all files in chord_storage folder
-		files, err := ioutil.ReadDir(currentDir + "/tmp/" + node.Name + "/chord_storage")
+	}
+
+	if _, err := os.Stat(node.path("private.pem")); os.IsNotExist(err) {
+		err = node.generateRSAKey(2048)
 		if err != nil {
-			fmt.Println("Read chord_storage folder failed")
-		}
-		for _, file := range files {
-			// Store file name in bucket
-			fileName := file.Name()
-			fileHash := StrHash(fileName)
-			fileHash.Mod(fileHash, hashMod)
-			node.Bucket[fileHash] = fileName
+			return nil, fmt.Errorf("generate keys: %w", err)
 		}
+	} else {
+		node.logger().Info("Node folder already exist")
 		// Init private key
-		privateHandler, err := os.Open("./tmp/" + node.Name + "/private.pem")
-		if err != nil {
-			panic(err)
-		}
-		defer privateHandler.Close()
-		privateKeyBuffer, _ := ioutil.ReadAll(privateHandler)
-		priBlock, _ := pem.Decode(privateKeyBuffer)
-		privateKey, err := x509.ParsePKCS1PrivateKey(priBlock.Bytes)
+		err = node.loadRSAKey()
 		if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("load keys: %w", err)
 		}
-		node.PrivateKey = privateKey
-		node.PublicKey = &node.PrivateKey.PublicKey
 	}
 
-	return node
+	// Init bucket and backup, file stores recover their objects from the metadata log
+	node.Bucket, node.Backup, node.meta, err = newStores(cfg.Storage, node.path())
+	if err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// Characters kept as-is in a node folder name, everything else becomes '_'
+var unsafeFolderChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+/*
+* @description: Map a node name to a folder name that is safe on every platform,
+*				e.g. "localhost:8000" -> "localhost_8000-<hash>". Names that had to
+*				be changed get a short hash suffix so they cannot collide.
+ */
+func folderName(name string) string {
+	folder := unsafeFolderChars.ReplaceAllString(name, "_")
+	if folder == name && folder != "" && folder != "." && folder != ".." {
+		return folder
+	}
+	return fmt.Sprintf("%s-%x", folder, sha1.Sum([]byte(name)))[:len(folder)+9]
+}
+
+/*
+* @description: Path of a file or folder inside the node folder
+* @param: 		elem: path elements below the node folder, none for the folder itself
+* @return: 		<DataDir>/<folderName(Name)>/elem...
+ */
+func (node *Node) path(elem ...string) string {
+	return filepath.Join(append([]string{node.DataDir, folderName(node.Name)}, elem...)...)
+}
+
+// getFinger returns a copy of the ith finger table entry
+func (node *Node) getFinger(i int) fingerEntry {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	return node.FingerTable[i]
+}
+
+func (node *Node) setFinger(i int, entry fingerEntry) {
+	node.mutex.Lock()
+	node.FingerTable[i] = entry
+	node.mutex.Unlock()
+}
+
+// getFingerTable returns a copy of the finger table
+func (node *Node) getFingerTable() []fingerEntry {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	return append([]fingerEntry(nil), node.FingerTable...)
 }
 
 /*
@@ -230,9 +335,11 @@ func NewNode(args Arguments) *Node {
  */
 func (node *Node) InitFingerTable() {
 	// Initialize finger table
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
 	node.FingerTable[0].Id = node.Identifier.Bytes()
 	node.FingerTable[0].Address = node.Address
-	fmt.Println("fingerTable[0] = ", node.FingerTable[0].Id, node.FingerTable[0].Address)
+	node.logger().Debug("Init finger table", F("id", node.Identifier), F(FieldPeer, node.FingerTable[0].Address))
 	for i := 1; i < fingerTableSize+1; i++ {
 		// Caculate the id of the ith finger
 		// id = (n + 2^i-1) mod (2^m)
@@ -247,6 +354,8 @@ func (node *Node) InitFingerTable() {
 
 func (node *Node) InitSuccessors() {
 	// Initialize successors
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
 	successorsSize := len(node.Successors)
 	for i := 0; i < successorsSize; i++ {
 		node.Successors[i] = ""
@@ -254,24 +363,30 @@ func (node *Node) InitSuccessors() {
 }
 
 func (node *Node) JoinChord(joinNode NodeAddress) error {
+	return node.joinChord(context.Background(), joinNode)
+}
+
+func (node *Node) joinChord(ctx context.Context, joinNode NodeAddress) error {
 	// Find the successor of the node's identifier
 	// Set the node's predecessor to nil and successors to the exits node
 	// joinNode is the successor of current node, which is node.Successors[0]
 	// current node will be the predecessor of joinNode
-	node.Predecessor = ""
-	fmt.Printf("Node %s join the Chord ring: %s \n", node.Name, joinNode)
+	node.setPredecessor("")
+	node.logger().Info("Join the Chord ring", F(FieldPeer, joinNode))
+	ctx = contextWithNode(ctx, node)
 
 	//  Join node is in charge of looking for the successor of the node's identifier
-	// 1. Call the joinNode's findSuccessor() to find the successor of the node's identifier
+	// 1. Call the joinNode's findSuccessor() to find the successor of the node's identifier.
+	// The connection says hello first, an incompatible ring fails the call with ErrIncompatible (see sayHello)
 	var reply FindSuccessorRPCReply
-	err := ChordCall(joinNode, "Node.FindSuccessorRPC", node.Identifier, &reply)
-	fmt.Println("Successor: ", reply.SuccessorAddress)
-	node.Successors[0] = reply.SuccessorAddress
+	err := ChordCallContext(ctx, joinNode, "Node.FindSuccessorRPC", node.Identifier, &reply)
+	node.logger().Info("Found successor", F(FieldPeer, reply.SuccessorAddress))
+	node.setSuccessor(reply.SuccessorAddress)
 	if err != nil {
 		return err
 	}
 	// 2. Call the successor's notify() to notify the successor that the node is its predecessor
-	err = ChordCall(node.Successors[0], "Node.NotifyRPC", node.Address, &reply)
+	err = ChordCallContext(ctx, reply.SuccessorAddress, "Node.NotifyRPC", node.Address, &NotifyRPCReply{})
 	if err != nil {
 		return err
 	}
@@ -281,6 +396,8 @@ func (node *Node) JoinChord(joinNode NodeAddress) error {
 func (node *Node) CreateChord() {
 	// Create a new Chord ring
 	// Set the node's predecessor to nil and successors to itself
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
 	node.Predecessor = ""
 	// All successors are itself when create a new Chord ring
 	for i := 0; i < len(node.Successors); i++ {
@@ -288,56 +405,211 @@ func (node *Node) CreateChord() {
 	}
 }
 
+// FingerState is a finger table entry in NodeState
+type FingerState struct {
+	Index   int
+	Id      *big.Int
+	Address NodeAddress
+}
+
+// ObjectState is a bucket or backup entry in NodeState
+type ObjectState struct {
+	Id   *big.Int
+	Name string
+	Size int64 // Content size in bytes
+}
+
+// NodeLoad summarizes how much of the ring and its data a node is responsible for
+type NodeLoad struct {
+	Keys          int     // Number of objects in the bucket
+	Bytes         int64   // Size of the objects in the bucket
+	BackupKeys    int     // Number of objects in the backup
+	BackupBytes   int64   // Size of the objects in the backup
+	OwnedFraction float64 // Fraction of the ID space in (predecessor, node], 0 if unknown
+}
+
+// NodeState is a snapshot of the node's position in the ring and its storage
+type NodeState struct {
+	Name        string
+	Address     NodeAddress
+	Identifier  *big.Int
+	Predecessor NodeAddress
+	Successors  []NodeAddress
+	Fingers     []FingerState
+	Bucket      []ObjectState
+	Backup      []ObjectState
+	Load        NodeLoad
+}
+
+// ownedFraction returns the fraction of the ID space in (start, end], a full circle if start == end
+func ownedFraction(start, end *big.Int) float64 {
+	arc := new(big.Int).Sub(end, start)
+	arc.Mod(arc, hashMod)
+	if arc.Sign() == 0 {
+		arc.Set(hashMod)
+	}
+	fraction, _ := new(big.Rat).SetFrac(arc, hashMod).Float64()
+	return fraction
+}
+
+// How long State waits for the name of the predecessor, which may be down
+const stateCallTimeout = time.Second
+
+/*
+* @description: Sum up the objects in the state and the ID space owned by the
+*				node. The owned fraction needs the name of the predecessor and is
+*				left 0 if it does not answer within stateCallTimeout.
+ */
+func (node *Node) load(state NodeState) NodeLoad {
+	load := NodeLoad{Keys: len(state.Bucket), BackupKeys: len(state.Backup)}
+	for _, object := range state.Bucket {
+		load.Bytes += object.Size
+	}
+	for _, object := range state.Backup {
+		load.BackupBytes += object.Size
+	}
+	if state.Predecessor != "" {
+		var reply GetNameRPCReply
+		ctx, cancel := context.WithTimeout(contextWithNode(context.Background(), node), stateCallTimeout)
+		err := ChordCallContext(ctx, state.Predecessor, "Node.GetNameRPC", "", &reply)
+		cancel()
+		if err == nil {
+			predecessorId := StrHash(reply.Name)
+			predecessorId.Mod(predecessorId, hashMod)
+			load.OwnedFraction = ownedFraction(predecessorId, node.Identifier)
+		}
+	}
+	return load
+}
+
+// objectStates lists the objects of a store with their sizes
+func objectStates(store Store, all *big.Int) []ObjectState {
+	objects := make([]ObjectState, 0, store.Len())
+	store.Range(all, all, func(id *big.Int, name string) bool {
+		size, err := store.Size(name)
+		if err != nil {
+			size = -1
+		}
+		objects = append(objects, ObjectState{Id: id, Name: name, Size: size})
+		return true
+	})
+	return objects
+}
+
+// Take a snapshot of the current node state
+func (node *Node) State() NodeState {
+	state := NodeState{
+		Name:        node.Name,
+		Address:     node.Address,
+		Identifier:  new(big.Int).Set(node.Identifier),
+		Predecessor: node.getPredecessor(),
+		Successors:  node.getSuccessorList(),
+		Fingers:     make([]FingerState, 0, fingerTableSize),
+		Bucket:      objectStates(node.Bucket, node.Identifier),
+		Backup:      objectStates(node.Backup, node.Identifier),
+	}
+	fingerTable := node.getFingerTable()
+	for i := 1; i < fingerTableSize+1; i++ {
+		entry := fingerTable[i]
+		state.Fingers = append(state.Fingers, FingerState{Index: i, Id: new(big.Int).SetBytes(entry.Id), Address: entry.Address})
+	}
+	state.Load = node.load(state)
+	return state
+}
+
 func (node *Node) PrintState() {
 	// Print current node state
+	state := node.State()
 	fmt.Println("-------------- Current Node State ------------")
-	fmt.Println("Node Name: ", node.Name)
-	fmt.Println("Node Address: ", node.Address)
-	fmt.Println("Node Identifier: ", new(big.Int).SetBytes(node.Identifier.Bytes()))
-	fmt.Println("Node Predecessor: ", node.Predecessor)
+	fmt.Println("Node Name: ", state.Name)
+	fmt.Println("Node Address: ", state.Address)
+	fmt.Println("Node Identifier: ", state.Identifier)
+	fmt.Println("Node Predecessor: ", state.Predecessor)
 	fmt.Println("Node Successors: ")
-	for i := 0; i < len(node.Successors); i++ {
-		fmt.Println("Successor ", i, " address: ", node.Successors[i])
+	for i, successor := range state.Successors {
+		fmt.Println("Successor ", i, " address: ", successor)
 	}
 	fmt.Println("Node Finger Table: ")
-	for i := 1; i < fingerTableSize+1; i++ {
-		enrty := node.FingerTable[i]
-		id := new(big.Int).SetBytes(enrty.Id)
-		address := enrty.Address
-		fmt.Println("Finger ", i, " id: ", id, ", address: ", address)
+	for _, finger := range state.Fingers {
+		fmt.Println("Finger ", finger.Index, " id: ", finger.Id, ", address: ", finger.Address)
 	}
 	fmt.Println("Node Bucket: ")
-	for k, v := range node.Bucket {
-		fmt.Println("Key: ", k, ", Value: ", v)
+	for _, object := range state.Bucket {
+		fmt.Println("Key: ", object.Id, ", Value: ", object.Name, ", Size: ", object.Size)
 	}
 	fmt.Println("Node Backup:")
-	for k, v := range node.Backup {
-		fmt.Println("Key: ", k, ", Value: ", v)
+	for _, object := range state.Backup {
+		fmt.Println("Key: ", object.Id, ", Value: ", object.Name, ", Size: ", object.Size)
+	}
+	fmt.Println("Node Load: ", state.Load.Keys, " keys, ", state.Load.Bytes, " bytes, owns ", state.Load.OwnedFraction*100, "% of the ring")
+	if len(node.VNodes) > 0 {
+		fmt.Println("Node Virtual Nodes: ")
+		for _, vnode := range node.VNodes {
+			fmt.Println("Name: ", vnode.Name, ", address: ", vnode.Address, ", identifier: ", vnode.Identifier)
+		}
 	}
 
 }
 
+// Print current node state as indented JSON, for scripts and test harnesses
+func (node *Node) PrintStateJSON() error {
+	data, err := json.MarshalIndent(node.State(), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
 /*------------------------------------------------------------*/
 /*                    RPC functions Below                     */
 /*------------------------------------------------------------*/
 
+// -------------------------- GetStateRPC ----------------------------------//
+
+// Get a snapshot of the node state, see NodeState
+func (node *Node) GetStateRPC(fakeRequest string, reply *NodeState) error {
+	*reply = node.State()
+	return nil
+}
+
+// -------------------------- GetStoreModeRPC ----------------------------------//
+type GetStoreModeRPCReply struct {
+	StoreMode string
+}
+
+// Get the store mode of the ring, used by clients to choose object keys
+func (node *Node) GetStoreModeRPC(fakeRequest string, reply *GetStoreModeRPCReply) error {
+	reply.StoreMode = node.StoreMode
+	return nil
+}
+
 type SetPredecessorRPCReply struct {
 	Success bool
 }
 
 func (node *Node) setPredecessor(predecessorAddress NodeAddress) bool {
+	node.mutex.Lock()
 	node.Predecessor = predecessorAddress
+	node.mutex.Unlock()
 	flag := true
 	return flag
 }
 
+// clearPredecessor forgets a failed predecessor, unless another one was set meanwhile
+func (node *Node) clearPredecessor(failed NodeAddress) {
+	node.mutex.Lock()
+	if node.Predecessor == failed {
+		node.Predecessor = ""
+	}
+	node.mutex.Unlock()
+}
+
 func (node *Node) SetPredecessorRPC(predecessorAddress NodeAddress, reply *SetPredecessorRPCReply) error {
-	fmt.Println("-------------- Invoke SetPredecessorRPC function ------------")
+	node.logger().Debug("Invoke SetPredecessorRPC", F(FieldMethod, "SetPredecessorRPC"), F(FieldPeer, predecessorAddress))
 	reply.Success = node.setPredecessor(predecessorAddress)
-	if reply.Success {
-		fmt.Println("Set predecessor success")
-	} else {
-		fmt.Println("Set predecessor failed")
+	if !reply.Success {
+		node.logger().Warn("Set predecessor failed", F(FieldPeer, predecessorAddress))
 		return errors.New("set predecessor failed")
 	}
 	return nil
@@ -346,64 +618,70 @@ func (node *Node) SetPredecessorRPC(predecessorAddress NodeAddress, reply *SetPr
 func (node *Node) storeChordFile(f FileRPC, backup bool) bool {
 	// Store the file in the bucket
 	// Return true if success, false if failed
-	// Append the file to the bucket
 	f.Id.Mod(f.Id, hashMod)
-	// Check if the file is already in the bucket
-
+	if err := node.checkFile(f); err != nil {
+		node.logger().Warn("Reject file", F("file", f.Name), Err(err))
+		return false
+	}
+	store := node.Bucket
+	storeName := "Bucket"
 	if backup {
-		for k, _ := range node.Backup {
-			if k.Cmp(f.Id) == 0 {
-				fmt.Println("File already in the Backup")
-				return false
-			}
+		store = node.Backup
+		storeName = "Backup"
+	}
+	// Check if the file is already in the store
+	if store.Contains(f.Name) {
+		content, err := store.Get(f.Name)
+		if err == nil && bytes.Equal(content, f.Content) {
+			// Same key and content, e.g. a move retried after its reply was lost, nothing to store
+			node.logger().Debug("File already stored, deduplicated", F("store", storeName), F("file", f.Name))
+			return true
 		}
-		node.Backup[f.Id] = f.Name
-		fmt.Println("Store Backup: ", node.Backup)
-	} else {
-		for k, _ := range node.Bucket {
-			if k.Cmp(f.Id) == 0 {
-				fmt.Println("File already in the Bucket")
-				return false
-			}
-		}
-		node.Bucket[f.Id] = f.Name
-		fmt.Println("Store Bucket: ", node.Bucket)
-	}
-	currentNodeFileDownloadPath := "./tmp/" + node.Name + "/chord_storage/"
-	filepath := currentNodeFileDownloadPath + f.Name
-	// Create the file on file path and store content
-	file, err := os.Create(filepath)
-	if err != nil {
-		fmt.Println("Create file failed")
+		node.logger().Warn("File already stored", F("store", storeName), F("file", f.Name))
 		return false
 	}
-	defer file.Close()
-	_, err = file.Write(f.Content)
+	err := store.Put(f.Id, f.Name, f.Content)
 	if err != nil {
-		fmt.Println("Write file failed")
+		node.logger().Error("Store file failed", F("store", storeName), F("file", f.Name), Err(err))
 		return false
 	}
-	// Store the file in the file download folder
+	node.logger().Debug("Stored file", F("store", storeName), F("id", f.Id), F("file", f.Name))
 	return true
 }
 
+// checkFile verifies the checksum of an uploaded file, and its key in the content store mode
+func (node *Node) checkFile(f FileRPC) error {
+	err := f.verify()
+	if err != nil {
+		return err
+	}
+	if node.StoreMode == StoreModeContent && f.Name != f.Checksum {
+		return fmt.Errorf("%w: key is not the content hash: %s", ErrCorrupt, f.Name)
+	}
+	return nil
+}
+
 func (node *Node) storeLocalFile(f FileRPC) bool {
 	// Store the file in the bucket
 	// Return true if success, false if failed
 	// Append the file to the bucket
 	f.Id.Mod(f.Id, hashMod)
-	currentNodeFileDownloadPath := "./tmp/" + node.Name + "/file_download/"
-	filepath := currentNodeFileDownloadPath + f.Name
+	// The name comes from a remote node, never let it escape file_download
+	if err := ValidateName(f.Name); err != nil {
+		node.logger().Warn("Reject file", Err(err))
+		return false
+	}
+	filepath := node.path("file_download", f.Name)
 	// Create the file on file path and store content
 	file, err := os.Create(filepath)
 	if err != nil {
-		fmt.Println("Create file failed")
+		node.logger().Error("Create file failed", F("file", f.Name), Err(err))
 		return false
 	}
 	defer file.Close()
 	_, err = file.Write(f.Content)
 	if err != nil {
-		fmt.Println("Write file failed")
+		node.logger().Error("Write file failed", F("file", f.Name), Err(err))
 		return false
 	}
 	// Store the file in the file download folder
@@ -412,18 +690,80 @@ func (node *Node) storeLocalFile(f FileRPC) bool {
 
 type StoreFileRPCReply struct {
 	Success bool
-	Err     error
+	Err     string // Reason of the failure, empty on success
 	Backup  bool
 }
 
 func (node *Node) StoreFileRPC(f FileRPC, reply *StoreFileRPCReply) error {
-	fmt.Println("-------------- Invoke StoreFileRPC function ------------")
+	node.logger().Debug("Invoke StoreFileRPC", F(FieldMethod, "StoreFileRPC"), F("file", f.Name))
+	err := ValidateName(f.Name)
+	if err != nil {
+		node.logger().Warn("Reject file", F(FieldMethod, "StoreFileRPC"), Err(err))
+		return err
+	}
 	reply.Success = node.storeChordFile(f, reply.Backup)
 	if !reply.Success {
-		reply.Err = errors.New("store file failed")
+		reply.Err = "store file failed"
 	} else {
-		reply.Err = nil
+		reply.Err = ""
+	}
+	return nil
+}
+
+/*
+* @description: RPC method storing a file in the bucket like StoreFileRPC, but
+*				an existing file with the same name is overwritten
+ */
+func (node *Node) PutFileRPC(f FileRPC, reply *StoreFileRPCReply) error {
+	err := ValidateName(f.Name)
+	if err != nil {
+		return err
+	}
+	f.Id.Mod(f.Id, hashMod)
+	err = node.checkFile(f)
+	if err != nil {
+		return err
+	}
+	err = node.Bucket.Put(f.Id, f.Name, f.Content)
+	if err != nil {
+		return err
+	}
+	reply.Success = true
+	return nil
+}
+
+type DeleteFileRPCReply struct {
+	Success bool
+}
+
+/*
+* @description: RPC method deleting a file from the bucket, the backup on
+*				successor[0] is deleted too so the file cannot come back when
+*				this node fails
+* @return: 		ErrNotFound if no file with this name is stored
+ */
+func (node *Node) DeleteFileRPC(f FileRPC, reply *DeleteFileRPCReply) error {
+	err := ValidateName(f.Name)
+	if err != nil {
+		return err
+	}
+	f.Id.Mod(f.Id, hashMod)
+	if !node.Bucket.Contains(f.Name) {
+		return ErrNotFound
+	}
+	err = node.Bucket.Delete(f.Name)
+	if err != nil {
+		return err
+	}
+	if successor := node.getSuccessor(); successor != node.Address {
+		var deleteReply DeleteFileRPCReply
+		ctx := contextWithNode(context.Background(), node)
+		err = ChordCallContext(ctx, successor, "Node.DeleteBackupFileRPC", f, &deleteReply)
+		if err != nil {
+			node.logger().Warn("Delete backup file failed", F(FieldMethod, "DeleteBackupFileRPC"), F(FieldPeer, successor), F("file", f.Name), Err(err))
+		}
 	}
+	reply.Success = true
 	return nil
 }
 
@@ -432,61 +772,100 @@ type CheckFileExistRPCReply struct {
 }
 
 func (node *Node) CheckFileExistRPC(fileName string, reply *CheckFileExistRPCReply) error {
-	fmt.Println("-------------- Invoke CheckFileExistRPC function ------------")
+	node.logger().Debug("Invoke CheckFileExistRPC", F(FieldMethod, "CheckFileExistRPC"), F("file", fileName))
+	err := ValidateName(fileName)
+	if err != nil {
+		node.logger().Warn("Reject file", F(FieldMethod, "CheckFileExistRPC"), Err(err))
+		return err
+	}
 	// Check if the file exists in the bucket
 	// Return true if exists, false if not
-	// Iterate the bucket to find the file
-	for _, value := range node.Bucket {
-		if value == fileName {
-			reply.Exist = true
-			return nil
-		}
-	}
-	reply.Exist = false
+	reply.Exist = node.Bucket.Contains(fileName)
 	return nil
 }
 
 func (node *Node) GetFileRPC(f FileRPC, reply *FileRPC) error {
-	fmt.Println("-------------- Invoke GetFileRPC function ------------")
+	node.logger().Debug("Invoke GetFileRPC", F(FieldMethod, "GetFileRPC"), F("file", f.Name))
+	err := ValidateName(f.Name)
+	if err != nil {
+		node.logger().Warn("Reject file", F(FieldMethod, "GetFileRPC"), Err(err))
+		return err
+	}
 	// Get the file from the bucket
 	// Return the file if success, return error if failed
 	f.Id.Mod(f.Id, hashMod)
-	fmt.Println("Get file id: ", f.Id)
-	var fileName string
-	var ok bool
-	// iterate the bucket to find the file
-	for key, value := range node.Bucket {
-		if key.Cmp(f.Id) == 0 {
-			fileName = value
-			ok = true
-			break
-		}
+	fileContent, err := node.readBucketFile(f.Id, f.Name)
+	if errors.Is(err, ErrCorrupt) {
+		return err
 	}
-	fmt.Println("Get file status: ", f.Name, " ", ok)
-	if !ok {
-		fmt.Println("Get file status: ", f.Name, " ", ok)
-		// Print bucket
-		fmt.Println("Bucket: ", node.Bucket)
-		return errors.New("file not found")
+	if err != nil {
+		node.logger().Debug("Get file failed", F(FieldMethod, "GetFileRPC"), F("id", f.Id), F("file", f.Name), Err(err))
+		return fmt.Errorf("%w: %s", ErrNotFound, f.Name)
 	}
 
-	// Read the file from the file chord_storage folder
-	currentNodeFileDownloadPath := "./tmp/" + node.Name + "/chord_storage/"
-	filepath := currentNodeFileDownloadPath + fileName
-	file, err := os.Open(filepath)
+	// Return the file
+	reply.Id = f.Id
+	reply.Name = f.Name
+	reply.Content = fileContent
+	reply.Checksum = contentChecksum(fileContent)
+	return nil
+}
+
+/*
+* @description: Read a file from the bucket, a corrupt file is repaired from the
+*				backup kept by successor[0] before it is returned
+ */
+func (node *Node) readBucketFile(id *big.Int, name string) ([]byte, error) {
+	content, err := node.Bucket.Get(name)
+	if !errors.Is(err, ErrCorrupt) {
+		return content, err
+	}
+	successor := node.getSuccessor()
+	node.logger().Warn("Bucket file is corrupt, repair from successor", F("file", name), F(FieldPeer, successor))
+	repaired := FileRPC{Id: id, Name: name}
+	err = ChordCallContext(contextWithNode(context.Background(), node), successor, "Node.GetBackupFileRPC", repaired, &repaired)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s, repair failed: %v", ErrCorrupt, name, err)
+	}
+	if repaired.Name != name {
+		return nil, fmt.Errorf("%w: %s, successor returned %s", ErrCorrupt, name, repaired.Name)
+	}
+	err = repaired.verify()
+	if err != nil {
+		return nil, err
+	}
+	err = node.Bucket.Put(id, name, repaired.Content)
+	if err != nil {
+		return nil, err
+	}
+	return repaired.Content, nil
+}
+
+/*
+* @description: RPC method serving a file from the backup, used by the
+*				predecessor to repair a corrupt copy in its bucket
+* @return: 		a corrupt backup is dropped (stabilization copies it again) and
+*				ErrCorrupt is returned
+ */
+func (node *Node) GetBackupFileRPC(f FileRPC, reply *FileRPC) error {
+	err := ValidateName(f.Name)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	fileContent, err := ioutil.ReadAll(file)
+	f.Id.Mod(f.Id, hashMod)
+	content, err := node.Backup.Get(f.Name)
+	if errors.Is(err, ErrCorrupt) {
+		node.logger().Warn("Backup file is corrupt, drop it", F("file", f.Name))
+		node.Backup.Delete(f.Name)
+		return err
+	}
 	if err != nil {
 		return err
 	}
-
-	// Return the file
 	reply.Id = f.Id
-	reply.Name = fileName
-	reply.Content = fileContent
+	reply.Name = f.Name
+	reply.Content = content
+	reply.Checksum = contentChecksum(content)
 	return nil
 }
 
@@ -497,7 +876,7 @@ func (node *Node) encryptFile(content []byte) []byte {
 	publicKey := node.PublicKey
 	encryptedContent, err := rsa.EncryptPKCS1v15(rand.Reader, publicKey, content)
 	if err != nil {
-		fmt.Println("Encrypt file failed")
+		node.logger().Error("Encrypt file failed", Err(err))
 		return nil
 	}
 	return encryptedContent
@@ -510,14 +889,41 @@ func (node *Node) decryptFile(content []byte) []byte {
 	privateKey := node.PrivateKey
 	decryptedContent, err := rsa.DecryptPKCS1v15(rand.Reader, privateKey, content)
 	if err != nil {
-		fmt.Println("Decrypt file failed")
+		node.logger().Error("Decrypt file failed", Err(err))
 		return decryptedContent
 	}
 	return decryptedContent
 }
 
+// abortStart stops serving and releases the metadata log and span exporter, of a node that failed to start or quits
+func (node *Node) abortStart() {
+	node.Stop()
+	if node.httpServer != nil {
+		node.httpServer.Close()
+	}
+	if node.metricsServer != nil {
+		node.metricsServer.Close()
+	}
+	if node.meta != nil {
+		node.meta.Close()
+	}
+	if node.traceExporter != nil {
+		removeSpanExporter(node.traceExporter)
+		node.traceExporter.Close()
+	}
+}
+
 func (node *Node) Quit() {
+	for _, vnode := range node.VNodes {
+		vnode.Quit()
+	}
 	node.Se_stab.Quit <- 1
 	node.Se_ff.Quit <- 1
 	node.Se_cp.Quit <- 1
+	// A run in progress still calls other nodes and writes to the stores
+	node.Se_stab.Wait()
+	node.Se_ff.Wait()
+	node.Se_cp.Wait()
+	// Let the requests being served finish before the stores are closed
+	node.abortStart()
 }
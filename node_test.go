@@ -0,0 +1,65 @@
+package chord
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCorruptFileRepairedFromBackup(t *testing.T) {
+	// Stabilization is run by the test, so nothing repairs the files behind its back
+	cfg := Config{Storage: "file", Stabilize: time.Minute, FixFingers: time.Minute, CheckPredecessor: time.Minute}
+	// Named so that the two get identifiers of their own (10 and 43)
+	cfg.Name = "node-a"
+	a := startTestNode(t, cfg)
+	cfg.Name, cfg.Join = "node-b", a.Address
+	b := startTestNode(t, cfg)
+	ctx := context.Background()
+	for _, node := range []*Node{a, b, a} {
+		if err := node.stabilize(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := NewClient(a)
+	if err := client.Put(ctx, "a.txt", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	owner, successor := a, b
+	if !a.Bucket.Contains("a.txt") {
+		owner, successor = b, a
+	}
+	// The owner copies its bucket to the backup of its successor
+	if err := owner.stabilize(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !successor.Backup.Contains("a.txt") {
+		t.Fatal("file not backed up by the successor")
+	}
+
+	stored := owner.path("chord_storage", diskName("a.txt"))
+	if err := os.WriteFile(stored, []byte("hellO"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := owner.Bucket.Get("a.txt"); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Get of a changed file = %v, want ErrCorrupt", err)
+	}
+	if value, err := client.Get(ctx, "a.txt"); err != nil || string(value) != "hello" {
+		t.Fatalf("Get of a corrupt file = %q, %v, want it repaired", value, err)
+	}
+	if content, err := os.ReadFile(stored); err != nil || string(content) != "hello" {
+		t.Fatalf("file on disk after the repair = %q, %v", content, err)
+	}
+
+	// Without a healthy backup the corruption is reported, never served
+	os.WriteFile(stored, []byte("hellO"), 0644)
+	os.WriteFile(successor.path("chord_backup", diskName("a.txt")), []byte("hellO"), 0644)
+	if _, err := client.Get(ctx, "a.txt"); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Get without a healthy backup = %v, want ErrCorrupt", err)
+	}
+	// The corrupt backup was dropped, the next stabilization copies a healthy one
+	if successor.Backup.Contains("a.txt") {
+		t.Fatal("corrupt backup kept")
+	}
+}
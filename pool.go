@@ -0,0 +1,221 @@
+package chord
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"strings"
+	"sync"
+)
+
+/*------------------------------------------------------------*/
+/*                  RPC Connection Pool Below                 */
+/*------------------------------------------------------------*/
+
+/*
+* @description: Keeps one RPC client per remote process ("IP:Port"), calling
+*				process and wire format so that periodic tasks and lookups do not
+*				dial a new connection for every call. net/rpc clients multiplex
+*				concurrent calls over a single connection, and all virtual nodes
+*				of a process share the pool. A node says hello on every
+*				connection it dials before any other call, see sayHello.
+ */
+type connPool struct {
+	mutex   sync.Mutex
+	clients map[string]*pooledClient // Keyed by poolKey
+
+	// Opens the connections of the pool, nil to dial TCP
+	dialer func(ctx context.Context, hostPort string) (net.Conn, error)
+}
+
+// pooledClient is a pooled connection and the protocol agreed on it
+type pooledClient struct {
+	*rpc.Client
+	protocol Protocol // Every feature of this build on the connections of clients, which say no hello
+}
+
+// poolKey is the key of the client of the calls from the node process caller ("" for clients) to hostPort in format
+func poolKey(format string, caller string, hostPort string) string {
+	return format + " " + caller + " " + hostPort
+}
+
+// Pool used by ChordCall, the default Transport
+var pool = &connPool{clients: make(map[string]*pooledClient)}
+
+/*
+* @description: Get the pooled client of a process for the calls of ctx,
+*				dialing it in the wire format of the calling node if there is none
+* @return: 		the client, its pool key and whether it was taken from the pool
+ */
+func (p *connPool) get(ctx context.Context, hostPort string) (*pooledClient, string, bool, error) {
+	format := callWireFormat(ctx)
+	node := callingNode(ctx)
+	caller := ""
+	if node != nil {
+		caller, _ = splitAddress(node.Address)
+	}
+	key := poolKey(format, caller, hostPort)
+	p.mutex.Lock()
+	client, ok := p.clients[key]
+	p.mutex.Unlock()
+	if ok {
+		return client, key, true, nil
+	}
+
+	client, err := p.dial(ctx, hostPort, format)
+	if err == nil && node != nil {
+		client.protocol, err = node.sayHello(ctx, client.Client, hostPort)
+		if err == nil && format == WireGob && !client.protocol.Has(FeatureGob) {
+			// The peer does not offer gob, dial it again in JSON
+			client.Close()
+			client, err = p.dial(ctx, hostPort, WireJSON)
+			if err == nil {
+				client.protocol, err = node.sayHello(ctx, client.Client, hostPort)
+			}
+		}
+		if err != nil && client != nil {
+			client.Close()
+		}
+	}
+	if err != nil {
+		return nil, key, false, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if other, ok := p.clients[key]; ok {
+		// Another call dialed the same process meanwhile, keep a single connection
+		client.Close()
+		return other, key, true, nil
+	}
+	p.clients[key] = client
+	return client, key, false, nil
+}
+
+// dial opens a connection to hostPort in format, its protocol is every feature of this build until a hello says otherwise
+func (p *connPool) dial(ctx context.Context, hostPort string, format string) (*pooledClient, error) {
+	var conn net.Conn
+	var err error
+	if p.dialer != nil {
+		conn, err = p.dialer(ctx, hostPort)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", hostPort)
+	}
+	if err != nil {
+		return nil, err
+	}
+	codec, err := newClientCodec(ctx, conn, format)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	protocol := Protocol{Version: ProtocolVersion, Features: SupportedFeatures()}
+	return &pooledClient{Client: rpc.NewClientWithCodec(codec), protocol: protocol}, nil
+}
+
+// drop closes a client whose connection failed, unless it was already replaced
+func (p *connPool) drop(key string, client *pooledClient) {
+	p.mutex.Lock()
+	if p.clients[key] == client {
+		delete(p.clients, key)
+	}
+	p.mutex.Unlock()
+	client.Close()
+}
+
+// closeAll closes every pooled connection
+func (p *connPool) closeAll() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for key, client := range p.clients {
+		client.Close()
+		delete(p.clients, key)
+	}
+}
+
+// closeProcess closes the pooled connections from and to the process at hostPort
+func (p *connPool) closeProcess(hostPort string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for key, client := range p.clients {
+		fields := strings.Split(key, " ")
+		if fields[1] == hostPort || fields[2] == hostPort {
+			client.Close()
+			delete(p.clients, key)
+		}
+	}
+}
+
+// Listen listens on the TCP address hostPort
+func (p *connPool) Listen(ctx context.Context, hostPort string) (net.Listener, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp4", hostPort)
+	if err != nil {
+		return nil, err
+	}
+	var lc net.ListenConfig
+	return lc.Listen(ctx, "tcp", tcpAddr.String())
+}
+
+/*
+* @description: Perform one call on the pooled connection of hostPort. A pooled
+*				connection that turns out to be closed (e.g. the remote process
+*				restarted) is replaced and the call is retried once; the request
+*				was never sent in that case.
+ */
+func (p *connPool) Call(ctx context.Context, hostPort string, method string, request interface{}, reply interface{}) error {
+	for {
+		client, key, pooled, err := p.get(ctx, hostPort)
+		if err != nil {
+			return err
+		}
+		if call, ok := request.(callParams); ok && call.Trace != nil && !client.protocol.Has(FeatureTrace) {
+			// The peer did not agree on traces
+			call.Trace = nil
+			request = call
+		}
+		call := client.Go(method, request, reply, make(chan *rpc.Call, 1))
+		select {
+		case <-call.Done:
+			err = call.Error
+		case <-ctx.Done():
+			// The connection stays usable, the late reply is discarded by the client
+			return ctx.Err()
+		}
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(rpc.ServerError); ok {
+			// The remote method failed, the connection is fine
+			return err
+		}
+		p.drop(key, client)
+		if !pooled || !errors.Is(err, rpc.ErrShutdown) {
+			return err
+		}
+	}
+}
+
+/*------------------------------------------------------------*/
+/*                 Virtual Node Addressing Below              */
+/*------------------------------------------------------------*/
+
+/*
+* @description: Split a node address into the process address and the RPC
+*				service of the node. Virtual nodes share the listener of their
+*				process and are addressed as "IP:Port/<index>".
+* @return: 		"IP:Port" and "Node" or "Node/<index>"
+ */
+func splitAddress(addr NodeAddress) (string, string) {
+	hostPort, index, found := strings.Cut(string(addr), "/")
+	if !found {
+		return hostPort, "Node"
+	}
+	return hostPort, vnodeService(index)
+}
+
+// vnodeService is the RPC service name a virtual node is registered under
+func vnodeService(index string) string {
+	return "Node/" + index
+}
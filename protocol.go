@@ -0,0 +1,259 @@
+package chord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/rpc"
+	"strings"
+	"sync"
+)
+
+/*------------------------------------------------------------*/
+/*                 Protocol Negotiation Below                 */
+/*------------------------------------------------------------*/
+
+/*
+* The protocol version is about the meaning of the RPCs (what a reply field
+* says, which calls a node expects), WireVersion about their encoding. A node
+* says hello on every connection it dials, before any other call: both sides
+* check that they speak a common protocol version on a ring of the same width
+* and store mode, and agree on the optional features they both offer for the
+* calls made on that connection.
+ */
+
+/*
+* ProtocolVersion is the version of the ring protocol spoken by this build.
+*	1: no HelloRPC, replies differ (e.g. StoreFileRPCReply.Err)
+*	2: a node says hello on every connection it dials and proves the address
+*	   of its process, see ConfirmHelloRPC
+ */
+const ProtocolVersion = 2
+
+// Oldest protocol version this build talks to
+const minProtocolVersion = 2
+
+// Optional features, used with a peer only if both nodes offer them
+const (
+	FeatureGob   = "wire-gob" // Accepts connections in the gob wire format
+	FeatureTrace = "trace"    // Continues the trace sent with a call
+)
+
+// SupportedFeatures returns the features this build offers by default
+func SupportedFeatures() []string {
+	return []string{FeatureGob, FeatureTrace}
+}
+
+// ErrIncompatible is wrapped by the errors of a refused handshake
+var ErrIncompatible = errors.New("incompatible protocol")
+
+// Hello is what two nodes tell each other in HelloRPC
+type Hello struct {
+	Address    NodeAddress // Address of the node saying hello
+	Version    int         // Newest protocol version it speaks
+	MinVersion int         // Oldest protocol version it speaks
+	RingBits   int         // m, identifiers are in [0, 2^m)
+	StoreMode  string      // Store mode of its ring
+	Features   []string    // Optional features it offers
+	Nonce      string      // Random hex string proving Address, see ConfirmHelloRPC
+}
+
+// Protocol is what a node agreed on with a peer
+type Protocol struct {
+	Version  int
+	Features []string
+}
+
+// Has reports whether feature was agreed on
+func (p Protocol) Has(feature string) bool {
+	for _, f := range p.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// hello is what node tells its peers
+func (node *Node) hello() Hello {
+	return Hello{
+		Address:    node.Address,
+		Version:    ProtocolVersion,
+		MinVersion: minProtocolVersion,
+		RingBits:   m,
+		StoreMode:  node.StoreMode,
+		Features:   node.features,
+	}
+}
+
+/*
+* @description: Agree on a protocol with a peer
+* @return: 		the highest common version and the features both offer, or an
+*				error wrapping ErrIncompatible saying what differs
+ */
+func negotiate(local Hello, remote Hello) (Protocol, error) {
+	if remote.RingBits != local.RingBits {
+		return Protocol{}, fmt.Errorf("%w: %s uses a ring of 2^%d identifiers, %s of 2^%d", ErrIncompatible, remote.Address, remote.RingBits, local.Address, local.RingBits)
+	}
+	if remote.StoreMode != local.StoreMode {
+		return Protocol{}, fmt.Errorf("%w: %s uses the %q store mode, %s the %q store mode", ErrIncompatible, remote.Address, remote.StoreMode, local.Address, local.StoreMode)
+	}
+	version := local.Version
+	if remote.Version < version {
+		version = remote.Version
+	}
+	if version < local.MinVersion || version < remote.MinVersion {
+		return Protocol{}, fmt.Errorf("%w: %s speaks protocol versions [%d, %d], %s versions [%d, %d]",
+			ErrIncompatible, remote.Address, remote.MinVersion, remote.Version, local.Address, local.MinVersion, local.Version)
+	}
+	protocol := Protocol{Version: version, Features: []string{}}
+	for _, feature := range local.Features {
+		if (Protocol{Features: remote.Features}).Has(feature) {
+			protocol.Features = append(protocol.Features, feature)
+		}
+	}
+	return protocol, nil
+}
+
+/*------------------------------------------------------------*/
+/*                       Handshake Below                      */
+/*------------------------------------------------------------*/
+
+/*
+* A node proves the address of its process when it says hello: the hello
+* carries a random nonce the node keeps with the process it says the hello
+* to while it waits for the reply, and the node answering asks the address
+* named in the hello whether the nonce is one of its own, said to the asking
+* process (ConfirmHelloRPC). A process that was dialed by the node cannot
+* pass its hello on to another one. The question is asked on a connection
+* of its own without hello, the answer binds the address to the connection
+* the hello was said on (see gateServerCodec).
+ */
+
+// helloNonces are the nonces of the hellos being said by a process with the process each is said to, shared by a node and its virtual nodes
+type helloNonces struct {
+	mutex   sync.Mutex
+	pending map[string]string
+}
+
+func newHelloNonces() *helloNonces {
+	return &helloNonces{pending: make(map[string]string)}
+}
+
+func (h *helloNonces) add(nonce string, hostPort string) {
+	h.mutex.Lock()
+	h.pending[nonce] = hostPort
+	h.mutex.Unlock()
+}
+
+func (h *helloNonces) remove(nonce string) {
+	h.mutex.Lock()
+	delete(h.pending, nonce)
+	h.mutex.Unlock()
+}
+
+// sentTo reports whether nonce is that of a hello being said to hostPort
+func (h *helloNonces) sentTo(nonce string, hostPort string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	target, ok := h.pending[nonce]
+	return ok && target == hostPort
+}
+
+// ConfirmHelloRPCArgs asks whether Nonce is that of a hello said to the process Asker
+type ConfirmHelloRPCArgs struct {
+	Nonce string
+	Asker NodeAddress // "IP:Port" of the process asking
+}
+
+type ConfirmHelloRPCReply struct {
+	Confirmed bool
+}
+
+// RPC method telling whether a nonce is that of a hello this process is saying to the asker
+func (node *Node) ConfirmHelloRPC(args ConfirmHelloRPCArgs, reply *ConfirmHelloRPCReply) error {
+	reply.Confirmed = args.Nonce != "" && node.hellos.sentTo(args.Nonce, string(args.Asker))
+	return nil
+}
+
+// confirmHello asks the process named in hello whether it is saying it
+func (node *Node) confirmHello(hello Hello) error {
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	// Without a calling node the question is sent without hello of its own
+	process, _ := splitAddress(node.Address)
+	var reply ConfirmHelloRPCReply
+	err := ChordCallContext(ctx, hello.Address, "Node.ConfirmHelloRPC", ConfirmHelloRPCArgs{Nonce: hello.Nonce, Asker: NodeAddress(process)}, &reply)
+	if err != nil {
+		return fmt.Errorf("%w: cannot confirm the hello of %s: %v", ErrUnauthorized, hello.Address, err)
+	}
+	if !reply.Confirmed {
+		return fmt.Errorf("%w: %s is not saying this hello", ErrUnauthorized, hello.Address)
+	}
+	return nil
+}
+
+/*
+* @description: RPC method answering the hello said on a connection. The
+*				caller is refused if it is incompatible, or if it names an
+*				address that does not confirm its nonce (see confirmHello).
+*				A hello without nonce proves nothing, its connection stays anonymous.
+ */
+func (node *Node) HelloRPC(hello Hello, reply *Hello) error {
+	*reply = node.hello()
+	protocol, err := negotiate(*reply, hello)
+	if err != nil {
+		node.logger().Warn("Refuse incompatible node", F(FieldPeer, hello.Address), Err(err))
+		return err
+	}
+	if hello.Nonce != "" {
+		err = node.confirmHello(hello)
+		if err != nil {
+			node.logger().Warn("Refuse unproven hello", F(FieldPeer, hello.Address), Err(err))
+			return err
+		}
+	}
+	node.logger().Debug("Negotiated protocol", F(FieldPeer, hello.Address), F("version", protocol.Version), F("features", strings.Join(protocol.Features, ",")))
+	return nil
+}
+
+/*
+* @description: Say hello to the process at hostPort on a connection node
+*				dialed, before any other call on it
+* @return: 		the protocol agreed for the connection, or an error wrapping
+*				ErrIncompatible if the peer is incompatible or too old to know HelloRPC
+ */
+func (node *Node) sayHello(ctx context.Context, client *rpc.Client, hostPort string) (Protocol, error) {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+	// The connection is shared by the nodes of the process, it says hello as the process
+	local := node.hello()
+	process, _ := splitAddress(node.Address)
+	local.Address = NodeAddress(process)
+	local.Nonce = randomHex(16)
+	node.hellos.add(local.Nonce, hostPort)
+	defer node.hellos.remove(local.Nonce)
+	request := callParams{Auth: callCredentials(ctx, "HelloRPC"), Params: local}
+	var reply Hello
+	call := client.Go("Node.HelloRPC", request, &reply, make(chan *rpc.Call, 1))
+	var err error
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if strings.Contains(fmt.Sprint(err), "can't find method") {
+		return Protocol{}, fmt.Errorf("%w: %s speaks protocol version 1 (no HelloRPC), %s needs at least version %d", ErrIncompatible, hostPort, node.Address, minProtocolVersion)
+	}
+	if err != nil {
+		// A refusal by the peer wraps ErrIncompatible, see remoteError
+		return Protocol{}, remoteError(err)
+	}
+	protocol, err := negotiate(local, reply)
+	if err != nil {
+		return Protocol{}, err
+	}
+	node.logger().Debug("Negotiated protocol", F(FieldPeer, hostPort), F("version", protocol.Version), F("features", strings.Join(protocol.Features, ",")))
+	return protocol, nil
+}
@@ -0,0 +1,229 @@
+package chord
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// startTestNode starts a node on a free local port, with its data in memory unless cfg.Storage is set
+func startTestNode(t *testing.T, cfg Config) *Node {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Address = "127.0.0.1"
+	cfg.Listener = listener
+	if cfg.Storage == "" {
+		cfg.Storage = "memory"
+	}
+	cfg.DataDir = t.TempDir()
+	if cfg.Logger == nil {
+		cfg.Logger = NopLogger()
+	}
+	node, err := Start(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Quit stops the periodic tasks too, which would otherwise call on into later tests
+	t.Cleanup(func() {
+		node.connMutex.Lock()
+		stopped := node.stopped
+		node.connMutex.Unlock()
+		if !stopped {
+			node.Quit()
+		}
+	})
+	return node
+}
+
+// dialTestNode opens a connection to the process of target in the JSON wire format
+func dialTestNode(t *testing.T, target *Node) *pooledClient {
+	t.Helper()
+	process, _ := splitAddress(target.Address)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := pool.dial(ctx, process, WireJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestNegotiate(t *testing.T) {
+	local := Hello{Address: "local", Version: ProtocolVersion, MinVersion: minProtocolVersion, RingBits: m, StoreMode: StoreModeName, Features: []string{FeatureGob, FeatureTrace}}
+	cases := []struct {
+		name     string
+		remote   func(h *Hello)
+		version  int
+		features []string
+	}{
+		{"same build", func(h *Hello) {}, ProtocolVersion, []string{FeatureGob, FeatureTrace}},
+		{"newer peer", func(h *Hello) { h.Version, h.MinVersion = ProtocolVersion+2, ProtocolVersion }, ProtocolVersion, []string{FeatureGob, FeatureTrace}},
+		{"fewer features", func(h *Hello) { h.Features = []string{FeatureTrace, "unknown"} }, ProtocolVersion, []string{FeatureTrace}},
+		{"no features", func(h *Hello) { h.Features = nil }, ProtocolVersion, []string{}},
+	}
+	for _, c := range cases {
+		remote := local
+		remote.Address = "remote"
+		c.remote(&remote)
+		protocol, err := negotiate(local, remote)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if protocol.Version != c.version || !reflect.DeepEqual(protocol.Features, c.features) {
+			t.Errorf("%s: agreed on %+v, want version %d with %v", c.name, protocol, c.version, c.features)
+		}
+	}
+
+	refused := []struct {
+		name   string
+		remote func(h *Hello)
+	}{
+		{"ring width", func(h *Hello) { h.RingBits = m + 1 }},
+		{"store mode", func(h *Hello) { h.StoreMode = StoreModeContent }},
+		{"too old", func(h *Hello) { h.Version, h.MinVersion = minProtocolVersion-1, 1 }},
+		{"too new", func(h *Hello) { h.Version, h.MinVersion = ProtocolVersion+2, ProtocolVersion+1 }},
+	}
+	for _, c := range refused {
+		remote := local
+		c.remote(&remote)
+		if _, err := negotiate(local, remote); !errors.Is(err, ErrIncompatible) {
+			t.Errorf("%s: %v, want ErrIncompatible", c.name, err)
+		}
+		// Both sides come to the same conclusion
+		if _, err := negotiate(remote, local); !errors.Is(err, ErrIncompatible) {
+			t.Errorf("%s seen from the peer: %v, want ErrIncompatible", c.name, err)
+		}
+	}
+}
+
+func TestHelloBetweenNodes(t *testing.T) {
+	a := startTestNode(t, Config{Features: []string{FeatureTrace}})
+	b := startTestNode(t, Config{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	process, _ := splitAddress(b.Address)
+	protocol, err := a.sayHello(ctx, dialTestNode(t, b).Client, process)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if protocol.Version != ProtocolVersion || !reflect.DeepEqual(protocol.Features, []string{FeatureTrace}) {
+		t.Fatalf("a agreed on %+v with b", protocol)
+	}
+	process, _ = splitAddress(a.Address)
+	protocol, err = b.sayHello(ctx, dialTestNode(t, a).Client, process)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(protocol.Features, []string{FeatureTrace}) {
+		t.Fatalf("b agreed on %v with a, want only %s", protocol.Features, FeatureTrace)
+	}
+
+	// No nonce is pending once the hellos are answered
+	var confirm ConfirmHelloRPCReply
+	for _, nonce := range []string{"", "0123456789abcdef"} {
+		if err := a.ConfirmHelloRPC(ConfirmHelloRPCArgs{Nonce: nonce, Asker: NodeAddress(process)}, &confirm); err != nil || confirm.Confirmed {
+			t.Fatalf("ConfirmHelloRPC(%q) = %v, %v", nonce, confirm.Confirmed, err)
+		}
+	}
+}
+
+func TestHelloNonceConfirmedToItsTargetOnly(t *testing.T) {
+	hellos := newHelloNonces()
+	hellos.add("nonce", "10.0.0.2:4000")
+	if !hellos.sentTo("nonce", "10.0.0.2:4000") {
+		t.Fatal("nonce not confirmed to its target")
+	}
+	if hellos.sentTo("nonce", "10.0.0.3:4000") || hellos.sentTo("other", "10.0.0.2:4000") {
+		t.Fatal("nonce confirmed to another process")
+	}
+	hellos.remove("nonce")
+	if hellos.sentTo("nonce", "10.0.0.2:4000") {
+		t.Fatal("removed nonce confirmed")
+	}
+}
+
+// relayService passes the hellos it receives on to target as they are
+type relayService struct {
+	target *pooledClient
+	err    chan error
+}
+
+func (r relayService) HelloRPC(hello Hello, reply *Hello) error {
+	err := remoteError(r.target.Call("Node.HelloRPC", callParams{Params: hello}, reply))
+	r.err <- err
+	return err
+}
+
+func TestHelloCannotBeRelayed(t *testing.T) {
+	p := startTestNode(t, Config{})
+	target := startTestNode(t, Config{})
+
+	// A process p dials passes the hello of p on to target
+	relay := relayService{target: dialTestNode(t, target), err: make(chan error, 1)}
+	server := rpc.NewServer()
+	if err := server.RegisterName("Node", relay); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		codec, _, err := newServerCodec(conn)
+		if err == nil {
+			server.ServeCodec(codec)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := pool.dial(ctx, listener.Addr().String(), WireJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	p.sayHello(ctx, client.Client, listener.Addr().String())
+
+	// p said the nonce to the relay, not to target
+	if err := <-relay.err; !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("relayed hello = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestHelloRefused(t *testing.T) {
+	a := startTestNode(t, Config{})
+	b := startTestNode(t, Config{})
+	client := dialTestNode(t, b)
+	process, _ := splitAddress(a.Address)
+
+	// A hello naming the process of a with a nonce a is not saying
+	forged := a.hello()
+	forged.Address = NodeAddress(process)
+	forged.Nonce = "0123456789abcdef"
+	var reply Hello
+	err := remoteError(client.Call("Node.HelloRPC", callParams{Params: forged}, &reply))
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("forged hello = %v, want ErrUnauthorized", err)
+	}
+
+	incompatible := a.hello()
+	incompatible.StoreMode = StoreModeContent
+	err = remoteError(client.Call("Node.HelloRPC", callParams{Params: incompatible}, &reply))
+	if !errors.Is(err, ErrIncompatible) {
+		t.Fatalf("hello in another store mode = %v, want ErrIncompatible", err)
+	}
+}
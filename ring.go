@@ -0,0 +1,255 @@
+package chord
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+/*------------------------------------------------------------*/
+/*              Ring Crawl and Consistency Check              */
+/*------------------------------------------------------------*/
+
+// RingMember is one node found by CrawlRing
+type RingMember struct {
+	Address     NodeAddress
+	Name        string
+	Id          *big.Int
+	Predecessor NodeAddress
+	Successors  []NodeAddress
+	Fingers     []FingerState
+	Load        NodeLoad
+}
+
+// Kinds of RingProblem
+const (
+	ProblemUnreachable = "unreachable" // A successor could not be contacted
+	ProblemPredecessor = "predecessor" // succ(n).predecessor != n
+	ProblemSkipped     = "skipped"     // A member lies between n and succ(n)
+	ProblemLoop        = "loop"        // The walk came back to a member other than the start
+	ProblemDuplicateId = "duplicate-id"
+	ProblemFinger      = "finger" // A finger does not point to successor(n + 2^(i-1))
+	ProblemIncomplete  = "incomplete"
+)
+
+type RingProblem struct {
+	Kind   string
+	Node   NodeAddress
+	Detail string
+}
+
+// RingReport is the result of CrawlRing
+type RingReport struct {
+	Start    NodeAddress
+	Members  []RingMember // In successor order, starting with Start
+	Complete bool         // The walk returned to Start
+	Problems []RingProblem
+}
+
+func (r *RingReport) problem(kind string, node NodeAddress, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, RingProblem{Kind: kind, Node: node, Detail: fmt.Sprintf(format, args...)})
+}
+
+/*
+* @description: Walk the ring by following successors from start, then check
+*				that every member agrees with its neighbours and that the finger
+*				tables point where they should
+* @param: 		start: any node of the ring
+* @return: 		the ordered membership and every problem found, error only if start is unreachable
+ */
+func CrawlRing(ctx context.Context, start NodeAddress) (*RingReport, error) {
+	report := &RingReport{Start: start, Problems: []RingProblem{}}
+	visited := make(map[NodeAddress]int) // Address -> index in Members
+	// A ring can hold at most 2^m nodes, one extra step detects a loop
+	maxMembers := int(hashMod.Int64()) + 1
+	current := start
+	for len(report.Members) < maxMembers {
+		member, err := crawlMember(ctx, current)
+		if err != nil {
+			if len(report.Members) == 0 {
+				return nil, err
+			}
+			// The successor answered a moment ago but failed now
+			report.problem(ProblemUnreachable, current, "crawl failed: %v", err)
+			break
+		}
+		visited[current] = len(report.Members)
+		report.Members = append(report.Members, member)
+
+		// Next member is the first reachable successor
+		next := NodeAddress("")
+		for _, successor := range member.Successors {
+			if successor == "" {
+				continue
+			}
+			if _, ok := visited[successor]; ok || successor == start {
+				next = successor
+				break
+			}
+			if _, err := crawlName(ctx, successor); err != nil {
+				report.problem(ProblemUnreachable, member.Address, "successor %s is unreachable: %v", successor, err)
+				continue
+			}
+			next = successor
+			break
+		}
+		if next == "" {
+			report.problem(ProblemIncomplete, member.Address, "no reachable successor")
+			break
+		}
+		if next == start {
+			report.Complete = true
+			break
+		}
+		if index, ok := visited[next]; ok {
+			report.problem(ProblemLoop, member.Address, "successor %s was already visited at position %d", next, index)
+			break
+		}
+		current = next
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	report.check()
+	return report, nil
+}
+
+func crawlName(ctx context.Context, addr NodeAddress) (string, error) {
+	var reply GetNameRPCReply
+	err := ChordCallContext(ctx, addr, "Node.GetNameRPC", "", &reply)
+	return reply.Name, err
+}
+
+// crawlMember collects name, predecessor, successors and fingers of one node
+func crawlMember(ctx context.Context, addr NodeAddress) (RingMember, error) {
+	member := RingMember{Address: addr}
+	name, err := crawlName(ctx, addr)
+	if err != nil {
+		return member, err
+	}
+	member.Name = name
+	member.Id = StrHash(name)
+	member.Id.Mod(member.Id, hashMod)
+
+	var successorReply GetSuccessorListRPCReply
+	err = ChordCallContext(ctx, addr, "Node.GetSuccessorListRPC", struct{}{}, &successorReply)
+	if err != nil {
+		return member, err
+	}
+	member.Successors = successorReply.SuccessorList
+
+	// An empty predecessor is reported as an error by GetPredecessorRPC
+	var predecessorReply GetPredecessorRPCReply
+	err = ChordCallContext(ctx, addr, "Node.GetPredecessorRPC", struct{}{}, &predecessorReply)
+	if err == nil {
+		member.Predecessor = predecessorReply.PredecessorAddress
+	}
+
+	state, err := GetState(ctx, addr)
+	if err == nil {
+		member.Fingers = state.Fingers
+		member.Load = state.Load
+	}
+	return member, nil
+}
+
+// check fills in the problems found between the crawled members
+func (r *RingReport) check() {
+	members := r.Members
+	if len(members) == 0 {
+		return
+	}
+	byId := make(map[string]NodeAddress)
+	for _, member := range members {
+		if other, ok := byId[member.Id.String()]; ok {
+			r.problem(ProblemDuplicateId, member.Address, "id %s is also used by %s", member.Id, other)
+		}
+		byId[member.Id.String()] = member.Address
+	}
+
+	// Neighbour checks along the walk
+	count := len(members)
+	if !r.Complete {
+		count--
+	}
+	for i := 0; i < count; i++ {
+		member := members[i]
+		successor := members[(i+1)%len(members)]
+		if successor.Predecessor != member.Address {
+			r.problem(ProblemPredecessor, successor.Address, "predecessor is %q, expected %s", successor.Predecessor, member.Address)
+		}
+		if len(members) == 1 {
+			continue
+		}
+		for _, other := range members {
+			if other.Address != member.Address && other.Address != successor.Address &&
+				between(member.Id, other.Id, successor.Id, false) {
+				r.problem(ProblemSkipped, member.Address, "successor is %s, but %s (id %s) lies in between", successor.Address, other.Address, other.Id)
+			}
+		}
+	}
+
+	// Finger checks need the full membership
+	if !r.Complete {
+		return
+	}
+	sorted := append([]RingMember(nil), members...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Id.Cmp(sorted[j].Id) < 0 })
+	for _, member := range members {
+		for _, finger := range member.Fingers {
+			expected := ringSuccessor(sorted, finger.Id)
+			if finger.Address != expected.Address {
+				r.problem(ProblemFinger, member.Address, "finger %d (id %s) points to %s, expected %s", finger.Index, finger.Id, finger.Address, expected.Address)
+			}
+		}
+	}
+}
+
+// ringSuccessor returns the first member whose id is >= id, wrapping around the ring
+func ringSuccessor(sorted []RingMember, id *big.Int) RingMember {
+	for _, member := range sorted {
+		if member.Id.Cmp(id) >= 0 {
+			return member
+		}
+	}
+	return sorted[0]
+}
+
+// JSON encodes the report with indentation
+func (r *RingReport) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+/*
+* @description: Render the report as a Graphviz digraph: solid edges are
+*				successor pointers, dashed edges predecessor pointers and nodes
+*				with problems are drawn in red
+ */
+func (r *RingReport) DOT() string {
+	faulty := make(map[NodeAddress]bool)
+	for _, problem := range r.Problems {
+		faulty[problem.Node] = true
+	}
+	var b strings.Builder
+	b.WriteString("digraph ring {\n")
+	for _, member := range r.Members {
+		color := "black"
+		if faulty[member.Address] {
+			color = "red"
+		}
+		fmt.Fprintf(&b, "  %q [label=%q, color=%s];\n", member.Address, fmt.Sprintf("%s\nid %s", member.Name, member.Id), color)
+	}
+	for _, member := range r.Members {
+		if len(member.Successors) > 0 && member.Successors[0] != "" {
+			fmt.Fprintf(&b, "  %q -> %q;\n", member.Address, member.Successors[0])
+		}
+		if member.Predecessor != "" {
+			fmt.Fprintf(&b, "  %q -> %q [style=dashed];\n", member.Address, member.Predecessor)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
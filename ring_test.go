@@ -0,0 +1,92 @@
+package chord
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestCrawlRing(t *testing.T) {
+	nodes := startTestRing(t, 3, Config{})
+	report, err := CrawlRing(context.Background(), nodes[1].Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Complete || report.Start != nodes[1].Address || len(report.Problems) != 0 {
+		t.Fatalf("crawl = %+v", report)
+	}
+	// Members follow the successors, so the ids increase once around the ring
+	wraps := 0
+	for i, member := range report.Members {
+		if member.Id.Cmp(Identifier(member.Name)) != 0 {
+			t.Errorf("member %s has id %s, want the id of its name", member.Address, member.Id)
+		}
+		if next := report.Members[(i+1)%len(report.Members)]; next.Id.Cmp(member.Id) < 0 {
+			wraps++
+		}
+		if len(member.Fingers) != fingerTableSize {
+			t.Errorf("member %s has %d fingers", member.Address, len(member.Fingers))
+		}
+	}
+	if wraps != 1 {
+		t.Fatalf("members not in ring order: %+v", report.Members)
+	}
+
+	var decoded RingReport
+	data, err := report.JSON()
+	if err != nil || json.Unmarshal(data, &decoded) != nil || len(decoded.Members) != 3 {
+		t.Fatalf("JSON = %s, %v", data, err)
+	}
+	dot := report.DOT()
+	first := report.Members[0]
+	if !strings.HasPrefix(dot, "digraph ring {") || !strings.Contains(dot, `"`+string(first.Address)+`" -> "`+string(first.Successors[0])+`";`) {
+		t.Fatalf("DOT = %s", dot)
+	}
+	if strings.Contains(dot, "red") {
+		t.Fatalf("healthy ring drawn with problems: %s", dot)
+	}
+
+	if _, err := CrawlRing(context.Background(), "127.0.0.1:1"); err == nil {
+		t.Fatal("crawl from an unreachable node succeeded")
+	}
+}
+
+// ringMember is a crawled member with the given id, successor and predecessor
+func ringMember(address string, id int64, successor, predecessor string) RingMember {
+	return RingMember{Address: NodeAddress(address), Id: big.NewInt(id), Successors: []NodeAddress{NodeAddress(successor)}, Predecessor: NodeAddress(predecessor)}
+}
+
+func TestRingReportCheck(t *testing.T) {
+	cases := []struct {
+		name    string
+		members []RingMember
+		kinds   []string
+	}{
+		{"consistent", []RingMember{ringMember("a", 10, "b", "c"), ringMember("b", 20, "c", "a"), ringMember("c", 40, "a", "b")}, nil},
+		{"broken predecessor", []RingMember{ringMember("a", 10, "b", "c"), ringMember("b", 20, "c", "c"), ringMember("c", 40, "a", "b")}, []string{ProblemPredecessor}},
+		{"skipped member", []RingMember{ringMember("a", 10, "c", "b"), ringMember("c", 40, "b", "a"), ringMember("b", 20, "a", "c")}, []string{ProblemSkipped, ProblemSkipped, ProblemSkipped}},
+		{"duplicate id", []RingMember{ringMember("a", 10, "b", "b"), ringMember("b", 10, "a", "a")}, []string{ProblemDuplicateId}},
+	}
+	for _, c := range cases {
+		report := &RingReport{Members: c.members, Complete: true, Problems: []RingProblem{}}
+		report.check()
+		var kinds []string
+		for _, problem := range report.Problems {
+			kinds = append(kinds, problem.Kind)
+		}
+		if strings.Join(kinds, ",") != strings.Join(c.kinds, ",") {
+			t.Errorf("%s: problems %+v, want kinds %v", c.name, report.Problems, c.kinds)
+		}
+	}
+
+	// Finger 5 of a (id 26) belongs to c, not b
+	members := []RingMember{ringMember("a", 10, "b", "c"), ringMember("b", 20, "c", "a"), ringMember("c", 40, "a", "b")}
+	members[0].Fingers = []FingerState{{Index: 4, Id: big.NewInt(18), Address: "b"}, {Index: 5, Id: big.NewInt(26), Address: "b"}}
+	report := &RingReport{Members: members, Complete: true, Problems: []RingProblem{}}
+	report.check()
+	if len(report.Problems) != 1 || report.Problems[0].Kind != ProblemFinger || report.Problems[0].Node != "a" {
+		t.Fatalf("problems of a wrong finger = %+v", report.Problems)
+	}
+}
@@ -1,8 +1,10 @@
 package chord
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"math/big"
+	"time"
 )
 
 /*------------------------------------------------------------*/
@@ -10,40 +12,56 @@ import (
 /*------------------------------------------------------------*/
 
 // Local use functionFindSuccessorRPC
-func (node *Node) closePrecedingNode(requestID *big.Int) NodeAddress {
+func (node *Node) closePrecedingNode(ctx context.Context, requestID *big.Int) NodeAddress {
 	// fmt.Println("************ Invoke closePrecedingNode function ************")
-	fingerTableSize := len(node.FingerTable)
-	for i := fingerTableSize - 1; i >= 1; i-- {
+	fingerTable := node.getFingerTable()
+	for i := len(fingerTable) - 1; i >= 1; i-- {
 		var reply GetNameRPCReply
-		err := ChordCall(node.FingerTable[i].Address, "Node.GetNameRPC", "", &reply)
+		err := ChordCallContext(ctx, fingerTable[i].Address, "Node.GetNameRPC", "", &reply)
 		if err != nil {
-			fmt.Println("Error in closePrecedingNode function: ", err)
+			node.logger().Debug("Finger is unreachable", F(FieldMethod, "GetNameRPC"), F(FieldPeer, fingerTable[i].Address), Err(err))
 			continue
 		}
 		fingerId := StrHash(reply.Name)
 		fingerId.Mod(fingerId, hashMod)
 		if between(node.Identifier, fingerId, requestID, false) {
-			return node.FingerTable[i].Address
+			return fingerTable[i].Address
 		}
 	}
-	return node.Successors[0]
+	return node.getSuccessor()
 }
 
 // Local use function
 // Lookup
 func find(id *big.Int, startNode NodeAddress) NodeAddress {
-	fmt.Println("****************** Invoke find function *********************")
-	fmt.Println("The id to be found is: ", id.Mod(id, hashMod))
+	addr, _ := findContext(context.Background(), id, startNode)
+	return addr
+}
+
+// find that stops when ctx is done, returns "-1" and the reason if the lookup failed
+func findContext(ctx context.Context, id *big.Int, startNode NodeAddress) (NodeAddress, error) {
+	defaultLogger.Debug("Invoke find", F("id", id.Mod(id, hashMod)), F(FieldPeer, startNode))
+	start := time.Now()
+	ctx, span := StartSpan(ctx, "lookup", SpanKindInternal, "")
+	span.SetAttr("chord.id", id)
+	defer func() {
+		lookupDuration.observe(time.Since(start).Seconds())
+	}()
 	found := false
 	nextNode := startNode
 	i := 0
 	maxSteps := 10 // 2^maxSteps
 	for !found && i < maxSteps {
+		if ctx.Err() != nil {
+			lookupRequests.inc("error")
+			span.Finish(ctx.Err())
+			return "-1", ctx.Err()
+		}
 		// found, nextNode = nextNode.FindSuccessor(id)
 		result := FindSuccessorRPCReply{}
-		err := ChordCall(nextNode, "Node.FindSuccessorRPC", id, &result)
+		err := ChordCallContext(ctx, nextNode, "Node.FindSuccessorRPC", id, &result)
 		if err != nil {
-			fmt.Println("Error in find function: ", err)
+			defaultLogger.Debug("Find step failed", traceFields(ctx, F(FieldMethod, "FindSuccessorRPC"), F(FieldPeer, nextNode), Err(err))...)
 		}
 		found = result.Found
 		// fmt.Println("The result of find is: ", result)
@@ -52,11 +70,18 @@ func find(id *big.Int, startNode NodeAddress) NodeAddress {
 		i++
 	}
 	if found {
-		fmt.Println("Find Success in ", i, " steps.")
-		return nextNode
+		defaultLogger.Debug("Find success", F("id", id), F("steps", i), F(FieldPeer, nextNode))
+		lookupRequests.inc("ok")
+		lookupHops.observe(float64(i))
+		span.SetAttr("chord.steps", i)
+		span.Finish(nil)
+		return nextNode, nil
 	} else {
-		fmt.Println("Find Failed, please try again.")
-		return "-1"
+		defaultLogger.Warn("Find failed", traceFields(ctx, F("id", id), F("steps", i))...)
+		lookupRequests.inc("error")
+		err := errors.New("cannot find the store position of the key")
+		span.Finish(err)
+		return "-1", err
 	}
 }
 
@@ -73,13 +98,16 @@ type FindSuccessorRPCReply struct {
 // Local use function
 func (node *Node) FindSuccessorRPC(requestID *big.Int, reply *FindSuccessorRPCReply) error {
 	// fmt.Println("*************** Invoke findSuccessor function ***************")
+	// Continue the trace of the caller, hops of one lookup share a trace id
+	ctx := contextWithNode(requestContext(requestID), node)
 	successorName := ""
+	successor := node.getSuccessor()
 	var getNameRPCReply GetNameRPCReply
-	err := ChordCall(node.Successors[0], "Node.GetNameRPC", "", &getNameRPCReply)
+	err := ChordCallContext(ctx, successor, "Node.GetNameRPC", "", &getNameRPCReply)
 	if err != nil {
-		fmt.Println("Error in findSuccessorRPC: ", err)
+		node.logger().Debug("Successor is unreachable", F(FieldMethod, "FindSuccessorRPC"), F(FieldPeer, successor), Err(err))
 		reply.Found = false
-		reply.SuccessorAddress = "Error in findSuccessorRPC at " + node.Successors[0]
+		reply.SuccessorAddress = "Error in findSuccessorRPC at " + successor
 		return nil
 	}
 	successorName = getNameRPCReply.Name
@@ -93,19 +121,19 @@ func (node *Node) FindSuccessorRPC(requestID *big.Int, reply *FindSuccessorRPCRe
 		// 	fmt.Println("Successor is: ", node.Successors[0])
 		// }
 		reply.Found = true
-		reply.SuccessorAddress = node.Successors[0]
+		reply.SuccessorAddress = successor
 		// return &res
 	} else {
 
-		successorAddr := node.closePrecedingNode(requestID)
+		successorAddr := node.closePrecedingNode(ctx, requestID)
 		// if requestID.String() == "15" {
 		// 	fmt.Println("Find closest preceding node at ", node.Address, " for ", requestID, " is ", successorAddr, "")
 		// }
 		// Get the successor of the close preceding node
 		var findSuccessorRPCReply FindSuccessorRPCReply
-		err := ChordCall(successorAddr, "Node.FindSuccessorRPC", requestID, &findSuccessorRPCReply)
+		err := ChordCallContext(ctx, successorAddr, "Node.FindSuccessorRPC", requestID, &findSuccessorRPCReply)
 		if err != nil {
-			fmt.Println("Error in findSuccessorRPC: ", err)
+			node.logger().Debug("Closest preceding node is unreachable", F(FieldMethod, "FindSuccessorRPC"), F(FieldPeer, successorAddr), Err(err))
 			reply.Found = false
 			reply.SuccessorAddress = "Error in findSuccessorRPC at " + successorAddr
 		} else {
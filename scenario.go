@@ -0,0 +1,338 @@
+package chord
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+/*------------------------------------------------------------*/
+/*                  Simulated Scenarios Below                 */
+/*------------------------------------------------------------*/
+
+// Kinds of SimEvent
+const (
+	SimJoin      = "join"      // A new node joins the ring
+	SimKill      = "kill"      // Node crashes
+	SimRestart   = "restart"   // Node starts again, empty
+	SimPartition = "partition" // The network is split into Groups
+	SimHeal      = "heal"      // The partition is removed
+)
+
+// SimEvent happens At a virtual time after the initial ring has settled
+type SimEvent struct {
+	At     time.Duration
+	Kind   string
+	Node   int     // Index of the node killed or restarted
+	Groups [][]int // Node indexes of each side of a partition
+}
+
+func (e SimEvent) String() string {
+	switch e.Kind {
+	case SimKill, SimRestart:
+		return fmt.Sprintf("%v %s %d", e.At, e.Kind, e.Node)
+	case SimPartition:
+		return fmt.Sprintf("%v %s %v", e.At, e.Kind, e.Groups)
+	}
+	return fmt.Sprintf("%v %s", e.At, e.Kind)
+}
+
+/*
+* @description: Scenario starts a ring of Nodes nodes, lets it settle and
+*				stores Keys objects on a reliable network, then plays Events in
+*				order with the configured drop rate. At the end the
+*				network is repaired (no drops, no partition) and given up to
+*				Settle to converge before the invariants are checked.
+ */
+type Scenario struct {
+	Nodes  int
+	Keys   int
+	Events []SimEvent
+	Settle time.Duration // Default 2 minutes of virtual time
+}
+
+// ScenarioResult reports a run of RunScenario
+type ScenarioResult struct {
+	Duration   time.Duration // Virtual time of the whole run
+	Converged  time.Duration // Virtual time the ring took to converge after the last event, -1 if it did not
+	Stats      SimStats
+	Failed     []string    // Events that could not be applied, a join can fail under drops
+	Report     *RingReport // Last crawl of the ring
+	Violations []string    // Invariants that do not hold, empty if the run passed
+}
+
+/*
+* @description: Run a scenario on a new SimNetwork. The invariants checked are
+*				those of a converged ring: CrawlRing walks a complete ring
+*				without problems, its members are exactly the running nodes,
+*				a lookup of every identifier from every node returns the
+*				successor of the identifier, and every key stored before the
+*				events is still readable if no node holding it was lost.
+* @return: 		an error only if the scenario could not be set up
+ */
+func RunScenario(cfg SimConfig, scenario Scenario) (*ScenarioResult, error) {
+	if scenario.Settle == 0 {
+		scenario.Settle = 2 * time.Minute
+	}
+	// The initial ring is built on a reliable network
+	dropRate := cfg.DropRate
+	cfg.DropRate = 0
+	s, err := NewSimNetwork(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	for i := 0; i < scenario.Nodes; i++ {
+		_, err := s.AddNode()
+		if err != nil {
+			return nil, fmt.Errorf("add node %d: %w", i, err)
+		}
+	}
+	if _, violations := s.settle(scenario.Settle); len(violations) > 0 {
+		return nil, fmt.Errorf("initial ring did not converge: %s", violations[0])
+	}
+
+	keys := make(map[string][]byte)
+	for i := 0; i < scenario.Keys; i++ {
+		key, value := fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("value-%d", i))
+		var err error
+		s.Observe(func() { err = s.client().Put(context.Background(), key, value) })
+		if err != nil {
+			return nil, fmt.Errorf("put %s: %w", key, err)
+		}
+		keys[key] = value
+	}
+
+	result := &ScenarioResult{Failed: []string{}}
+	s.SetDropRate(dropRate)
+	start := s.Now()
+	lost := false // A node holding objects crashed, keys may be gone
+	for _, event := range scenario.Events {
+		if at := start + event.At; at > s.Now() {
+			s.Run(at - s.Now())
+		}
+		err := s.apply(event)
+		if err != nil {
+			result.Failed = append(result.Failed, fmt.Sprintf("%s: %v", event, err))
+		}
+		lost = lost || event.Kind == SimKill
+	}
+
+	// Repair the network and let the ring converge
+	s.Heal()
+	s.SetDropRate(0)
+	repaired := s.Now()
+	converged, violations := s.settle(scenario.Settle)
+	result.Converged = -1
+	if converged {
+		result.Converged = s.Now() - repaired
+	}
+	result.Report, _ = s.CheckRing()
+	result.Violations = violations
+	if !lost {
+		result.Violations = append(result.Violations, s.checkKeys(keys)...)
+	}
+	result.Duration = s.Now()
+	result.Stats = s.Stats()
+	return result, nil
+}
+
+// apply plays one event
+func (s *SimNetwork) apply(event SimEvent) error {
+	switch event.Kind {
+	case SimJoin:
+		_, err := s.AddNode()
+		return err
+	case SimKill, SimRestart:
+		if event.Node < 0 || event.Node >= s.Len() {
+			return fmt.Errorf("no node %d", event.Node)
+		}
+		if event.Kind == SimKill {
+			return s.Kill(event.Node)
+		}
+		return s.Restart(event.Node)
+	case SimPartition:
+		for _, group := range event.Groups {
+			for _, i := range group {
+				if i < 0 || i >= s.Len() {
+					return fmt.Errorf("no node %d", i)
+				}
+			}
+		}
+		s.Partition(event.Groups...)
+		return nil
+	case SimHeal:
+		s.Heal()
+		return nil
+	}
+	return fmt.Errorf("unknown event kind %q", event.Kind)
+}
+
+// settle runs the network until CheckRing passes, checking once per stabilize interval, or until limit elapsed
+func (s *SimNetwork) settle(limit time.Duration) (bool, []string) {
+	end := s.Now() + limit
+	for {
+		_, violations := s.CheckRing()
+		if len(violations) == 0 {
+			return true, nil
+		}
+		if s.Now() >= end {
+			return false, violations
+		}
+		s.Run(s.node.Stabilize)
+	}
+}
+
+// client enters the ring through the first running node
+func (s *SimNetwork) client() *Client {
+	for _, sn := range s.nodes {
+		if sn.node != nil {
+			return NewClient(sn.node)
+		}
+	}
+	return &Client{}
+}
+
+/*
+* @description: Check the ring formed by the running nodes, see RunScenario.
+*				The checks are made as an observer and do not change the clock.
+* @return: 		the crawl of the ring and the invariants that do not hold
+ */
+func (s *SimNetwork) CheckRing() (*RingReport, []string) {
+	alive := make(map[NodeAddress]*Node)
+	start := NodeAddress("")
+	for _, sn := range s.nodes {
+		if sn.node == nil {
+			continue
+		}
+		alive[sn.node.Address] = sn.node
+		if start == "" {
+			start = sn.node.Address
+		}
+	}
+	if start == "" {
+		return nil, []string{"no node is running"}
+	}
+
+	var report *RingReport
+	var err error
+	violations := []string{}
+	s.Observe(func() { report, err = CrawlRing(context.Background(), start) })
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	if !report.Complete {
+		violations = append(violations, fmt.Sprintf("the walk from %s did not come back", start))
+	}
+	for _, p := range report.Problems {
+		violations = append(violations, fmt.Sprintf("%s at %s: %s", p.Kind, p.Node, p.Detail))
+	}
+	onRing := make(map[NodeAddress]bool)
+	for _, member := range report.Members {
+		onRing[member.Address] = true
+		if alive[member.Address] == nil {
+			violations = append(violations, fmt.Sprintf("%s is down but still on the ring", member.Address))
+		}
+	}
+	for addr := range alive {
+		if !onRing[addr] {
+			violations = append(violations, fmt.Sprintf("%s is running but not on the ring", addr))
+		}
+	}
+	if len(violations) > 0 {
+		sort.Strings(violations)
+		return report, violations
+	}
+
+	// Every node must resolve every identifier to its successor on the ring
+	sorted := append([]RingMember(nil), report.Members...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Id.Cmp(sorted[j].Id) < 0 })
+	for _, sn := range s.nodes {
+		if sn.node == nil {
+			continue
+		}
+		for id := int64(0); id < hashMod.Int64(); id++ {
+			expected := ringSuccessor(sorted, big.NewInt(id)).Address
+			var found NodeAddress
+			s.Observe(func() { found, err = findContext(context.Background(), big.NewInt(id), sn.node.Address) })
+			if err != nil || found != expected {
+				violations = append(violations, fmt.Sprintf("lookup of %d from %s returned %s, expected %s", id, sn.node.Address, found, expected))
+			}
+		}
+	}
+	return report, violations
+}
+
+// checkKeys reads every key back through the ring
+func (s *SimNetwork) checkKeys(keys map[string][]byte) []string {
+	names := make([]string, 0, len(keys))
+	for key := range keys {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	violations := []string{}
+	for _, key := range names {
+		var value []byte
+		var err error
+		s.Observe(func() { value, err = s.client().Get(context.Background(), key) })
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("get %s: %v", key, err))
+		} else if !bytes.Equal(value, keys[key]) {
+			violations = append(violations, fmt.Sprintf("get %s returned %q, expected %q", key, value, keys[key]))
+		}
+	}
+	return violations
+}
+
+/*
+* @description: Build a random churn scenario: events nodes join, crash or
+*				restart, one every interval, keeping at least 3 nodes running
+ */
+func ChurnScenario(seed int64, nodes int, events int, interval time.Duration) Scenario {
+	r := rand.New(rand.NewSource(seed))
+	scenario := Scenario{Nodes: nodes}
+	alive, down := []int{}, []int{}
+	for i := 0; i < nodes; i++ {
+		alive = append(alive, i)
+	}
+	total := nodes
+	for e := 0; e < events; e++ {
+		event := SimEvent{At: time.Duration(e+1) * interval}
+		choices := []string{}
+		if total < int(hashMod.Int64())/2 {
+			choices = append(choices, SimJoin)
+		}
+		if len(alive) > 3 {
+			choices = append(choices, SimKill)
+		}
+		if len(down) > 0 {
+			choices = append(choices, SimRestart)
+		}
+		if len(choices) == 0 {
+			break
+		}
+		event.Kind = choices[r.Intn(len(choices))]
+		switch event.Kind {
+		case SimJoin:
+			alive = append(alive, total)
+			total++
+		case SimKill:
+			k := r.Intn(len(alive))
+			event.Node = alive[k]
+			alive = append(alive[:k], alive[k+1:]...)
+			down = append(down, event.Node)
+		case SimRestart:
+			k := r.Intn(len(down))
+			event.Node = down[k]
+			down = append(down[:k], down[k+1:]...)
+			alive = append(alive, event.Node)
+		}
+		scenario.Events = append(scenario.Events, event)
+	}
+	return scenario
+}
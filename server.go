@@ -0,0 +1,343 @@
+package chord
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*------------------------------------------------------------*/
+/*                      RPC Server Below                      */
+/*------------------------------------------------------------*/
+
+// How long Stop waits for the requests being served before closing their connections
+const stopTimeout = 5 * time.Second
+
+/*
+* @description: Register node and its virtual nodes on an RPC server of their
+*				own, so several nodes can be served by one process
+ */
+func (node *Node) registerRPC() error {
+	err := checkWireSchema()
+	if err != nil {
+		return err
+	}
+	node.rpcServer = rpc.NewServer()
+	err = node.rpcServer.Register(node)
+	if err != nil {
+		return err
+	}
+	// Virtual nodes are served by the same listener under their own service name
+	for i, vnode := range node.VNodes {
+		err = node.rpcServer.RegisterName(vnodeService(strconv.Itoa(i+1)), vnode)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+* @description: rpc.ServerCodec rejecting, before rpc.Server sees them, the
+*				calls beyond the rate limit of their peer or unauthorized (see
+*				authorize): such a call is answered with its error and the next
+*				request is read instead. Files beyond the size limit are rejected
+*				once decoded. Responses are serialized, as rejections are written
+*				next to those of rpc.Server.
+ */
+type gateServerCodec struct {
+	rpc.ServerCodec
+	node  *Node        // Node owning the listener, see serviceNode
+	conn  *limitedConn // Connection of the codec, nil without message size limit
+	peer  string       // IP of the peer, see admit
+	mutex sync.Mutex
+
+	// Process that proved its address with a HelloRPC on the connection, empty until then
+	caller NodeAddress
+	seq    uint64                 // Sequence number of the request read last
+	hellos map[uint64]NodeAddress // Process named by the HelloRPCs being served, by sequence number
+
+	// Bytes of the memory budget held by the requests being served, by sequence number
+	serving map[uint64]int64
+}
+
+func newGateServerCodec(codec rpc.ServerCodec, node *Node, conn *limitedConn, peer string) rpc.ServerCodec {
+	return &gateServerCodec{ServerCodec: codec, node: node, conn: conn, peer: peer, hellos: make(map[uint64]NodeAddress), serving: make(map[uint64]int64)}
+}
+
+// settle gives the bytes read for the request read last to it while it is served, or back to the budget
+func (c *gateServerCodec) settle(n int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.serving[c.seq]; ok {
+		c.serving[c.seq] += n
+		return
+	}
+	c.node.limits.memory.release(n)
+}
+
+// provenCaller is the process that proved its address on the connection, empty if none did
+func (c *gateServerCodec) provenCaller() NodeAddress {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.caller
+}
+
+func (c *gateServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	for {
+		if c.conn != nil {
+			c.settle(c.conn.take())
+			c.conn.startRequest()
+		}
+		err := c.ServerCodec.ReadRequestHeader(r)
+		if err != nil {
+			return err
+		}
+		c.seq = r.Seq
+		var presented *Credentials
+		if authed, ok := c.ServerCodec.(interface{ requestAuth() *Credentials }); ok {
+			presented = authed.requestAuth()
+		}
+		// The members of a ring with a secret are not rate limited once they proved their address, see HelloRPC
+		var denied error
+		if c.node.secrets.secret == "" || c.provenCaller() == "" {
+			denied = c.node.admit(c.peer)
+		}
+		if denied != nil {
+			rpcRejected.inc("rate")
+			c.node.logger().Debug("Reject call", F(FieldMethod, r.ServiceMethod), Err(denied))
+		} else {
+			denied = c.node.serviceNode(r.ServiceMethod).authorize(r.ServiceMethod, presented, c.provenCaller())
+			if denied == nil {
+				c.mutex.Lock()
+				if _, ok := c.serving[r.Seq]; !ok {
+					c.serving[r.Seq] = 0
+				}
+				c.mutex.Unlock()
+				return nil
+			}
+			rpcServerRequests.inc(methodLabel(r.ServiceMethod), "unauthorized")
+			c.node.logger().Warn("Reject unauthorized call", F(FieldMethod, r.ServiceMethod), Err(denied))
+		}
+		// The body is discarded and the call answered without being served
+		err = c.ServerCodec.ReadRequestBody(nil)
+		if err != nil {
+			return err
+		}
+		err = c.WriteResponse(&rpc.Response{ServiceMethod: r.ServiceMethod, Seq: r.Seq, Error: denied.Error()}, struct{}{})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+/*
+* @description: Read a request body, failing for a file beyond the size limit:
+*				rpc.Server answers the call with the error. The process named
+*				by a hello with a nonce becomes the caller of the connection
+*				once HelloRPC confirmed it, see WriteResponse.
+ */
+func (c *gateServerCodec) ReadRequestBody(x interface{}) error {
+	err := c.ServerCodec.ReadRequestBody(x)
+	if hello, ok := x.(*Hello); ok && err == nil && hello.Nonce != "" {
+		process, _ := splitAddress(hello.Address)
+		c.mutex.Lock()
+		c.hellos[c.seq] = NodeAddress(process)
+		c.mutex.Unlock()
+	}
+	if f, ok := x.(*FileRPC); ok && err == nil {
+		err = c.node.checkFileSize(f)
+		if err != nil {
+			rpcRejected.inc("file_size")
+			c.node.logger().Warn("Reject file", Err(err))
+		}
+	}
+	return err
+}
+
+// requestTrace is that of the wrapped codec, see observedServerCodec
+func (c *gateServerCodec) requestTrace() *SpanContext {
+	if traced, ok := c.ServerCodec.(interface{ requestTrace() *SpanContext }); ok {
+		return traced.requestTrace()
+	}
+	return nil
+}
+
+func (c *gateServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if process, ok := c.hellos[r.Seq]; ok {
+		delete(c.hellos, r.Seq)
+		if r.Error == "" {
+			c.caller = process
+		}
+	}
+	err := c.ServerCodec.WriteResponse(r, body)
+	if held, ok := c.serving[r.Seq]; ok {
+		delete(c.serving, r.Seq)
+		c.node.limits.memory.release(held)
+	}
+	return err
+}
+
+// Close gives back the bytes of the budget still held, rpc.Server closes the codec once every request is answered
+func (c *gateServerCodec) Close() error {
+	if c.conn != nil {
+		c.settle(c.conn.take())
+	}
+	c.mutex.Lock()
+	for seq, held := range c.serving {
+		delete(c.serving, seq)
+		c.node.limits.memory.release(held)
+	}
+	c.mutex.Unlock()
+	return c.ServerCodec.Close()
+}
+
+// serviceNode is the node serving a method, "Node/1.X" is served by the first virtual node
+func (node *Node) serviceNode(serviceMethod string) *Node {
+	service, _, _ := strings.Cut(serviceMethod, ".")
+	if _, index, found := strings.Cut(service, "/"); found {
+		i, err := strconv.Atoi(index)
+		if err == nil && i >= 1 && i <= len(node.VNodes) {
+			return node.VNodes[i-1]
+		}
+	}
+	return node
+}
+
+// serve accepts the connections of listener in the background until Stop
+func (node *Node) serve(listener net.Listener) {
+	node.listener = listener
+	node.serving.Add(1)
+	go func() {
+		defer node.serving.Done()
+		HandleConnection(listener, node)
+	}()
+}
+
+/*
+* @description: Serve the RPCs of node and its virtual nodes on listener until
+*				the listener is closed. Connections are tracked so that Stop can
+*				shut them down, Stop only closes the listener given to serve.
+ */
+func HandleConnection(listener net.Listener, node *Node) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			node.logger().Error("Accept failed", Err(err))
+			continue
+		}
+		if node.limits.maxConns > 0 && node.connCount() >= node.limits.maxConns {
+			node.refuseConn(conn)
+			continue
+		}
+		if !node.trackConn(conn) {
+			// Stopped meanwhile
+			conn.Close()
+			return
+		}
+		go func() {
+			defer node.serving.Done()
+			defer node.untrackConn(conn)
+			var limited *limitedConn
+			var served net.Conn = conn
+			if node.limits.maxMessageSize > 0 {
+				limited = &limitedConn{Conn: conn, node: node, max: node.limits.maxMessageSize}
+				served = limited
+			}
+			codec, format, err := newServerCodec(served)
+			if err != nil {
+				node.logger().Warn("Refused connection", F(FieldPeer, conn.RemoteAddr()), Err(err))
+				conn.Close()
+				return
+			}
+			node.logger().Debug("Accepted connection", F(FieldPeer, conn.RemoteAddr()), F("wire", format))
+			// Returns once the connection is closed and its pending requests are answered
+			node.rpcServer.ServeCodec(newObservedServerCodec(newGateServerCodec(codec, node, limited, peerIP(conn)), node))
+		}()
+	}
+}
+
+// trackConn records a connection being served, false if the node is stopped
+func (node *Node) trackConn(conn net.Conn) bool {
+	node.connMutex.Lock()
+	defer node.connMutex.Unlock()
+	if node.stopped {
+		return false
+	}
+	if node.conns == nil {
+		node.conns = make(map[net.Conn]struct{})
+	}
+	node.conns[conn] = struct{}{}
+	node.serving.Add(1)
+	return true
+}
+
+// connCount is the number of connections being served
+func (node *Node) connCount() int {
+	node.connMutex.Lock()
+	defer node.connMutex.Unlock()
+	return len(node.conns)
+}
+
+func (node *Node) untrackConn(conn net.Conn) {
+	node.connMutex.Lock()
+	delete(node.conns, conn)
+	node.connMutex.Unlock()
+}
+
+/*
+* @description: Stop serving RPCs. The listener is closed, which ends the
+*				accept loop, and the connections stop reading requests: the
+*				requests already received are answered before their connection
+*				is closed. Connections still busy after stopTimeout are closed
+*				without waiting. Periodic tasks and stores are left to Quit,
+*				which calls Stop. Calling Stop again does nothing.
+ */
+func (node *Node) Stop() {
+	node.connMutex.Lock()
+	if node.stopped {
+		node.connMutex.Unlock()
+		return
+	}
+	node.stopped = true
+	if node.listener != nil {
+		node.listener.Close()
+	}
+	for conn := range node.conns {
+		closeRead(conn)
+	}
+	node.connMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		node.serving.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(stopTimeout):
+		node.connMutex.Lock()
+		node.logger().Warn("Requests still running after stop timeout, closing their connections", F("connections", len(node.conns)))
+		for conn := range node.conns {
+			conn.Close()
+		}
+		node.connMutex.Unlock()
+	}
+}
+
+// closeRead makes the server see the end of the request stream while it can still write replies
+func closeRead(conn net.Conn) {
+	if tcp, ok := conn.(interface{ CloseRead() error }); ok && tcp.CloseRead() == nil {
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,53 @@
+package chord
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStop(t *testing.T) {
+	cfg := Config{Stabilize: time.Minute, FixFingers: time.Minute, CheckPredecessor: time.Minute}
+	node := startTestNode(t, cfg)
+	// A second node of the process is served by its own server
+	other := startTestNode(t, cfg)
+	client := dialTestNode(t, node)
+	defer client.Close()
+
+	// The request waits for the successor list, held by the test, while the node stops
+	node.mutex.Lock()
+	var reply GetSuccessorListRPCReply
+	call := client.Go("Node.GetSuccessorListRPC", callParams{Params: struct{}{}}, &reply, nil)
+	time.Sleep(100 * time.Millisecond)
+	stopped := make(chan struct{})
+	go func() {
+		node.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		node.mutex.Unlock()
+		t.Fatal("Stop returned before the request in progress was answered")
+	case <-time.After(100 * time.Millisecond):
+	}
+	node.mutex.Unlock()
+	<-call.Done
+	if call.Error != nil || len(reply.SuccessorList) == 0 {
+		t.Fatalf("request in progress during Stop = %v, %v", reply.SuccessorList, call.Error)
+	}
+	<-stopped
+
+	process, _ := splitAddress(node.Address)
+	if conn, err := net.DialTimeout("tcp", process, time.Second); err == nil {
+		conn.Close()
+		t.Fatal("listener still open after Stop")
+	}
+	// Stopping again does nothing, as does the Stop of Quit, which ends the periodic tasks
+	node.Stop()
+	node.Quit()
+
+	var name GetNameRPCReply
+	if err := ChordCall(other.Address, "Node.GetNameRPC", "", &name); err != nil || name.Name != other.Name {
+		t.Fatalf("other node after Stop: %q, %v", name.Name, err)
+	}
+}
@@ -0,0 +1,558 @@
+package chord
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+/*------------------------------------------------------------*/
+/*                  Network Simulator Below                   */
+/*------------------------------------------------------------*/
+
+// SimConfig configures a SimNetwork, zero values select the defaults
+type SimConfig struct {
+	Seed        int64         // Seed of every random choice, the same seed replays the same run
+	Latency     time.Duration // One-way delay of a message, default 1ms
+	Jitter      time.Duration // Random extra delay of a message, up to Jitter
+	DropRate    float64       // Probability that a request or a reply is lost, in [0, 1)
+	CallTimeout time.Duration // Time a caller waits for a lost message, default 1s
+
+	Stabilize        time.Duration // Periodic task intervals, defaults as in Config
+	FixFingers       time.Duration
+	CheckPredecessor time.Duration
+	Successors       int    // Number of successors to maintain, default 3
+	Logger           Logger // Logger of the nodes, default NopLogger
+}
+
+// SimStats counts the calls made by the nodes of a SimNetwork
+type SimStats struct {
+	Calls   int // Calls made by nodes
+	Dropped int // Calls whose request or reply was lost, partitions included
+	Refused int // Calls to nodes that were down
+}
+
+/*
+* @description: SimNetwork runs nodes in memory on a virtual clock. It is the
+*				Transport of the process while it exists: a call is sent over an
+*				in-memory connection (net.Pipe) of its own connection pool to a
+*				listener of the target process, which serves it through the same
+*				codecs as a TCP connection (handshake, hello, limits,
+*				authorization, metrics). Calls are still made one at a time, the
+*				caller waits for the reply, so a seed replays exactly the same
+*				run. Periodic tasks are scheduled on the virtual clock, every
+*				message advances it by Latency plus jitter, lost messages and
+*				calls across a partition cost CallTimeout. Opening a connection
+*				and its hello are free. Calls that do not come from a node
+*				(checks, clients) are delivered instantly and reliably. Not safe
+*				for concurrent use.
+ */
+type SimNetwork struct {
+	config  SimConfig
+	node    Config // Config of every node, see AddNode
+	rand    *rand.Rand
+	now     time.Duration
+	dir     string // Folder of the node keys
+	nodes   []*simNode
+	byHost  map[string]*simNode
+	used    map[string]bool // Identifiers taken by the nodes
+	tasks   simTasks
+	seq     uint64
+	groups  map[string]int // Partition of each process, nil if the network is whole
+	observe int            // > 0 while calls are made on behalf of an observer
+	stats   SimStats
+
+	// Connections between the processes and the listeners they are served on
+	pool      *connPool
+	listeners map[string]*simListener
+}
+
+// simNode is one process of the simulation, it keeps its name and address across restarts
+type simNode struct {
+	name       string
+	port       int
+	hostPort   string
+	node       *Node // nil while down
+	generation int   // Incremented on kill and restart, tasks of older generations are dropped
+}
+
+// simTask is one run of a periodic task, due at virtual time at
+type simTask struct {
+	at         time.Duration
+	seq        uint64 // Orders tasks due at the same time
+	sim        *simNode
+	generation int
+	name       string
+	period     time.Duration
+	run        func(ctx context.Context) error
+}
+
+// simTasks is a min-heap of tasks ordered by (at, seq)
+type simTasks []*simTask
+
+func (t simTasks) Len() int { return len(t) }
+func (t simTasks) Less(i, j int) bool {
+	return t[i].at < t[j].at || (t[i].at == t[j].at && t[i].seq < t[j].seq)
+}
+func (t simTasks) Swap(i, j int)       { t[i], t[j] = t[j], t[i] }
+func (t *simTasks) Push(x interface{}) { *t = append(*t, x.(*simTask)) }
+func (t *simTasks) Pop() interface{} {
+	old := *t
+	task := old[len(old)-1]
+	*t = old[:len(old)-1]
+	return task
+}
+
+// Errors seen by the callers of a SimNetwork
+var (
+	ErrSimTimeout = errors.New("sim: call timed out")
+	ErrSimRefused = errors.New("sim: connection refused")
+)
+
+// First port of the simulated nodes, all of them live on 127.0.0.1
+const simBasePort = 20000
+
+/*
+* @description: Create an empty network and make it the Transport of the
+*				process until Close
+ */
+func NewSimNetwork(cfg SimConfig) (*SimNetwork, error) {
+	if cfg.Latency == 0 {
+		cfg.Latency = time.Millisecond
+	}
+	if cfg.CallTimeout == 0 {
+		cfg.CallTimeout = time.Second
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = NopLogger()
+	}
+	if cfg.DropRate < 0 || cfg.DropRate >= 1 {
+		return nil, fmt.Errorf("drop rate %v is not in [0, 1)", cfg.DropRate)
+	}
+	dir, err := os.MkdirTemp("", "chordsim")
+	if err != nil {
+		return nil, err
+	}
+	s := &SimNetwork{
+		config: cfg,
+		node: Config{
+			Address:          "127.0.0.1",
+			Stabilize:        cfg.Stabilize,
+			FixFingers:       cfg.FixFingers,
+			CheckPredecessor: cfg.CheckPredecessor,
+			Successors:       cfg.Successors,
+			Storage:          "memory",
+			DataDir:          dir,
+			Logger:           cfg.Logger,
+		}.withDefaults(),
+		rand:      rand.New(rand.NewSource(cfg.Seed)),
+		dir:       dir,
+		byHost:    make(map[string]*simNode),
+		used:      make(map[string]bool),
+		pool:      &connPool{clients: make(map[string]*pooledClient)},
+		listeners: make(map[string]*simListener),
+	}
+	s.pool.dialer = s.dial
+	SetTransport(s)
+	return s, nil
+}
+
+// Close stops the nodes, restores the TCP transport and removes the node folders
+func (s *SimNetwork) Close() error {
+	s.pool.closeAll()
+	for _, sn := range s.nodes {
+		if sn.node != nil {
+			sn.node.Stop()
+		}
+	}
+	SetTransport(nil)
+	return os.RemoveAll(s.dir)
+}
+
+// Now returns the virtual time elapsed since the network was created
+func (s *SimNetwork) Now() time.Duration {
+	return s.now
+}
+
+// Stats returns the call counters
+func (s *SimNetwork) Stats() SimStats {
+	return s.stats
+}
+
+// Len returns the number of nodes ever added, down ones included
+func (s *SimNetwork) Len() int {
+	return len(s.nodes)
+}
+
+// Node returns the i-th node, nil while it is down
+func (s *SimNetwork) Node(i int) *Node {
+	return s.nodes[i].node
+}
+
+// Address returns the address of the i-th node, which does not change on restart
+func (s *SimNetwork) Address(i int) NodeAddress {
+	return NodeAddress(s.nodes[i].hostPort)
+}
+
+// Alive returns the indexes of the running nodes
+func (s *SimNetwork) Alive() []int {
+	alive := []int{}
+	for i, sn := range s.nodes {
+		if sn.node != nil {
+			alive = append(alive, i)
+		}
+	}
+	return alive
+}
+
+/*
+* @description: Add a node and join it to the ring through the first running
+*				node, or create the ring if none is running. Node names are
+*				chosen so that no two nodes share an identifier.
+* @return: 		the index of the node
+ */
+func (s *SimNetwork) AddNode() (int, error) {
+	index := len(s.nodes)
+	name := ""
+	for attempt := 0; attempt < 1000 && name == ""; attempt++ {
+		candidate := fmt.Sprintf("s%d.%d", index, attempt)
+		if id := Identifier(candidate).String(); !s.used[id] {
+			s.used[id] = true
+			name = candidate
+		}
+	}
+	if name == "" {
+		return 0, errors.New("no free identifier left for a new node")
+	}
+	port := simBasePort + index
+	sn := &simNode{name: name, port: port, hostPort: fmt.Sprintf("127.0.0.1:%d", port)}
+	s.nodes = append(s.nodes, sn)
+	s.byHost[sn.hostPort] = sn
+	return index, s.start(sn)
+}
+
+// Kill crashes the i-th node, its memory storage is lost
+func (s *SimNetwork) Kill(i int) error {
+	sn := s.nodes[i]
+	if sn.node == nil {
+		return fmt.Errorf("node %d is not running", i)
+	}
+	s.stop(sn)
+	return nil
+}
+
+// stop closes the connections and the listener of the node of sn at once, as a crash would
+func (s *SimNetwork) stop(sn *simNode) {
+	s.pool.closeProcess(sn.hostPort)
+	sn.node.Stop()
+	sn.node = nil
+	sn.generation++
+}
+
+// Restart starts the crashed i-th node again, empty, with the same name and address
+func (s *SimNetwork) Restart(i int) error {
+	sn := s.nodes[i]
+	if sn.node != nil {
+		return fmt.Errorf("node %d is running", i)
+	}
+	return s.start(sn)
+}
+
+/*
+* @description: Split the network: nodes of different groups cannot reach each
+*				other, nodes in no group form one more group
+ */
+func (s *SimNetwork) Partition(groups ...[]int) {
+	s.groups = make(map[string]int)
+	for g, group := range groups {
+		for _, i := range group {
+			s.groups[s.nodes[i].hostPort] = g + 1
+		}
+	}
+}
+
+// Heal removes the partition
+func (s *SimNetwork) Heal() {
+	s.groups = nil
+}
+
+// SetDropRate changes the probability that a message is lost
+func (s *SimNetwork) SetDropRate(rate float64) {
+	s.config.DropRate = rate
+}
+
+// start creates the node of sn, joins it and schedules its periodic tasks
+func (s *SimNetwork) start(sn *simNode) error {
+	cfg := s.node
+	cfg.Name = sn.name
+	cfg.Port = sn.port
+	node, err := NewNode(cfg)
+	if err == nil {
+		err = node.registerRPC()
+	}
+	if err != nil {
+		return err
+	}
+	node.limits.now = s.clock
+	listener, err := s.Listen(context.Background(), sn.hostPort)
+	if err != nil {
+		return err
+	}
+	node.serve(listener)
+
+	join := ""
+	for _, other := range s.nodes {
+		if other.node != nil && other != sn {
+			join = other.hostPort
+			break
+		}
+	}
+	// The node is reachable while it joins, like a listening process
+	sn.node = node
+	sn.generation++
+	if join == "" {
+		node.CreateChord()
+	} else {
+		err = node.joinChord(context.Background(), NodeAddress(join))
+		if err != nil {
+			s.stop(sn)
+			return fmt.Errorf("join %s: %w", join, err)
+		}
+	}
+
+	// Random offsets keep the nodes from running their tasks in lockstep
+	for _, task := range []struct {
+		name   string
+		period time.Duration
+		run    func(ctx context.Context) error
+	}{
+		{"stabilize", cfg.Stabilize, node.stabilize},
+		{"fix_fingers", cfg.FixFingers, node.fixFingers},
+		{"check_predecessor", cfg.CheckPredecessor, node.checkPredecessor},
+	} {
+		s.seq++
+		heap.Push(&s.tasks, &simTask{
+			at:         s.now + time.Duration(s.rand.Int63n(int64(task.period))),
+			seq:        s.seq,
+			sim:        sn,
+			generation: sn.generation,
+			name:       task.name,
+			period:     task.period,
+			run:        task.run,
+		})
+	}
+	return nil
+}
+
+/*
+* @description: Advance the virtual clock by d, running the periodic tasks due
+*				in order. A task that makes calls moves the clock on, so the
+*				tasks due meanwhile run late, one after the other.
+ */
+func (s *SimNetwork) Run(d time.Duration) {
+	end := s.now + d
+	for s.tasks.Len() > 0 && s.tasks[0].at <= end {
+		task := heap.Pop(&s.tasks).(*simTask)
+		if task.generation != task.sim.generation {
+			// The node was killed or restarted
+			continue
+		}
+		if task.at > s.now {
+			s.now = task.at
+		}
+		task.sim.node.observeTask(task.name, task.run)
+
+		s.seq++
+		task.at += task.period
+		task.seq = s.seq
+		heap.Push(&s.tasks, task)
+	}
+	if s.now < end {
+		s.now = end
+	}
+}
+
+/*
+* @description: Run fn on behalf of an observer: the calls it makes, and those
+*				made by the nodes while serving them, are instant and reliable
+ */
+func (s *SimNetwork) Observe(fn func()) {
+	s.observe++
+	defer func() { s.observe-- }()
+	fn()
+}
+
+// delay is the time one message spends on the network
+func (s *SimNetwork) delay() time.Duration {
+	d := s.config.Latency
+	if s.config.Jitter > 0 {
+		d += time.Duration(s.rand.Int63n(int64(s.config.Jitter) + 1))
+	}
+	return d
+}
+
+// lost draws whether a message is lost
+func (s *SimNetwork) lost() bool {
+	return s.config.DropRate > 0 && s.rand.Float64() < s.config.DropRate
+}
+
+// clock is the virtual time as a time.Time, the clock of the rate limiters of the nodes
+func (s *SimNetwork) clock() time.Time {
+	return time.Unix(0, 0).Add(s.now)
+}
+
+/*
+* @description: Deliver a call made through ChordCall, see SimNetwork. The
+*				caller is the node in ctx, as a node marks the calls it makes.
+ */
+func (s *SimNetwork) Call(ctx context.Context, hostPort string, serviceMethod string, request interface{}, reply interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	caller := ""
+	if node := callingNode(ctx); node != nil {
+		caller, _ = splitAddress(node.Address)
+	}
+	observed := caller == "" || s.observe > 0
+	target := s.byHost[hostPort]
+
+	if !observed {
+		s.stats.Calls++
+		if target == nil || target.node == nil {
+			s.stats.Refused++
+			s.now += s.delay()
+			return fmt.Errorf("dial %s: %w", hostPort, ErrSimRefused)
+		}
+		if s.groups != nil && s.groups[caller] != s.groups[hostPort] || s.lost() {
+			s.stats.Dropped++
+			s.now += s.config.CallTimeout
+			return fmt.Errorf("%s: %w", serviceMethod, ErrSimTimeout)
+		}
+		s.now += s.delay()
+	} else if target == nil || target.node == nil {
+		return fmt.Errorf("dial %s: %w", hostPort, ErrSimRefused)
+	}
+
+	// The reply of a node is only filled in if it is not lost
+	received := reply
+	if !observed && reply != nil {
+		received = reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+	}
+	err := s.pool.Call(ctx, hostPort, serviceMethod, request, received)
+
+	if !observed {
+		if s.lost() {
+			// The request took effect, only the reply is lost
+			s.stats.Dropped++
+			s.now += s.config.CallTimeout
+			return fmt.Errorf("%s: %w", serviceMethod, ErrSimTimeout)
+		}
+		s.now += s.delay()
+		if err == nil && reply != nil {
+			reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(received).Elem())
+		}
+	}
+	return err
+}
+
+/*------------------------------------------------------------*/
+/*                   In-memory Connections                    */
+/*------------------------------------------------------------*/
+
+// Listen returns a listener of the process at hostPort, reached by the calls of the network
+func (s *SimNetwork) Listen(ctx context.Context, hostPort string) (net.Listener, error) {
+	if _, ok := s.listeners[hostPort]; ok {
+		return nil, fmt.Errorf("listen %s: address already in use", hostPort)
+	}
+	addr, err := net.ResolveTCPAddr("tcp", hostPort)
+	if err != nil {
+		return nil, err
+	}
+	l := &simListener{sim: s, addr: addr, conns: make(chan net.Conn), closed: make(chan struct{})}
+	s.listeners[hostPort] = l
+	return l, nil
+}
+
+// dial opens an in-memory connection from the node in ctx, or a client, to the listener of hostPort
+func (s *SimNetwork) dial(ctx context.Context, hostPort string) (net.Conn, error) {
+	l := s.listeners[hostPort]
+	if l == nil {
+		return nil, fmt.Errorf("dial %s: %w", hostPort, ErrSimRefused)
+	}
+	from := "127.0.0.1:0"
+	if node := callingNode(ctx); node != nil {
+		from, _ = splitAddress(node.Address)
+	}
+	local, err := net.ResolveTCPAddr("tcp", from)
+	if err != nil {
+		return nil, err
+	}
+	client, server := net.Pipe()
+	select {
+	case l.conns <- &simConn{Conn: server, local: l.addr, remote: local}:
+		return &simConn{Conn: client, local: local, remote: l.addr}, nil
+	case <-l.closed:
+		client.Close()
+		server.Close()
+		return nil, fmt.Errorf("dial %s: %w", hostPort, ErrSimRefused)
+	case <-ctx.Done():
+		client.Close()
+		server.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// simListener hands the connections dialed by a SimNetwork to HandleConnection
+type simListener struct {
+	sim    *SimNetwork
+	addr   *net.TCPAddr
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (l *simListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting, the process can listen again
+func (l *simListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+		if l.sim.listeners[l.addr.String()] == l {
+			delete(l.sim.listeners, l.addr.String())
+		}
+	})
+	return nil
+}
+
+func (l *simListener) Addr() net.Addr {
+	return l.addr
+}
+
+// simConn is one end of an in-memory connection with the addresses of the processes
+type simConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *simConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *simConn) RemoteAddr() net.Addr {
+	return c.remote
+}
@@ -0,0 +1,91 @@
+package chord
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScenarioReplaysWithSeed(t *testing.T) {
+	run := func() *ScenarioResult {
+		t.Helper()
+		scenario := ChurnScenario(3, 5, 6, 10*time.Second)
+		scenario.Keys = 8
+		result, err := RunScenario(SimConfig{Seed: 3, Jitter: time.Millisecond, DropRate: 0.05}, scenario)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	first, second := run(), run()
+	if len(first.Violations) > 0 {
+		t.Fatalf("invariants violated: %v", first.Violations)
+	}
+	if first.Stats.Dropped == 0 {
+		t.Fatal("no message dropped at a drop rate of 5%")
+	}
+	if first.Duration != second.Duration || first.Converged != second.Converged || first.Stats != second.Stats ||
+		!reflect.DeepEqual(first.Failed, second.Failed) || !reflect.DeepEqual(first.Report.Members, second.Report.Members) {
+		t.Fatalf("same seed, different runs:\n%+v\n%+v", first, second)
+	}
+}
+
+func TestSimNetworkDropAndPartition(t *testing.T) {
+	s, err := NewSimNetwork(SimConfig{Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := s.AddNode(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Run(time.Minute)
+	// call asks node to for its name on behalf of node from, as the nodes call each other
+	call := func(from, to int) error {
+		var reply GetNameRPCReply
+		ctx := contextWithNode(context.Background(), s.Node(from))
+		return s.Call(ctx, string(s.Address(to)), "Node.GetNameRPC", "", &reply)
+	}
+	if err := call(0, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Partition([]int{0, 1})
+	before := s.Stats()
+	if err := call(0, 2); !errors.Is(err, ErrSimTimeout) {
+		t.Fatalf("call across the partition = %v, want ErrSimTimeout", err)
+	}
+	if err := call(0, 1); err != nil {
+		t.Fatalf("call inside a group = %v", err)
+	}
+	if dropped := s.Stats().Dropped - before.Dropped; dropped != 1 {
+		t.Fatalf("%d calls dropped, want 1", dropped)
+	}
+	s.Heal()
+	if err := call(0, 2); err != nil {
+		t.Fatalf("call after the partition healed = %v", err)
+	}
+
+	// Observers are not affected, nodes are
+	s.SetDropRate(0.999)
+	s.Observe(func() {
+		if err := call(0, 2); err != nil {
+			t.Errorf("observed call = %v", err)
+		}
+	})
+	if err := call(0, 2); !errors.Is(err, ErrSimTimeout) {
+		t.Fatalf("call at a drop rate of 0.999 = %v, want ErrSimTimeout", err)
+	}
+	s.SetDropRate(0)
+
+	if err := s.Kill(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := call(0, 2); !errors.Is(err, ErrSimRefused) {
+		t.Fatalf("call to a killed node = %v, want ErrSimRefused", err)
+	}
+}
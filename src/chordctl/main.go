@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/AlexwellChen/chord"
+)
+
+// chordctl reads and writes data on a Chord ring without joining it
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage: chordctl [-b address] [-timeout duration] [-trace-file file] [-wire format] command args...
+
+Commands:
+  lookup <key>             Print the address of the node responsible for key
+  put <key> <file|->       Store the content of file (or stdin) under key
+  get <key> [file]         Write the value of key to file (or stdout)
+  delete <key>             Delete key
+  storefile <path>         Upload a local file, print its key
+  getfile <key> <path>     Download the file stored under key to path
+  state                    Print the state of the bootstrap node as JSON
+  load [--json]            Print key counts, bytes and owned ID space of every node
+  ring [--dot]             Crawl the ring, print membership and problems as JSON (or Graphviz DOT)
+  setpredecessor <address> Set the predecessor of the bootstrap node, needs -admin-secret
+
+Flags:`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	bootstrap := flag.String("b", "localhost:8000", "Address of any node in the ring")
+	timeout := flag.Duration("timeout", 10*time.Second, "Timeout of the whole command")
+	traceFile := flag.String("trace-file", "", "Append the spans of the command to this file")
+	wire := flag.String("wire", chord.WireJSON, "Wire format of the calls: json or gob")
+	ring := flag.String("ring", "", "ID of the ring, needed with -secret by put and delete on a closed ring")
+	secret := flag.String("secret", os.Getenv("CHORD_SECRET"), "Shared secret of the ring, default $CHORD_SECRET")
+	adminSecret := flag.String("admin-secret", os.Getenv("CHORD_ADMIN_SECRET"), "Admin secret of the ring, needed by setpredecessor, default $CHORD_ADMIN_SECRET")
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 1 || (len(args) < 2 && args[0] != "state" && args[0] != "ring" && args[0] != "load") {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := chord.SetWireFormat(*wire); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	chord.SetCredentials(*ring, *secret)
+	chord.SetAdminSecret(*adminSecret)
+
+	var exporter *chord.FileSpanExporter
+	if *traceFile != "" {
+		var err error
+		exporter, err = chord.NewFileSpanExporter(*traceFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		chord.SetSpanExporter(exporter)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	client, err := chord.DialContext(ctx, chord.NodeAddress(*bootstrap))
+	if err == nil {
+		err = run(ctx, client, args[0], args[1:])
+	}
+	cancel()
+	if exporter != nil {
+		// Flush the spans before exiting, failed commands are the interesting ones
+		exporter.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, client *chord.Client, command string, args []string) error {
+	switch command {
+	case "lookup":
+		addr, err := client.Lookup(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println(addr)
+	case "put":
+		if len(args) < 2 {
+			return fmt.Errorf("put needs a key and a file")
+		}
+		var value []byte
+		var err error
+		if args[1] == "-" {
+			value, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			value, err = ioutil.ReadFile(args[1])
+		}
+		if err != nil {
+			return err
+		}
+		return client.Put(ctx, args[0], value)
+	case "get":
+		value, err := client.Get(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		if len(args) > 1 {
+			return ioutil.WriteFile(args[1], value, 0644)
+		}
+		_, err = os.Stdout.Write(value)
+		return err
+	case "delete":
+		return client.Delete(ctx, args[0])
+	case "storefile":
+		key, err := client.StoreFile(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+	case "getfile":
+		if len(args) < 2 {
+			return fmt.Errorf("getfile needs a key and a path")
+		}
+		return client.GetFile(ctx, args[0], args[1])
+	case "load":
+		report, err := chord.RingLoad(ctx, client.Entry())
+		if err != nil {
+			return err
+		}
+		if len(args) > 0 && args[0] == "--json" {
+			data, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		fmt.Print(report.Text())
+	case "ring":
+		report, err := chord.CrawlRing(ctx, client.Entry())
+		if err != nil {
+			return err
+		}
+		if len(args) > 0 && args[0] == "--dot" {
+			fmt.Print(report.DOT())
+			return nil
+		}
+		data, err := report.JSON()
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case "setpredecessor":
+		var reply chord.SetPredecessorRPCReply
+		return chord.ChordCallContext(ctx, client.Entry(), "Node.SetPredecessorRPC", chord.NodeAddress(args[0]), &reply)
+	case "state":
+		state, err := client.State(ctx)
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(state, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	default:
+		return fmt.Errorf("unknown command: %s", command)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/AlexwellChen/chord"
+)
+
+// chordsim plays a random churn scenario on a simulated network and checks the ring afterwards
+
+func main() {
+	seed := flag.Int64("seed", 1, "Seed of the scenario and of the network, the same seed replays the same run")
+	nodes := flag.Int("nodes", 8, "Nodes of the initial ring")
+	events := flag.Int("events", 20, "Joins, crashes and restarts played after the ring settled")
+	interval := flag.Duration("interval", 10*time.Second, "Virtual time between two events")
+	keys := flag.Int("keys", 16, "Objects stored before the events")
+	latency := flag.Duration("latency", time.Millisecond, "One-way delay of a message")
+	jitter := flag.Duration("jitter", 0, "Random extra delay of a message")
+	drop := flag.Float64("drop", 0, "Probability that a message is lost while the events are played")
+	partition := flag.Bool("partition", false, "Split the ring in two halves during the middle third of the events")
+	settle := flag.Duration("settle", 2*time.Minute, "Virtual time the ring is given to converge after the last event")
+	verbose := flag.Bool("v", false, "Print the events and the final ring")
+	flag.Parse()
+	// Failed calls are expected under churn, only the outcome is of interest
+	chord.SetLogger(chord.NopLogger())
+
+	scenario := chord.ChurnScenario(*seed, *nodes, *events, *interval)
+	scenario.Keys = *keys
+	scenario.Settle = *settle
+	if *partition && *events > 0 {
+		half := []int{}
+		for i := 0; i < *nodes/2; i++ {
+			half = append(half, i)
+		}
+		split := chord.SimEvent{At: time.Duration(*events/3) * *interval, Kind: chord.SimPartition, Groups: [][]int{half}}
+		heal := chord.SimEvent{At: time.Duration(2**events/3) * *interval, Kind: chord.SimHeal}
+		scenario.Events = insertEvent(insertEvent(scenario.Events, split), heal)
+	}
+	if *verbose {
+		for _, event := range scenario.Events {
+			fmt.Println(event)
+		}
+	}
+
+	result, err := chord.RunScenario(chord.SimConfig{
+		Seed:     *seed,
+		Latency:  *latency,
+		Jitter:   *jitter,
+		DropRate: *drop,
+	}, scenario)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *verbose && result.Report != nil {
+		for _, member := range result.Report.Members {
+			fmt.Printf("%-16s id %-3s predecessor %s\n", member.Address, member.Id, member.Predecessor)
+		}
+	}
+	for _, failed := range result.Failed {
+		fmt.Println("Event failed:", failed)
+	}
+	fmt.Printf("Virtual time %v, %d calls, %d dropped, %d refused\n", result.Duration, result.Stats.Calls, result.Stats.Dropped, result.Stats.Refused)
+	if result.Converged >= 0 {
+		fmt.Printf("Converged %v after the last event\n", result.Converged)
+	} else {
+		fmt.Println("Did not converge")
+	}
+	if len(result.Violations) > 0 {
+		for _, violation := range result.Violations {
+			fmt.Println("Violation:", violation)
+		}
+		os.Exit(1)
+	}
+	fmt.Println("All invariants hold")
+}
+
+// insertEvent keeps the events ordered by time, event goes after those of the same time
+func insertEvent(events []chord.SimEvent, event chord.SimEvent) []chord.SimEvent {
+	i := 0
+	for i < len(events) && events[i].At <= event.At {
+		i++
+	}
+	events = append(events, chord.SimEvent{})
+	copy(events[i+1:], events[i:])
+	events[i] = event
+	return events
+}
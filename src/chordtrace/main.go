@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/AlexwellChen/chord"
+)
+
+// chordtrace collects the spans sent by nodes started with --trace-collector and prints traces
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage: chordtrace command args...
+
+Commands:
+  collect [-listen address] [-o file]  Accept OTLP/HTTP JSON spans on /v1/traces, append them to file
+  list <file>                          List the traces found in a span file
+  show <file> <trace id>               Print the spans of a trace as a tree
+
+Span files are written by "collect" or by nodes started with --trace-file.`)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "collect":
+		err = collect(os.Args[2:])
+	case "list":
+		if len(os.Args) != 3 {
+			usage()
+			os.Exit(2)
+		}
+		err = list(os.Args[2])
+	case "show":
+		if len(os.Args) != 4 {
+			usage()
+			os.Exit(2)
+		}
+		err = show(os.Args[2], os.Args[3])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func collect(args []string) error {
+	flags := flag.NewFlagSet("collect", flag.ExitOnError)
+	listen := flags.String("listen", ":4318", "Address to accept spans on")
+	output := flags.String("o", "spans.jsonl", "File the spans are appended to")
+	flags.Parse(args)
+
+	exporter, err := chord.NewFileSpanExporter(*output)
+	if err != nil {
+		return err
+	}
+	defer exporter.Close()
+	mux := http.NewServeMux()
+	mux.Handle("/v1/traces", chord.CollectorHandler(exporter))
+	fmt.Fprintln(os.Stderr, "Collecting spans on", *listen, "into", *output)
+	return http.ListenAndServe(*listen, mux)
+}
+
+// Fixed width so that the columns line up
+const startLayout = "2006-01-02T15:04:05.000000Z07:00"
+
+func list(path string) error {
+	spans, err := chord.ReadSpans(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-32s  %-27s  %-20s  %-16s  %5s  %6s\n", "TRACE", "START", "ROOT", "NODE", "SPANS", "ERRORS")
+	for _, trace := range chord.ListTraces(spans) {
+		fmt.Printf("%-32s  %-27s  %-20s  %-16s  %5d  %6d\n", trace.TraceId, trace.Start.Format(time.RFC3339Nano), trace.Root, trace.Node, trace.Spans, trace.Errors)
+	}
+	return nil
+}
+
+func show(path string, traceId string) error {
+	spans, err := chord.ReadSpans(path)
+	if err != nil {
+		return err
+	}
+	tree, err := chord.TraceTree(spans, traceId)
+	if err != nil {
+		return err
+	}
+	fmt.Print(tree)
+	return nil
+}
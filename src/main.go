@@ -2,6 +2,8 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -12,8 +14,21 @@ import (
 func main() {
 	// Parse command line arguments
 	Arguments := chord.GetCmdArgs()
-	fmt.Println(Arguments)
-	node := chord.StartChord(Arguments)
+	level, err := chord.ParseLevel(Arguments.LogLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid command line arguments:", err)
+		os.Exit(1)
+	}
+	if Arguments.LogFormat != chord.LogFormatText && Arguments.LogFormat != chord.LogFormatJSON {
+		fmt.Fprintln(os.Stderr, "Invalid command line arguments: unknown log format", Arguments.LogFormat)
+		os.Exit(1)
+	}
+	chord.SetLogger(chord.NewLogger(os.Stderr, level, Arguments.LogFormat))
+	node, err := chord.Start(context.Background(), Arguments.Config())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Start node failed:", err)
+		os.Exit(1)
+	}
 	// Get user input for printing states
 	reader := bufio.NewReader(os.Stdin)
 	for {
@@ -21,8 +36,35 @@ func main() {
 		command, _ := reader.ReadString('\n')
 		command = strings.TrimSpace(command)
 		command = strings.ToUpper(command)
-		if command == "PRINTSTATE" || command == "PS" {
+		if command == "PRINTSTATE" || command == "PS" || command == "STATE" {
 			node.PrintState()
+		} else if command == "STATE --JSON" {
+			err := node.PrintStateJSON()
+			if err != nil {
+				fmt.Println(err)
+			}
+		} else if command == "RING" || command == "RING --JSON" || command == "RING --DOT" {
+			// Crawl the whole ring starting from this node
+			report, err := chord.CrawlRing(context.Background(), node.Address)
+			if err != nil {
+				fmt.Println(err)
+			} else if command == "RING --DOT" {
+				fmt.Print(report.DOT())
+			} else {
+				data, _ := report.JSON()
+				fmt.Println(string(data))
+			}
+		} else if command == "LOAD" || command == "LOAD --JSON" {
+			// Aggregate key and ID space distribution over the ring
+			report, err := chord.RingLoad(context.Background(), node.Address)
+			if err != nil {
+				fmt.Println(err)
+			} else if command == "LOAD --JSON" {
+				data, _ := json.MarshalIndent(report, "", "  ")
+				fmt.Println(string(data))
+			} else {
+				fmt.Print(report.Text())
+			}
 		} else if command == "LOOKUP" || command == "L" {
 			fmt.Println("Please enter the key you want to lookup")
 			key, _ := reader.ReadString('\n')
@@ -59,11 +101,11 @@ func main() {
 			fmt.Println("Please enter the file name you want to store")
 			fileName, _ := reader.ReadString('\n')
 			fileName = strings.TrimSpace(fileName)
-			err := chord.ClientStoreFile(fileName, node)
+			key, err := chord.ClientStoreFile(fileName, node)
 			if err != nil {
 				fmt.Print(err)
 			} else {
-				fmt.Println("Store file success")
+				fmt.Println("Store file success, key: ", key)
 			}
 		} else if command == "QUIT" || command == "Q" {
 			// Quit the program
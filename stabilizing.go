@@ -1,14 +1,10 @@
 package chord
 
 import (
-	"crypto/sha1"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"math/big"
-	"net/rpc/jsonrpc"
-	"os"
-	"strings"
 )
 
 /*
@@ -21,43 +17,68 @@ import (
 
 // verifies n’s immediate
 func (node *Node) Stablize() error {
+	return node.stabilize(context.Background())
+}
+
+// stabilize is Stablize with the RPCs traced as children of the span in ctx
+func (node *Node) stabilize(ctx context.Context) error {
 	// fmt.Println("***************** Invoke stablize function *****************")
+	// The successor takes the backup RPCs only from its predecessor, see authorize
+	ctx = contextWithNode(ctx, node)
 
 	// First request the successor list of your successor[0]
+	// The list is copied, node.mutex is never held across an RPC
+	list := node.getSuccessorList()
 	var getSuccessorListRPCReply GetSuccessorListRPCReply
-	err := ChordCall(node.Successors[0], "Node.GetSuccessorListRPC", struct{}{}, &getSuccessorListRPCReply)
+	err := ChordCallContext(ctx, list[0], "Node.GetSuccessorListRPC", struct{}{}, &getSuccessorListRPCReply)
+	if err != nil && !answered(err) && list[0] != "" {
+		// One lost message must not drop a live successor, it is asked twice
+		err = ChordCallContext(ctx, list[0], "Node.GetSuccessorListRPC", struct{}{}, &getSuccessorListRPCReply)
+	}
 	successors := getSuccessorListRPCReply.SuccessorList
+	if answered(err) {
+		// The successor is alive, it is asked again next time
+		node.logger().Warn("GetSuccessorList refused", F(FieldMethod, "GetSuccessorListRPC"), F(FieldPeer, list[0]), Err(err))
+		return nil
+	}
 	if err == nil {
-		for i := 0; i < len(successors)-1; i++ {
-			node.Successors[i+1] = successors[i]
+		for i := 0; i < len(successors)-1 && i+1 < len(list); i++ {
+			list[i+1] = successors[i]
 		}
+		node.setSuccessorList(list)
 	} else {
-		fmt.Println("GetSuccessorList failed")
-		if node.Successors[0] == "" {
+		node.logger().Warn("GetSuccessorList failed", F(FieldMethod, "GetSuccessorListRPC"), F(FieldPeer, list[0]), Err(err))
+		if list[0] == "" {
 			// No successor, use self as successor
-			fmt.Println("Node Successor[0] is empty -> use self as successor")
-			node.Successors[0] = node.Address
+			node.logger().Info("Successor[0] is empty, use self as successor")
+			node.setSuccessor(node.Address)
 		} else {
 			// Successor[0] might be dead, remove it from the list, and shift the list
-			for i := 0; i < len(node.Successors); i++ {
-				if i == len(node.Successors)-1 {
-					node.Successors[i] = ""
+			for i := 0; i < len(list); i++ {
+				if i == len(list)-1 {
+					list[i] = ""
 				} else {
-					node.Successors[i] = node.Successors[i+1]
+					list[i] = list[i+1]
 				}
 			}
+			node.setSuccessorList(list)
+			if list[0] == "" {
+				// The list ran out, maybe on lost messages only, another node may still know the way back
+				node.recoverSuccessor(ctx)
+			}
 		}
 	}
 
+	successor := node.getSuccessor()
 	var getPredecessorRPCReply GetPredecessorRPCReply
-	err = ChordCall(node.Successors[0], "Node.GetPredecessorRPC", struct{}{}, &getPredecessorRPCReply)
+	err = ChordCallContext(ctx, successor, "Node.GetPredecessorRPC", struct{}{}, &getPredecessorRPCReply)
 	if err == nil {
 		// Get successor's name
 		var successorName string
 		var getSuccessorNameRPCReply GetNameRPCReply
-		err = ChordCall(node.Successors[0], "Node.GetNameRPC", "", &getSuccessorNameRPCReply)
+		err = ChordCallContext(ctx, successor, "Node.GetNameRPC", "", &getSuccessorNameRPCReply)
 		if err != nil {
-			fmt.Println("Get successor[0] name failed")
+			node.logger().Warn("Get successor[0] name failed", F(FieldMethod, "GetNameRPC"), F(FieldPeer, successor), Err(err))
 			return err
 		}
 		successorName = getSuccessorNameRPCReply.Name
@@ -65,9 +86,9 @@ func (node *Node) Stablize() error {
 		// Get predecessor's name
 		predecessorAddr := getPredecessorRPCReply.PredecessorAddress
 		var getNameReply GetNameRPCReply
-		err = ChordCall(predecessorAddr, "Node.GetNameRPC", "", &getNameReply)
+		err = ChordCallContext(ctx, predecessorAddr, "Node.GetNameRPC", "", &getNameReply)
 		if err != nil {
-			fmt.Println("Get predecessor name failed: ", err)
+			node.logger().Warn("Get predecessor name failed", F(FieldMethod, "GetNameRPC"), F(FieldPeer, predecessorAddr), Err(err))
 			return err
 		}
 		predecessorName := getNameReply.Name
@@ -79,82 +100,117 @@ func (node *Node) Stablize() error {
 		successorId.Mod(successorId, hashMod)
 		if predecessorAddr != "" && between(nodeId,
 			predecessorId, successorId, false) {
-			node.Successors[0] = predecessorAddr
+			node.setSuccessor(predecessorAddr)
 		}
 	}
-	ChordCall(node.Successors[0], "Node.NotifyRPC", node.Address, &NotifyRPCReply{})
+	successor = node.getSuccessor()
+	ChordCallContext(ctx, successor, "Node.NotifyRPC", node.Address, &NotifyRPCReply{})
 
 	// fmt.Println("------------DO COPY NODE BUCKET TO SUCCESSOR[0]------------")
+	// Verify the bucket while successor's backup can still repair corrupt files
+	if successor != node.Address {
+		node.Bucket.Range(node.Identifier, node.Identifier, func(k *big.Int, v string) bool {
+			_, err := node.readBucketFile(k, v)
+			if err != nil {
+				node.logger().Warn("Verify bucket file failed", F("file", v), Err(err))
+			}
+			return true
+		})
+	}
 	// First empty successor's backup
 	deleteSuccessorBackupRPCReply := DeleteSuccessorBackupRPCReply{}
-	err = ChordCall(node.Successors[0], "Node.DeleteSuccessorBackupRPC", struct{}{}, &deleteSuccessorBackupRPCReply)
+	err = ChordCallContext(ctx, successor, "Node.DeleteSuccessorBackupRPC", struct{}{}, &deleteSuccessorBackupRPCReply)
 	if err != nil {
-		fmt.Println("empty successor backup failed")
+		node.logger().Warn("Empty successor backup failed", F(FieldMethod, "DeleteSuccessorBackupRPC"), F(FieldPeer, successor), Err(err))
 		return err
 	}
 
 	// If only one node in the network, do not copy backup
-	if node.Successors[0] == node.Address {
+	if successor == node.Address {
 		return nil
 	}
-	lastValue := ""
 	// Iterate through node's bucket, copy file to successor[0]'s backup
-	for k, v := range node.Bucket {
+	var copyErr error
+	node.Bucket.Range(node.Identifier, node.Identifier, func(k *big.Int, v string) bool {
 		newFile := FileRPC{}
-		newFile.Name = v
 		newFile.Id = k
-		// check loop
-		if v == lastValue {
-			break
+		newFile.Name = v
+		newFile.Content, copyErr = node.Bucket.Get(v)
+		if errors.Is(copyErr, ErrCorrupt) {
+			// Could not be repaired, never spread a corrupt copy
+			node.logger().Warn("Copy to backup: skip corrupt file", F("file", v))
+			copyErr = nil
+			return true
 		}
-		if v != "" {
-			lastValue = v
-			filepath := "tmp/" + node.Name + "/chord_storage/" + v
-			file, err := os.Open(filepath)
-			if err != nil {
-				fmt.Println("Copy to backup: open file failed: ", err)
-				return err
-			}
-			defer file.Close()
-			newFile.Content, err = ioutil.ReadAll(file)
-			if err != nil {
-				fmt.Println("Copy to backup: read file failed: ", err)
-				return err
-			} else {
-				reply := new(SuccessorStoreFileRPCReply)
-				err = ChordCall(node.Successors[0], "Node.SuccessorStoreFileRPC", newFile, &reply)
-				if reply.Err != nil && err != nil {
-					fmt.Println("Copy to backup: store file failed: ", reply.Err, " and ", err)
-				}
-			}
+		if copyErr != nil {
+			node.logger().Error("Copy to backup: read file failed", F("file", v), Err(copyErr))
+			return false
 		}
-	}
-
-	// Clean redundant files
-	node.cleanRedundantFile()
-	return nil
+		newFile.Checksum = contentChecksum(newFile.Content)
+		reply := new(SuccessorStoreFileRPCReply)
+		err := ChordCallContext(ctx, successor, "Node.SuccessorStoreFileRPC", newFile, reply)
+		if err != nil {
+			node.logger().Warn("Copy to backup: store file failed", F(FieldMethod, "SuccessorStoreFileRPC"), F(FieldPeer, successor), F("file", v), Err(err))
+		}
+		return true
+	})
+	return copyErr
 }
 
 // check whether predecessor has failed
 func (node *Node) CheckPredecessor() error {
+	return node.checkPredecessor(context.Background())
+}
+
+/*
+* @description: Look up the successor of node through its predecessor and
+*				fingers when its successor list ran out. Successors[0] stays
+*				empty if no lookup succeeds, the node is then alone.
+ */
+func (node *Node) recoverSuccessor(ctx context.Context) {
+	next := new(big.Int).Add(node.Identifier, big.NewInt(1))
+	candidates := []NodeAddress{node.getPredecessor()}
+	for _, finger := range node.getFingerTable() {
+		candidates = append(candidates, finger.Address)
+	}
+	for _, candidate := range candidates {
+		if candidate == "" || candidate == node.Address {
+			continue
+		}
+		found, err := findContext(ctx, new(big.Int).Mod(next, hashMod), candidate)
+		if err == nil && found != "" && found != node.Address {
+			node.logger().Info("Recovered successor", F(FieldPeer, found), F("via", candidate))
+			node.setSuccessor(found)
+			return
+		}
+	}
+}
+
+func (node *Node) checkPredecessor(ctx context.Context) error {
+	ctx = contextWithNode(ctx, node)
 	// fmt.Println("************* Invoke checkPredecessor function **************")
-	pred := node.Predecessor
+	pred := node.getPredecessor()
 	if pred != "" {
-		//check connection
-		ip := strings.Split(string(pred), ":")[0]
-		port := strings.Split(string(pred), ":")[1]
-
-		predAddr := ip + ":" + port
-		_, err := jsonrpc.Dial("tcp", predAddr)
-		if err != nil {
-			fmt.Printf("Predecessor %s has failed\n", string(pred))
-			node.Predecessor = ""
+		//check connection, the predecessor may be a virtual node behind a shared listener
+		var reply GetNameRPCReply
+		err := ChordCallContext(ctx, pred, "Node.GetNameRPC", "", &reply)
+		if err != nil && !answered(err) {
+			node.logger().Warn("Predecessor has failed", F(FieldPeer, pred), Err(err))
+			node.clearPredecessor(pred)
 			// fmt.Println("------------DO COPY BUCKUP TO BUCKET------------")
-			for k, v := range node.Backup {
-				if v != "" {
-					node.Bucket[k] = v
+			node.Backup.Range(node.Identifier, node.Identifier, func(k *big.Int, v string) bool {
+				// A corrupt backup is skipped, its owner is gone so there is no healthy copy left
+				content, err := node.Backup.Get(v)
+				if err != nil {
+					node.logger().Error("Copy backup to bucket failed", F("file", v), Err(err))
+					return true
 				}
-			}
+				err = node.Bucket.Put(k, v, content)
+				if err != nil {
+					node.logger().Error("Copy backup to bucket failed", F("file", v), Err(err))
+				}
+				return true
+			})
 
 		}
 	}
@@ -179,6 +235,11 @@ func (node *Node) fingerEntry(fingerentry int) *big.Int {
 
 // refreshes finger table entries, next stores the index of the next finger to fix
 func (node *Node) FixFingers() error {
+	return node.fixFingers(context.Background())
+}
+
+func (node *Node) fixFingers(ctx context.Context) error {
+	ctx = contextWithNode(ctx, node)
 	// fmt.Println("*************** Invoke fixfinger function ***************")
 	// Lock node.next
 
@@ -195,27 +256,28 @@ func (node *Node) FixFingers() error {
 	id := node.fingerEntry(node.next)
 	//find successor of id
 	result := FindSuccessorRPCReply{}
-	err := ChordCall(node.Address, "Node.FindSuccessorRPC", id, &result)
+	err := ChordCallContext(ctx, node.Address, "Node.FindSuccessorRPC", id, &result)
 	if !result.Found {
-		fmt.Println("FindSuccessorRPC failed:", result)
+		node.logger().Debug("FindSuccessorRPC failed", F(FieldMethod, "FindSuccessorRPC"), F("finger", node.next), F("result", result.SuccessorAddress))
 	}
 	if err != nil {
-		fmt.Println("Find successor failed")
+		node.logger().Warn("Find successor failed", F(FieldMethod, "FindSuccessorRPC"), F("finger", node.next), Err(err))
 		return err
 	}
 	// Get successor's name
 	var getSuccessorNameRPCReply GetNameRPCReply
-	err = ChordCall(result.SuccessorAddress, "Node.GetNameRPC", "", &getSuccessorNameRPCReply)
+	err = ChordCallContext(ctx, result.SuccessorAddress, "Node.GetNameRPC", "", &getSuccessorNameRPCReply)
 	if err != nil {
-		fmt.Println("node.Next: ", node.next)
-		fmt.Println("Fix finger get successor name failed")
+		node.logger().Warn("Fix finger get successor name failed", F(FieldMethod, "GetNameRPC"), F(FieldPeer, result.SuccessorAddress), F("finger", node.next), Err(err))
 		return err
 	}
-	node.FingerTable[node.next].Id = id.Bytes()
-	if node.FingerTable[node.next].Address != result.SuccessorAddress && result.SuccessorAddress != "" {
-		fmt.Println("FingerTable[", node.next, "] = ", getSuccessorNameRPCReply.Name)
-		node.FingerTable[node.next].Address = result.SuccessorAddress
+	finger := node.getFinger(node.next)
+	finger.Id = id.Bytes()
+	if finger.Address != result.SuccessorAddress && result.SuccessorAddress != "" {
+		node.logger().Debug("Update finger", F("finger", node.next), F(FieldPeer, result.SuccessorAddress), F("name", getSuccessorNameRPCReply.Name))
+		finger.Address = result.SuccessorAddress
 	}
+	node.setFinger(node.next, finger)
 	//optimization, update other finger table entries use the first successor
 	for {
 		// node.mutex.Lock()
@@ -231,19 +293,18 @@ func (node *Node) FixFingers() error {
 		}
 		id := node.fingerEntry(node.next)
 		var getSuccessorNameRPCReply GetNameRPCReply
-		err := ChordCall(result.SuccessorAddress, "Node.GetNameRPC", "", &getSuccessorNameRPCReply)
+		err := ChordCallContext(ctx, result.SuccessorAddress, "Node.GetNameRPC", "", &getSuccessorNameRPCReply)
 		if err != nil {
-			fmt.Println("Get successor name failed")
+			node.logger().Warn("Get successor name failed", F(FieldMethod, "GetNameRPC"), F(FieldPeer, result.SuccessorAddress), Err(err))
 			return err
 		}
 		successorName := getSuccessorNameRPCReply.Name
 		successorId := StrHash(string(successorName))
 		successorId.Mod(successorId, hashMod)
 		if between(node.Identifier, id, successorId, false) && result.SuccessorAddress != "" {
-			if node.FingerTable[node.next].Address != result.SuccessorAddress && result.SuccessorAddress != "" {
-				node.FingerTable[node.next].Id = id.Bytes()
-				node.FingerTable[node.next].Address = result.SuccessorAddress
-				fmt.Println("FingerTable[", node.next, "] = ", result.SuccessorAddress)
+			if node.getFinger(node.next).Address != result.SuccessorAddress && result.SuccessorAddress != "" {
+				node.setFinger(node.next, fingerEntry{Id: id.Bytes(), Address: result.SuccessorAddress})
+				node.logger().Debug("Update finger", F("finger", node.next), F(FieldPeer, result.SuccessorAddress))
 			}
 		} else {
 			// node.mutex.Lock()
@@ -264,25 +325,25 @@ type NotifyRPCReply struct {
 }
 
 // 'address' thinks it might be our predecessor
-func (node *Node) notify(address NodeAddress) (bool, error) {
+func (node *Node) notify(ctx context.Context, address NodeAddress) (bool, error) {
 	// fmt.Println("***************** Invoke notify function ********************")
 	// if (predecessor is nil or n' ∈ (predecessor, n))
 	// Get predecessor name
-	if node.Predecessor != "" {
+	if predecessor := node.getPredecessor(); predecessor != "" {
 		predcessorName := ""
 		var getPredecessorNameRPCReply GetNameRPCReply
-		err := ChordCall(node.Predecessor, "Node.GetNameRPC", "", &getPredecessorNameRPCReply)
+		err := ChordCallContext(ctx, predecessor, "Node.GetNameRPC", "", &getPredecessorNameRPCReply)
 		if err != nil {
-			fmt.Println("Get predecessor name failed: ", err)
+			node.logger().Warn("Get predecessor name failed", F(FieldMethod, "GetNameRPC"), F(FieldPeer, predecessor), Err(err))
 			return false, err
 		}
 
 		// Get address name
 		addressName := ""
 		var getAddressNameRPCReply GetNameRPCReply
-		err = ChordCall(address, "Node.GetNameRPC", "", &getAddressNameRPCReply)
+		err = ChordCallContext(ctx, address, "Node.GetNameRPC", "", &getAddressNameRPCReply)
 		if err != nil {
-			fmt.Println("Get address name failed: ", err)
+			node.logger().Warn("Get address name failed", F(FieldMethod, "GetNameRPC"), F(FieldPeer, address), Err(err))
 			return false, err
 		}
 
@@ -297,82 +358,124 @@ func (node *Node) notify(address NodeAddress) (bool, error) {
 		nodeId := node.Identifier
 		if between(predcessorId, addressId, nodeId, false) {
 			//predecessor = n'
-			node.Predecessor = address
-			fmt.Println(node.Name, "'s Predecessor is set to", address)
+			node.setPredecessor(address)
+			node.logger().Info("Predecessor changed", F(FieldPeer, address))
 			return true, nil
 		} else {
 			return false, nil
 		}
 	} else {
-		node.Predecessor = address
-		fmt.Println(node.Name, "'s Predecessor is set to", address)
+		node.setPredecessor(address)
+		node.logger().Info("Predecessor changed", F(FieldPeer, address))
 		return true, nil
 	}
 
 }
 
-func (node *Node) moveFiles(addr NodeAddress) {
+func (node *Node) moveFiles(ctx context.Context, addr NodeAddress) {
 	// Parse local bucket
 	// Get address name
 	addressName := ""
 	var getAddressNameRPCReply GetNameRPCReply
-	err := ChordCall(addr, "Node.GetNameRPC", "", &getAddressNameRPCReply)
+	err := ChordCallContext(ctx, addr, "Node.GetNameRPC", "", &getAddressNameRPCReply)
 	if err != nil {
-		fmt.Println("Get address name failed: ", err)
+		node.logger().Warn("Get address name failed", F(FieldMethod, "GetNameRPC"), F(FieldPeer, addr), Err(err))
 		return
 	}
 	addressName = getAddressNameRPCReply.Name
 	addressId := StrHash(addressName)
 	addressId.Mod(addressId, hashMod)
 
-	// Iterate through local bucket
-	for key, element := range node.Bucket {
-		fileId := key
-		fileName := element
-		filepath := "tmp/" + node.Name + "/chord_storage/" + fileName
-		file, err := os.Open(filepath)
-		if err != nil {
-			fmt.Println("Cannot open the file")
-		}
-		defer file.Close()
+	// Iterate through the files that belong to addr: ids in (node.Identifier, addressId]
+	node.Bucket.Range(node.Identifier, addressId, func(fileId *big.Int, fileName string) bool {
 		// Init new file struct and put content into it
 		newFile := FileRPC{}
+		newFile.Id = fileId
 		newFile.Name = fileName
-		newFile.Content, err = ioutil.ReadAll(file)
+		newFile.Content, err = node.readBucketFile(fileId, fileName)
 		if err != nil {
-			fmt.Println("Cannot read the file")
+			node.logger().Error("Cannot read the file", F("file", fileName), Err(err))
+			return true
 		}
-		newFile.Id = key
-		if between(fileId, addressId, node.Identifier, true) && fileId.Cmp(node.Identifier) != 0 || fileId.Cmp(addressId) == 0 { // if file shouldn't be in this node or file should be in addressId node
-			//move file to new node
-			var moveFileRPCReply StoreFileRPCReply
-			moveFileRPCReply.Backup = false
-			// Move local file to new predecessor using storeFile function
-			err := ChordCall(addr, "Node.StoreFileRPC", newFile, &moveFileRPCReply)
-			if err != nil {
-				fmt.Println("Move file failed: ", err)
-			}
-			//delete file from local bucket
-			fmt.Println("FileId: ", fileId)
-			fmt.Println("addressId: ", addressId)
-			fmt.Println("Address is: ", addr)
-			fmt.Println("node.Identifier: ", node.Identifier)
-			delete(node.Bucket, key)
-			// delete file from local directory
-			err = os.Remove(filepath)
-			if err != nil {
-				fmt.Println("Cannot delete the file")
-			}
+		newFile.Checksum = contentChecksum(newFile.Content)
+		//move file to new node
+		var moveFileRPCReply StoreFileRPCReply
+		moveFileRPCReply.Backup = false
+		// Move local file to new predecessor using storeFile function
+		err := ChordCallContext(ctx, addr, "Node.StoreFileRPC", newFile, &moveFileRPCReply)
+		if err == nil && !moveFileRPCReply.Success {
+			err = errors.New(moveFileRPCReply.Err)
+		}
+		if err != nil {
+			// The file stays here, the next notify of the predecessor moves it again
+			node.logger().Warn("Move file failed", F(FieldMethod, "StoreFileRPC"), F(FieldPeer, addr), F("file", fileName), Err(err))
+			return true
+		}
+		//delete file from local bucket
+		node.logger().Debug("Moved file", F(FieldPeer, addr), F("id", fileId), F("peerId", addressId), F("file", fileName))
+		err = node.Bucket.Delete(fileName)
+		if err != nil {
+			node.logger().Error("Cannot delete the file", F("file", fileName), Err(err))
+		}
+		return true
+	})
+}
+
+/*
+* @description: Check with the ring that address may become the predecessor of
+*				node: its identifier must be between the predecessor and node,
+*				and a lookup of the identifier through successor[0] must end at
+*				address, or at node while the rest of the ring does not know
+*				address yet. The notifier itself is only asked for its name.
+* @return: 		false if address is not between the predecessor and node, an
+*				error wrapping ErrUnauthorized if the ring places it elsewhere
+ */
+func (node *Node) checkNotifier(ctx context.Context, address NodeAddress) (bool, error) {
+	var reply GetNameRPCReply
+	err := ChordCallContext(ctx, address, "Node.GetNameRPC", "", &reply)
+	if err != nil {
+		return false, fmt.Errorf("notifier %s cannot be reached: %w", address, err)
+	}
+	id := Identifier(reply.Name)
+	if predecessor := node.getPredecessor(); predecessor != "" {
+		var predecessorReply GetNameRPCReply
+		err = ChordCallContext(ctx, predecessor, "Node.GetNameRPC", "", &predecessorReply)
+		if err != nil {
+			return false, fmt.Errorf("predecessor %s cannot be reached: %w", predecessor, err)
+		}
+		if !between(Identifier(predecessorReply.Name), id, node.Identifier, false) {
+			return false, nil
 		}
 	}
+	found, err := findContext(ctx, new(big.Int).Set(id), node.getSuccessor())
+	if err != nil {
+		return false, fmt.Errorf("%w: cannot look up the identifier %s of notifier %s: %v", ErrUnauthorized, id, address, err)
+	}
+	if found != address && found != node.Address {
+		return false, fmt.Errorf("%w: the ring places identifier %s of notifier %s at %s", ErrUnauthorized, id, address, found)
+	}
+	return true, nil
 }
 
 func (node *Node) NotifyRPC(address NodeAddress, reply *NotifyRPCReply) error {
 	// fmt.Println("---------------- Invoke NotifyRPC function ------------------")
-	if node.Successors[0] != node.Address {
-		node.moveFiles(address)
+	ctx := contextWithNode(context.Background(), node)
+	// Files are moved to the notifier, so a new one is checked before anything else
+	if address != node.getPredecessor() {
+		accepted, err := node.checkNotifier(ctx, address)
+		if err != nil {
+			node.logger().Warn("Reject notify", F(FieldPeer, address), Err(err))
+			return err
+		}
+		if !accepted {
+			reply.Success = false
+			return nil
+		}
+	}
+	if node.getSuccessor() != node.Address {
+		node.moveFiles(ctx, address)
 	}
-	reply.Success, _ = node.notify(address)
+	reply.Success, _ = node.notify(ctx, address)
 	return nil
 }
 
@@ -381,10 +484,32 @@ type GetSuccessorListRPCReply struct {
 	SuccessorList []NodeAddress
 }
 
-// get node's successorList
+// get node's successorList, a copy taken under node.mutex
 func (node *Node) getSuccessorList() []NodeAddress {
 	// fmt.Println("************* Invoke getSuccessorList function **************")
-	return node.Successors[:]
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	return append([]NodeAddress(nil), node.Successors...)
+}
+
+// setSuccessorList replaces the successor list, keeping its length
+func (node *Node) setSuccessorList(successors []NodeAddress) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	copy(node.Successors, successors)
+}
+
+// get node's successor[0]
+func (node *Node) getSuccessor() NodeAddress {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	return node.Successors[0]
+}
+
+func (node *Node) setSuccessor(address NodeAddress) {
+	node.mutex.Lock()
+	node.Successors[0] = address
+	node.mutex.Unlock()
 }
 
 func (node *Node) GetSuccessorListRPC(none *struct{}, reply *GetSuccessorListRPCReply) error {
@@ -400,6 +525,8 @@ type GetPredecessorRPCReply struct {
 // get node's predecessor
 func (node *Node) getPredecessor() NodeAddress {
 	// fmt.Println("************** Invoke getPredecessor function ***************")
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
 	return node.Predecessor
 }
 func (node *Node) GetPredecessorRPC(none *struct{}, reply *GetPredecessorRPCReply) error {
@@ -418,16 +545,16 @@ type DeleteSuccessorBackupRPCReply struct {
 
 func (node *Node) deleteSuccessorBackup() bool {
 	// Iterate through successor's backup and delete all files
-	for key, _ := range node.Backup {
-
-		// filepath := "tmp/" + node.Name + "/chord_storage/" + fileName
-		// err := os.Remove(filepath)
-		// if err != nil {
-		// 	fmt.Println("Cannot delete file: ", fileName)
-		// }
-		delete(node.Backup, key)
-	}
-	return true
+	success := true
+	node.Backup.Range(node.Identifier, node.Identifier, func(_ *big.Int, fileName string) bool {
+		err := node.Backup.Delete(fileName)
+		if err != nil {
+			node.logger().Error("Cannot delete file", F("file", fileName), Err(err))
+			success = false
+		}
+		return true
+	})
+	return success
 }
 
 func (node *Node) DeleteSuccessorBackupRPC(none *struct{}, reply *DeleteSuccessorBackupRPCReply) error {
@@ -436,21 +563,34 @@ func (node *Node) DeleteSuccessorBackupRPC(none *struct{}, reply *DeleteSuccesso
 	return nil
 }
 
+// Delete a single file from the backup, called by the predecessor when the file is deleted
+func (node *Node) DeleteBackupFileRPC(f FileRPC, reply *DeleteFileRPCReply) error {
+	err := ValidateName(f.Name)
+	if err != nil {
+		return err
+	}
+	f.Id.Mod(f.Id, hashMod)
+	if !node.Backup.Contains(f.Name) {
+		return ErrNotFound
+	}
+	err = node.Backup.Delete(f.Name)
+	reply.Success = err == nil
+	return err
+}
+
 func (node *Node) successorStoreFile(f FileRPC) bool {
 	//fmt.Println("************** Invoke successorStoreFile function ***************")
 	// Store file in successor's backup
 	f.Id.Mod(f.Id, hashMod)
-	node.Backup[f.Id] = f.Name
-	// Write file to local
-	filepath := "tmp/" + node.Name + "/chord_storage/" + f.Name
-	file, err := os.Create(filepath)
+	err := f.verify()
 	if err != nil {
-		fmt.Println("Cannot create backup file")
+		node.logger().Warn("Reject backup file", F("file", f.Name), Err(err))
+		return false
 	}
-	defer file.Close()
-	_, err = file.Write(f.Content)
+	err = node.Backup.Put(f.Id, f.Name, f.Content)
 	if err != nil {
-		fmt.Println("Cannot write backup file")
+		node.logger().Error("Cannot write backup file", F("file", f.Name), Err(err))
+		return false
 	}
 	// fmt.Println("Stab Backup: ", node.Backup)
 	return true
@@ -458,57 +598,21 @@ func (node *Node) successorStoreFile(f FileRPC) bool {
 
 type SuccessorStoreFileRPCReply struct {
 	Success bool
-	Err     error
+	Err     string // Reason of the failure, empty on success
 }
 
 func (node *Node) SuccessorStoreFileRPC(f FileRPC, reply *SuccessorStoreFileRPCReply) error {
 	// fmt.Println("------------- Invoke SuccessorStoreFileRPC function -------------")
+	err := ValidateName(f.Name)
+	if err != nil {
+		node.logger().Warn("Reject backup file", F(FieldMethod, "SuccessorStoreFileRPC"), Err(err))
+		return err
+	}
 	reply.Success = node.successorStoreFile(f)
 	if !reply.Success {
-		reply.Err = errors.New("store file failed")
-	} else {
-		reply.Err = nil
+		// Report the failure to the caller instead of acknowledging the backup
+		return errors.New("store backup file failed: " + f.Name)
 	}
+	reply.Err = ""
 	return nil
 }
-
-func (node *Node) cleanRedundantFile() {
-	// Read local chord_storage directory
-	files, err := ioutil.ReadDir("tmp/" + node.Name + "/chord_storage")
-	if err != nil {
-		fmt.Println("Cannot read chord_storage directory")
-	}
-	// Iterate through local chord_storage directory
-	for _, file := range files {
-		// Get file name
-		fileName := file.Name()
-		// Get file id
-		fileId := sha1.New()
-		fileId.Write([]byte(fileName))
-		key := new(big.Int)
-		key.SetBytes(fileId.Sum(nil))
-		key.Mod(key, hashMod)
-		// Check if file is in local bucket and local backup
-		inBucket := false
-		inBackup := false
-		for k, _ := range node.Bucket {
-			if k.Cmp(key) == 0 {
-				inBucket = true
-			}
-		}
-		for k, _ := range node.Backup {
-			if k.Cmp(key) == 0 {
-				inBackup = true
-			}
-		}
-		if !inBucket && !inBackup {
-			// Delete file from local chord_storage directory
-			filepath := "tmp/" + node.Name + "/chord_storage/" + fileName
-			err = os.Remove(filepath)
-			if err != nil {
-				fmt.Println("Cannot delete file: ", fileName)
-			}
-
-		}
-	}
-}
@@ -0,0 +1,52 @@
+package chord
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// nameBetween is a file name whose identifier is in (node, predecessor]
+func nameBetween(t *testing.T, prefix string, node, predecessor *Node) string {
+	t.Helper()
+	for i := 0; i < 10000; i++ {
+		name := fmt.Sprintf("%s-%d.txt", prefix, i)
+		if between(node.Identifier, Identifier(name), predecessor.Identifier, true) {
+			return name
+		}
+	}
+	t.Fatal("no name found between the nodes")
+	return ""
+}
+
+func TestMoveFilesKeepsFilesNotStored(t *testing.T) {
+	cfg := Config{Stabilize: time.Minute, FixFingers: time.Minute, CheckPredecessor: time.Minute}
+	large := cfg
+	large.Name, large.MaxFileSize = "predecessor", 4
+	predecessor := startTestNode(t, large)
+	cfg.Name = "node"
+	node := startTestNode(t, cfg)
+	ctx := contextWithNode(context.Background(), node)
+
+	small := nameBetween(t, "small", node, predecessor)
+	big := nameBetween(t, "big", node, predecessor)
+	node.Bucket.Put(Identifier(small), small, []byte("abc"))
+	node.Bucket.Put(Identifier(big), big, []byte("beyond the limit"))
+	node.moveFiles(ctx, predecessor.Address)
+
+	if node.Bucket.Contains(small) || !predecessor.Bucket.Contains(small) {
+		t.Fatal("file taken by the predecessor not moved")
+	}
+	// The predecessor refused it, it is not lost
+	if content, err := node.Bucket.Get(big); err != nil || string(content) != "beyond the limit" {
+		t.Fatalf("file refused by the predecessor = %q, %v", content, err)
+	}
+
+	// A move whose reply was lost is retried, the predecessor already has the file
+	node.Bucket.Put(Identifier(small), small, []byte("abc"))
+	node.moveFiles(ctx, predecessor.Address)
+	if node.Bucket.Contains(small) {
+		t.Fatal("file already held by the predecessor not moved")
+	}
+}
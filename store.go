@@ -0,0 +1,476 @@
+package chord
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*------------------------------------------------------------*/
+/*                  Storage Backends Below                    */
+/*------------------------------------------------------------*/
+
+var ErrNotFound = errors.New("object not found")
+var ErrInvalidName = errors.New("invalid object name")
+var ErrCorrupt = errors.New("checksum mismatch")
+
+// Longest object name accepted from clients and peers
+const maxNameLength = 255
+
+/*
+* @description: Check an object name received from a user or a remote node.
+*				Names are opaque keys, but they still end up in local paths
+*				(file_upload, file_download), so anything that could escape a
+*				folder is rejected.
+* @return: 		ErrInvalidName (wrapped with the reason) if the name is unsafe
+ */
+func ValidateName(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	if len(name) > maxNameLength {
+		return fmt.Errorf("%w: longer than %d bytes", ErrInvalidName, maxNameLength)
+	}
+	if !utf8.ValidString(name) {
+		return fmt.Errorf("%w: not valid UTF-8", ErrInvalidName)
+	}
+	for _, r := range name {
+		if r == '/' || r == '\\' || unicode.IsControl(r) {
+			return fmt.Errorf("%w: %q contains %q", ErrInvalidName, name, r)
+		}
+	}
+	return nil
+}
+
+// diskName maps an object name to the file name used on disk: hex of its SHA-256
+func diskName(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+* @description: Storage backend behind a node's bucket and backup.
+*				Objects are addressed by their name, many names share a Chord
+*				identifier on a small ring. The identifier is kept with the
+*				object to range over the objects a node owns, callers are
+*				responsible for reducing it mod 2^m before use.
+ */
+type Store interface {
+	// Put stores (or overwrites) the object with the given name
+	Put(id *big.Int, name string, content []byte) error
+	// Get returns the content of the object, ErrNotFound if missing
+	// and ErrCorrupt if the content no longer matches its recorded checksum
+	Get(name string) ([]byte, error)
+	// Delete removes the object, deleting a missing object is not an error
+	Delete(name string) error
+	// Contains reports whether an object with the given name is stored
+	Contains(name string) bool
+	// Size returns the content size of the object in bytes, ErrNotFound if missing
+	Size(name string) (int64, error)
+	// Range calls fn for every object whose id lies in (start, end], in id order.
+	// start == end covers the whole ring. Iteration stops when fn returns false.
+	// fn may modify the store.
+	Range(start, end *big.Int, fn func(id *big.Int, name string) bool)
+	// Len returns the number of stored objects
+	Len() int
+}
+
+// storeEntry is the index entry kept by both backends
+type storeEntry struct {
+	Id   *big.Int
+	Name string
+}
+
+// rangeEntries returns the entries of index lying in (start, end], sorted by id and name
+func rangeEntries(index map[string]storeEntry, start, end *big.Int) []storeEntry {
+	entries := make([]storeEntry, 0, len(index))
+	for _, entry := range index {
+		if between(start, entry.Id, end, true) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if c := entries[i].Id.Cmp(entries[j].Id); c != 0 {
+			return c < 0
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+// -------------------------- MemoryStore ----------------------------------//
+
+// MemoryStore keeps objects in memory only, content is lost on exit
+type MemoryStore struct {
+	mutex   sync.RWMutex
+	index   map[string]storeEntry // name -> entry
+	content map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		index:   make(map[string]storeEntry),
+		content: make(map[string][]byte),
+	}
+}
+
+func (s *MemoryStore) Put(id *big.Int, name string, content []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.index[name] = storeEntry{Id: new(big.Int).Set(id), Name: name}
+	s.content[name] = append([]byte(nil), content...)
+	return nil
+}
+
+func (s *MemoryStore) Get(name string) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if _, ok := s.index[name]; !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), s.content[name]...), nil
+}
+
+func (s *MemoryStore) Delete(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.index, name)
+	delete(s.content, name)
+	return nil
+}
+
+func (s *MemoryStore) Contains(name string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, ok := s.index[name]
+	return ok
+}
+
+func (s *MemoryStore) Size(name string) (int64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if _, ok := s.index[name]; !ok {
+		return 0, ErrNotFound
+	}
+	return int64(len(s.content[name])), nil
+}
+
+func (s *MemoryStore) Range(start, end *big.Int, fn func(id *big.Int, name string) bool) {
+	// Take a snapshot so fn is free to modify the store
+	s.mutex.RLock()
+	entries := rangeEntries(s.index, start, end)
+	s.mutex.RUnlock()
+	for _, entry := range entries {
+		if !fn(entry.Id, entry.Name) {
+			return
+		}
+	}
+}
+
+func (s *MemoryStore) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.index)
+}
+
+// -------------------------- FileStore ----------------------------------//
+
+/*
+* @description: FileStore keeps one file per object in a directory, named by
+*				diskName so object names never touch the filesystem. Object
+*				metadata (name, id, role, version, checksum) is committed to a
+*				MetaLog, which is the source of truth when the store is reopened.
+*				Content is written to a temp file first and renamed into place
+*				after the metadata is committed, so a crash never exposes a
+*				half-written object.
+ */
+type FileStore struct {
+	mutex sync.RWMutex
+	dir   string
+	role  string
+	meta  *MetaLog
+	index map[string]storeEntry // name -> entry
+}
+
+func tempFileName(name string) string {
+	return diskName(name) + ".tmp"
+}
+
+// writeFileSync writes content to path and syncs it to disk
+func writeFileSync(path string, content []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+/*
+* @description: Open (or create) a file store in dir holding the objects of one role
+* @param: 		meta: the metadata log shared by the node's stores
+* @param: 		role: RolePrimary for the bucket, RoleReplica for the backup
+ */
+func NewFileStore(dir string, meta *MetaLog, role string) (*FileStore, error) {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{dir: dir, role: role, meta: meta, index: make(map[string]storeEntry)}
+	if meta.Fresh() {
+		// Node folder written before metadata existed, import its files
+		err = s.importFiles()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = s.recover()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// importFiles records every file in the directory, using the hash of its name as id.
+// Files are renamed to their disk name, files with unsafe names are left for recover to remove.
+func (s *FileStore) importFiles() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() || ValidateName(file.Name()) != nil {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(s.dir, file.Name()))
+		if err != nil {
+			return err
+		}
+		id := StrHash(file.Name())
+		id.Mod(id, hashMod)
+		_, err = s.meta.Put(ObjectMeta{Name: file.Name(), Id: id, Role: s.role, Checksum: contentChecksum(content)})
+		if err != nil {
+			return err
+		}
+		err = os.Rename(filepath.Join(s.dir, file.Name()), filepath.Join(s.dir, diskName(file.Name())))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recover rebuilds the index from the metadata log and cleans up interrupted writes
+func (s *FileStore) recover() error {
+	for _, meta := range s.meta.Entries(s.role) {
+		path := filepath.Join(s.dir, diskName(meta.Name))
+		tmpPath := filepath.Join(s.dir, tempFileName(meta.Name))
+		// Files written before disk names were introduced are stored under the object name
+		if _, err := os.Stat(path); os.IsNotExist(err) && ValidateName(meta.Name) == nil {
+			os.Rename(filepath.Join(s.dir, meta.Name), path)
+		}
+		// Finish a write that was committed but not yet renamed
+		if content, err := ioutil.ReadFile(tmpPath); err == nil {
+			if contentChecksum(content) == meta.Checksum {
+				os.Rename(tmpPath, path)
+			} else {
+				os.Remove(tmpPath)
+			}
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			// Content never made it to disk, forget the object
+			err = s.meta.Delete(s.role, meta.Name)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		s.index[meta.Name] = storeEntry{Id: meta.Id, Name: meta.Name}
+	}
+	// Remove files that are not referenced by the metadata (uncommitted writes)
+	known := make(map[string]bool)
+	for _, entry := range s.index {
+		known[diskName(entry.Name)] = true
+	}
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !file.IsDir() && !known[file.Name()] {
+			os.Remove(filepath.Join(s.dir, file.Name()))
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) Put(id *big.Int, name string, content []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	path := filepath.Join(s.dir, diskName(name))
+	tmpPath := filepath.Join(s.dir, tempFileName(name))
+	err := writeFileSync(tmpPath, content)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	// Commit point: once the metadata is logged the object survives a crash
+	_, err = s.meta.Put(ObjectMeta{Name: name, Id: id, Role: s.role, Checksum: contentChecksum(content)})
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return err
+	}
+	syncDir(s.dir)
+	s.index[name] = storeEntry{Id: new(big.Int).Set(id), Name: name}
+	return nil
+}
+
+func (s *FileStore) Get(name string) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if _, ok := s.index[name]; !ok {
+		return nil, ErrNotFound
+	}
+	content, err := ioutil.ReadFile(filepath.Join(s.dir, diskName(name)))
+	if err != nil {
+		return nil, err
+	}
+	// Never hand out content that changed on disk behind our back
+	if meta, ok := s.meta.Get(s.role, name); ok && meta.Checksum != contentChecksum(content) {
+		return nil, ErrCorrupt
+	}
+	return content, nil
+}
+
+// Meta returns the recorded metadata of an object
+func (s *FileStore) Meta(name string) (ObjectMeta, bool) {
+	return s.meta.Get(s.role, name)
+}
+
+func (s *FileStore) Delete(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.index[name]; !ok {
+		return nil
+	}
+	err := s.meta.Delete(s.role, name)
+	if err != nil {
+		return err
+	}
+	delete(s.index, name)
+	err = os.Remove(filepath.Join(s.dir, diskName(name)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) Contains(name string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, ok := s.index[name]
+	return ok
+}
+
+func (s *FileStore) Size(name string) (int64, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if _, ok := s.index[name]; !ok {
+		return 0, ErrNotFound
+	}
+	info, err := os.Stat(filepath.Join(s.dir, diskName(name)))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (s *FileStore) Range(start, end *big.Int, fn func(id *big.Int, name string) bool) {
+	// Take a snapshot so fn is free to modify the store
+	s.mutex.RLock()
+	entries := rangeEntries(s.index, start, end)
+	s.mutex.RUnlock()
+	for _, entry := range entries {
+		if !fn(entry.Id, entry.Name) {
+			return
+		}
+	}
+}
+
+func (s *FileStore) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.index)
+}
+
+/*
+* @description: Create the bucket and backup stores for a node
+* @param: 		storage: backend name, "file" or "memory"
+* @param: 		nodeFolder: the node folder, file stores live in chord_storage and
+*						chord_backup and share the metadata log in metadata.log
+* @return: 		the metadata log is nil for the memory backend
+ */
+func newStores(storage string, nodeFolder string) (Store, Store, *MetaLog, error) {
+	if storage == "memory" {
+		return NewMemoryStore(), NewMemoryStore(), nil, nil
+	}
+	err := os.MkdirAll(nodeFolder, os.ModePerm)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	meta, err := OpenMetaLog(filepath.Join(nodeFolder, "metadata.log"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	bucket, err := NewFileStore(filepath.Join(nodeFolder, "chord_storage"), meta, RolePrimary)
+	if err != nil {
+		meta.Close()
+		return nil, nil, nil, err
+	}
+	backup, err := NewFileStore(filepath.Join(nodeFolder, "chord_backup"), meta, RoleReplica)
+	if err != nil {
+		meta.Close()
+		return nil, nil, nil, err
+	}
+	return bucket, backup, meta, nil
+}
+
+/*
+* @description: Create the stores of a virtual node. File stores live in folder
+*				and record their metadata in the log of the hosting node, under
+*				roles suffixed with "#<index>" so that they do not mix with it.
+ */
+func newVirtualStores(storage string, folder string, meta *MetaLog, index string) (Store, Store, error) {
+	if storage == "memory" {
+		return NewMemoryStore(), NewMemoryStore(), nil
+	}
+	bucket, err := NewFileStore(filepath.Join(folder, "chord_storage"), meta, RolePrimary+"#"+index)
+	if err != nil {
+		return nil, nil, err
+	}
+	backup, err := NewFileStore(filepath.Join(folder, "chord_backup"), meta, RoleReplica+"#"+index)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bucket, backup, nil
+}
@@ -0,0 +1,204 @@
+package chord
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestStores returns a store of every backend, the file store in a fresh folder
+func newTestStores(t *testing.T) map[string]Store {
+	t.Helper()
+	meta, err := OpenMetaLog(filepath.Join(t.TempDir(), "metadata.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { meta.Close() })
+	files, err := NewFileStore(filepath.Join(t.TempDir(), "bucket"), meta, RolePrimary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Store{"memory": NewMemoryStore(), "file": files}
+}
+
+func TestStorePutGetDelete(t *testing.T) {
+	for backend, store := range newTestStores(t) {
+		t.Run(backend, func(t *testing.T) {
+			id := big.NewInt(7)
+			if _, err := store.Get("a.txt"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Get of a missing object: %v, want ErrNotFound", err)
+			}
+			if err := store.Put(id, "a.txt", []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			content, err := store.Get("a.txt")
+			if err != nil || string(content) != "hello" {
+				t.Fatalf("Get = %q, %v", content, err)
+			}
+			if size, err := store.Size("a.txt"); err != nil || size != 5 {
+				t.Fatalf("Size = %d, %v", size, err)
+			}
+			if err := store.Put(id, "a.txt", []byte("bye")); err != nil {
+				t.Fatal(err)
+			}
+			if content, _ := store.Get("a.txt"); string(content) != "bye" {
+				t.Fatalf("Get after overwrite = %q", content)
+			}
+			// Another name with the same id is another object
+			if err := store.Put(id, "b.txt", []byte("other")); err != nil {
+				t.Fatal(err)
+			}
+			if content, _ := store.Get("a.txt"); string(content) != "bye" {
+				t.Fatalf("Get after a put with the same id = %q", content)
+			}
+			if store.Len() != 2 || !store.Contains("a.txt") || !store.Contains("b.txt") {
+				t.Fatalf("Len = %d, Contains = %v, %v", store.Len(), store.Contains("a.txt"), store.Contains("b.txt"))
+			}
+			if err := store.Delete("a.txt"); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Delete("a.txt"); err != nil {
+				t.Fatalf("Delete of a missing object: %v", err)
+			}
+			if store.Len() != 1 || store.Contains("a.txt") || !store.Contains("b.txt") {
+				t.Fatalf("Delete removed %d objects, want only a.txt", 2-store.Len())
+			}
+		})
+	}
+}
+
+func TestStoreRange(t *testing.T) {
+	for backend, store := range newTestStores(t) {
+		t.Run(backend, func(t *testing.T) {
+			for _, id := range []int64{3, 10, 40, 60} {
+				if err := store.Put(big.NewInt(id), string(rune('a'+id)), []byte("x")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// A second object under id 40, ranged after the first one
+			store.Put(big.NewInt(40), string(rune('a'+40))+"2", []byte("x"))
+			cases := []struct {
+				start, end int64
+				want       []int64
+			}{
+				{3, 40, []int64{10, 40, 40}},         // (start, end]
+				{50, 5, []int64{3, 60}},              // Wraps around zero, sorted by id
+				{10, 10, []int64{3, 10, 40, 40, 60}}, // The whole ring
+			}
+			for _, c := range cases {
+				var got []int64
+				store.Range(big.NewInt(c.start), big.NewInt(c.end), func(id *big.Int, name string) bool {
+					got = append(got, id.Int64())
+					return true
+				})
+				if !equalInts(got, c.want) {
+					t.Errorf("Range(%d, %d] = %v, want %v", c.start, c.end, got, c.want)
+				}
+			}
+			// fn may delete while ranging and stop early
+			count := 0
+			store.Range(big.NewInt(0), big.NewInt(0), func(id *big.Int, name string) bool {
+				count++
+				store.Delete(name)
+				return count < 2
+			})
+			if count != 2 || store.Len() != 3 {
+				t.Fatalf("ranged %d objects, %d left, want 2 and 3", count, store.Len())
+			}
+		})
+	}
+}
+
+func equalInts(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestValidateName(t *testing.T) {
+	valid := []string{"a.txt", "report 2022.pdf", "..a", "名前.txt", ".hidden"}
+	for _, name := range valid {
+		if err := ValidateName(name); err != nil {
+			t.Errorf("ValidateName(%q) = %v, want nil", name, err)
+		}
+	}
+	invalid := []string{"", ".", "..", "a/b", "../etc/passwd", `a\b`, "a\x00b", "a\nb", "\xff", string(make([]byte, maxNameLength+1))}
+	for _, name := range invalid {
+		if err := ValidateName(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("ValidateName(%q) = %v, want ErrInvalidName", name, err)
+		}
+	}
+}
+
+func TestFileStoreNamesNeverTouchTheFilesystem(t *testing.T) {
+	dir := t.TempDir()
+	meta, err := OpenMetaLog(filepath.Join(dir, "metadata.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer meta.Close()
+	store, err := NewFileStore(filepath.Join(dir, "bucket"), meta, RolePrimary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(big.NewInt(1), "..", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	files, err := os.ReadDir(filepath.Join(dir, "bucket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != diskName("..") {
+		t.Fatalf("files on disk %v, want only %s", files, diskName(".."))
+	}
+}
+
+func TestContentStoreMode(t *testing.T) {
+	node := startTestNode(t, Config{StoreMode: StoreModeContent})
+	client := NewClient(node)
+	ctx := context.Background()
+
+	value := []byte("hello")
+	key := ContentKey(value)
+	if err := client.Put(ctx, key, value); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := client.Get(ctx, key); err != nil || string(got) != "hello" {
+		t.Fatalf("Get by content hash = %q, %v", got, err)
+	}
+
+	// Files with the same content are stored once, under the same key
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, value, 0644); err != nil {
+			t.Fatal(err)
+		}
+		stored, err := client.StoreFile(ctx, path)
+		if err != nil || stored != key {
+			t.Fatalf("StoreFile(%s) = %q, %v, want key %s", name, stored, err, key)
+		}
+	}
+	if node.Bucket.Len() != 1 {
+		t.Fatalf("%d objects stored for one content, want 1", node.Bucket.Len())
+	}
+
+	// Content not matching its key is refused
+	for _, k := range []string{ContentKey([]byte("other")), "a.txt"} {
+		if err := client.Put(ctx, k, value); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Put of content under key %s = %v, want ErrCorrupt", k, err)
+		}
+	}
+	if node.Bucket.Len() != 1 {
+		t.Fatalf("refused content stored, %d objects", node.Bucket.Len())
+	}
+}
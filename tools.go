@@ -1,21 +1,20 @@
 package chord
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"math/big"
 	"net"
 	"net/http"
 	"net/rpc"
-	"net/rpc/jsonrpc"
 	"os"
-	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -33,30 +32,69 @@ import (
 * @return:		error: the error returned by the RPC call
  */
 func ChordCall(targetNode NodeAddress, method string, request interface{}, reply interface{}) error {
-	if len(strings.Split(string(targetNode), ":")) != 2 {
-		fmt.Println("Error: targetNode address is not in the correct format: ", targetNode)
+	return ChordCallContext(context.Background(), targetNode, method, request, reply)
+}
+
+/*
+* @description: ChordCall that gives up when ctx is cancelled or its deadline passes
+ */
+func ChordCallContext(ctx context.Context, targetNode NodeAddress, method string, request interface{}, reply interface{}) error {
+	hostPort, service := splitAddress(targetNode)
+	if len(strings.Split(hostPort, ":")) != 2 {
+		defaultLogger.Warn("Target node address is not in the correct format", F(FieldMethod, method), F(FieldPeer, targetNode))
 		return errors.New("Error: targetNode address is not in the correct format: " + string(targetNode))
 	}
-	ip := strings.Split(string(targetNode), ":")[0]
-	port := strings.Split(string(targetNode), ":")[1]
-
-	targetNodeAddr := ip + ":" + port
-	// conn, err := tls.Dial("tcp", targetNodeAddr, &tls.Config{InsecureSkipVerify: true})
-	// client := jsonrpc.NewClient(conn)
-	client, err := jsonrpc.Dial("tcp", targetNodeAddr)
+	// Methods are written as "Node.<Method>", a virtual node serves them as "Node/<index>.<Method>"
+	if strings.HasPrefix(method, "Node.") {
+		method = service + strings.TrimPrefix(method, "Node")
+	}
+	err := checkWireCall(method, request, reply)
 	if err != nil {
-		fmt.Println("Method: ", method, "Dial Error: ", err)
 		return err
 	}
-	defer client.Close()
-	err = client.Call(method, request, reply)
+	// conn, err := tls.Dial("tcp", targetNodeAddr, &tls.Config{InsecureSkipVerify: true})
+	// client := jsonrpc.NewClient(conn)
+	ctx, span := StartSpan(ctx, methodLabel(method), SpanKindClient, "")
+	call := callParams{Auth: callCredentials(ctx, methodLabel(method)), Params: request}
+	if span != nil {
+		// The pool leaves the trace out for peers that did not agree on it
+		span.Peer = targetNode
+		trace := span.Context()
+		call.Trace = &trace
+	}
+	if call.Trace != nil || call.Auth != nil {
+		request = call
+	}
+	start := time.Now()
+	err = currentTransport().Call(ctx, hostPort, method, request, reply)
+	rpcClientRequests.inc(methodLabel(method), resultLabel(err))
+	rpcClientDuration.observe(time.Since(start).Seconds(), methodLabel(method))
+	span.Finish(err)
 	if err != nil {
-		fmt.Println("Call Error:", err)
-		return err
+		// Callers decide how bad a failed call is, hence only debug here
+		defaultLogger.Debug("Call failed", traceFields(ctx, F(FieldMethod, method), F(FieldPeer, targetNode), Err(err))...)
+		return remoteError(err)
 	}
 	return nil
 }
 
+// Errors that keep their identity (errors.Is) when returned by a remote node
+var remoteErrors = []error{ErrNotFound, ErrInvalidName, ErrCorrupt, ErrIncompatible, ErrUnauthorized, ErrRateLimited, ErrTooLarge}
+
+// remoteError maps an error string sent by a remote node back to its sentinel error
+func remoteError(err error) error {
+	serverErr, ok := err.(rpc.ServerError)
+	if !ok {
+		return err
+	}
+	for _, target := range remoteErrors {
+		if strings.HasPrefix(string(serverErr), target.Error()) {
+			return fmt.Errorf("%w%s", target, strings.TrimPrefix(string(serverErr), target.Error()))
+		}
+	}
+	return err
+}
+
 /*------------------------------------------------------------*/
 /*                     Tool Functions Below                   */
 /*------------------------------------------------------------*/
@@ -72,6 +110,27 @@ type Arguments struct {
 	CheckPred   int         // The time in milliseconds between invocations of check_predecessor.
 	Successors  int
 	ClientName  string
+	Storage     string // Storage backend for bucket and backup: "file" or "memory"
+	DataDir     string // Root folder of the node folders
+	StoreMode   string // How objects are keyed: StoreModeName or StoreModeContent
+	HTTPAddress string // Address of the HTTP gateway, empty to disable it
+	HTTPToken   string // Bearer token of the mutating gateway requests, see Config.HTTPToken
+	VNodes      int    // Number of ring positions taken by this process, see newVirtualNode
+	Metrics     string // Address of the /metrics endpoint, empty to disable it
+	LogLevel    string // Minimum level logged: debug, info, warn, error or off
+	LogFormat   string // LogFormatText or LogFormatJSON
+	TraceFile   string // File the spans are appended to, empty to disable it
+	TraceURL    string // OTLP/HTTP collector the spans are sent to, empty to disable it
+	Wire        string // WireJSON or WireGob
+	Ring        string // ID of the ring, see Config.Ring
+	Secret      string // Shared secret of the ring, see Config.Secret
+	AdminSecret string // Secret of the administrative RPCs, see Config.AdminSecret
+
+	// Limits of the RPC server
+	MaxConns    int     // Connections served at once, see Config.MaxConns
+	RateLimit   float64 // Requests per second of a peer IP, see Config.RateLimit
+	MaxFileSize int64   // Bytes of the content of a file, see Config.MaxFileSize
+	MaxMemory   int64   // Bytes held by the requests being served, see Config.MaxMemory
 }
 
 func GetCmdArgs() Arguments {
@@ -85,6 +144,27 @@ func GetCmdArgs() Arguments {
 	var tcp int   // The time in milliseconds between invocations of check_predecessor.
 	var r int     // The number of successors to maintain.
 	var i string  // Client name
+	var s string  // Storage backend
+	var d string  // Data directory
+	var sm string // Store mode
+	var h string  // HTTP gateway address
+	var ht string // HTTP gateway token
+	var v int     // Number of virtual nodes
+	var mx string // Metrics address
+	var ll string // Log level
+	var lf string // Log format
+	var tf string // Trace file
+	var tu string // Trace collector
+	var w string  // Wire format
+	var ri string // Ring ID
+	var se string // Ring secret
+	var as string // Admin secret
+
+	// Limits of the RPC server
+	var mc int     // Max connections
+	var rl float64 // Rate limit
+	var mf int64   // Max file size
+	var mm int64   // Max memory
 
 	// Parse command line arguments
 	flag.StringVar(&a, "a", "localhost", "Current node address")
@@ -96,6 +176,25 @@ func GetCmdArgs() Arguments {
 	flag.IntVar(&tcp, "tcp", 3000, "The time in milliseconds between invocations of check_predecessor.")
 	flag.IntVar(&r, "r", 3, "The number of successors to maintain.")
 	flag.StringVar(&i, "i", "Default", "Client ID/Name")
+	flag.StringVar(&s, "storage", "file", "Storage backend for bucket and backup: file or memory")
+	flag.StringVar(&d, "data-dir", "tmp", "Folder holding the node folders")
+	flag.StringVar(&h, "http", "", "Address of the HTTP gateway, e.g. :8080. Disabled if empty")
+	flag.StringVar(&ht, "http-token", os.Getenv("CHORD_HTTP_TOKEN"), "Bearer token of the PUT and DELETE requests of the HTTP gateway, default $CHORD_HTTP_TOKEN. Required with --http on a ring with a secret")
+	flag.StringVar(&mx, "metrics", "", "Address of the Prometheus /metrics endpoint, e.g. :9100. Disabled if empty")
+	flag.StringVar(&ll, "log-level", "warn", "Minimum level logged to stderr: debug, info, warn, error or off")
+	flag.StringVar(&lf, "log-format", LogFormatText, "Log format: text or json")
+	flag.StringVar(&tf, "trace-file", "", "File the trace spans are appended to, one JSON object per line. Disabled if empty")
+	flag.StringVar(&tu, "trace-collector", "", "URL of an OTLP/HTTP JSON collector receiving the trace spans, e.g. http://localhost:4318/v1/traces. Disabled if empty")
+	flag.StringVar(&w, "wire", WireJSON, "Wire format of the calls to other nodes: json or gob")
+	flag.StringVar(&ri, "ring", "", "ID of the ring, calls changing a node need the credentials of its ring ID and secret")
+	flag.StringVar(&se, "secret", os.Getenv("CHORD_SECRET"), "Shared secret of the ring, default $CHORD_SECRET. Empty with no ring ID for an open ring")
+	flag.StringVar(&as, "admin-secret", os.Getenv("CHORD_ADMIN_SECRET"), "Secret of the administrative RPCs such as SetPredecessorRPC, default $CHORD_ADMIN_SECRET. Disabled if empty")
+	flag.IntVar(&mc, "max-conns", defaultMaxConns, "Connections the node serves at once, more are refused")
+	flag.Float64Var(&rl, "rate-limit", 0, "Requests per second a peer IP may send on average, more are rejected. No limit if 0")
+	flag.Int64Var(&mf, "max-file-size", defaultMaxFileSize, "Bytes of the content of a file, larger files are rejected")
+	flag.Int64Var(&mm, "max-memory", 0, "Bytes the requests of all connections may hold at once, connections waiting too long for room are closed. Default 256 MiB, or twice the largest request if larger")
+	flag.IntVar(&v, "vnodes", 1, "Number of virtual nodes hosted by this process, including itself")
+	flag.StringVar(&sm, "store-mode", StoreModeName, "Object keys: name (file name) or content (hash of the content)")
 	flag.Parse()
 
 	// Return command line arguments
@@ -109,18 +208,87 @@ func GetCmdArgs() Arguments {
 		CheckPred:   tcp,
 		Successors:  r,
 		ClientName:  i,
+		Storage:     s,
+		DataDir:     d,
+		StoreMode:   sm,
+		HTTPAddress: h,
+		HTTPToken:   ht,
+		VNodes:      v,
+		Metrics:     mx,
+		LogLevel:    ll,
+		LogFormat:   lf,
+		TraceFile:   tf,
+		TraceURL:    tu,
+		Wire:        w,
+		Ring:        ri,
+		Secret:      se,
+		AdminSecret: as,
+		MaxConns:    mc,
+		RateLimit:   rl,
+		MaxFileSize: mf,
+		MaxMemory:   mm,
 	}
 }
 
+/*
+* @description: Convert the command line arguments to the Config of Start.
+*				Logging is left to the command, see LogLevel and LogFormat.
+ */
+func (args Arguments) Config() Config {
+	cfg := Config{
+		Address:          string(args.Address),
+		Port:             args.Port,
+		Name:             args.ClientName,
+		Stabilize:        time.Duration(args.Stabilize) * time.Millisecond,
+		FixFingers:       time.Duration(args.FixFingers) * time.Millisecond,
+		CheckPredecessor: time.Duration(args.CheckPred) * time.Millisecond,
+		Successors:       args.Successors,
+		VNodes:           args.VNodes,
+		Storage:          args.Storage,
+		DataDir:          args.DataDir,
+		StoreMode:        args.StoreMode,
+		HTTPAddress:      args.HTTPAddress,
+		HTTPToken:        args.HTTPToken,
+		MetricsAddress:   args.Metrics,
+		TraceFile:        args.TraceFile,
+		TraceURL:         args.TraceURL,
+		Wire:             args.Wire,
+		Ring:             args.Ring,
+		Secret:           args.Secret,
+		AdminSecret:      args.AdminSecret,
+		MaxConns:         args.MaxConns,
+		RateLimit:        args.RateLimit,
+		MaxFileSize:      args.MaxFileSize,
+		MaxMemory:        args.MaxMemory,
+	}
+	if args.ClientName == "Default" {
+		cfg.Name = ""
+	}
+	if args.JoinAddress != "Unspecified" {
+		cfg.Join = NodeAddress(fmt.Sprintf("%s:%d", args.JoinAddress, args.JoinPort))
+	}
+	return cfg
+}
+
 // Use Go channel to implement periodic tasks
 func (se *ScheduledExecutor) Start(task func()) {
-	se.Ticker = *time.NewTicker(se.Delay)
+	se.Ticker = time.NewTicker(se.Delay)
+	var running int32
 	go func() {
 		for {
 			select {
 			case <-se.Ticker.C:
-				// Use goroutine to run the task to avoid blocking user input
-				go task()
+				// Use goroutine to run the task to avoid blocking user input.
+				// Runs must not overlap (fix_fingers keeps its position in node.next),
+				// so the tick is skipped while the previous run is not done.
+				if atomic.CompareAndSwapInt32(&running, 0, 1) {
+					se.tasks.Add(1)
+					go func() {
+						defer se.tasks.Done()
+						defer atomic.StoreInt32(&running, 0)
+						task()
+					}()
+				}
 			case <-se.Quit:
 				se.Ticker.Stop()
 				return
@@ -129,67 +297,11 @@ func (se *ScheduledExecutor) Start(task func()) {
 	}()
 }
 
-func CheckArgsValid(args Arguments) int {
-	// Check if Ip address is valid or not
-	if net.ParseIP(string(args.Address)) == nil && args.Address != "localhost" {
-		fmt.Println("IP address is invalid")
-		return -1
-	}
-	// Check if port is valid
-	if args.Port < 1024 || args.Port > 65535 {
-		fmt.Println("Port number is invalid")
-		return -1
-	}
-
-	// Check if durations are valid
-	if args.Stabilize < 1 || args.Stabilize > 60000 {
-		fmt.Println("Stabilize time is invalid")
-		return -1
-	}
-	if args.FixFingers < 1 || args.FixFingers > 60000 {
-		fmt.Println("FixFingers time is invalid")
-		return -1
-	}
-	if args.CheckPred < 1 || args.CheckPred > 60000 {
-		fmt.Println("CheckPred time is invalid")
-		return -1
-	}
-
-	// Check if number of successors is valid
-	if args.Successors < 1 || args.Successors > 32 {
-		fmt.Println("Successors number is invalid")
-		return -1
-	}
-
-	// Check if client name is s a valid string matching the regular expression [0-9a-fA-F]{40}
-	if args.ClientName != "Default" {
-		matched, err := regexp.MatchString("[0-9a-fA-F]*", args.ClientName)
-		if err != nil || !matched {
-			fmt.Println("Client Name is invalid")
-			return -1
-		}
-	}
-
-	// Check if joining address and port is valid or not
-	if args.JoinAddress != "Unspecified" {
-		// Addr is specified, check if addr & port are valid
-		if net.ParseIP(string(args.JoinAddress)) != nil || args.JoinAddress == "localhost" {
-			// Check if join port is valid
-			if args.JoinPort < 1024 || args.JoinPort > 65535 {
-				fmt.Println("Join port number is invalid")
-				return -1
-			}
-			// Join the chord
-			return 0
-		} else {
-			fmt.Println("Joining address is invalid")
-			return -1
-		}
-	} else {
-		// Join address is not specified, create a new chord ring
-		// ignroe jp input
-		return 1
-	}
+/*
+ * @description: Wait blocks until the run in progress is done, once Quit was sent no new run starts
+ */
+func (se *ScheduledExecutor) Wait() {
+	se.tasks.Wait()
 }
 
 func StrHash(elt string) *big.Int {
@@ -198,6 +310,12 @@ func StrHash(elt string) *big.Int {
 	return new(big.Int).SetBytes(hasher.Sum(nil))
 }
 
+// Identifier returns the position of a node name or key on the ring, StrHash(name) mod 2^m
+func Identifier(name string) *big.Int {
+	id := StrHash(name)
+	return id.Mod(id, hashMod)
+}
+
 func between(start, elt, end *big.Int, inclusive bool) bool {
 	if end.Cmp(start) > 0 { // start < end
 		return (start.Cmp(elt) < 0 && elt.Cmp(end) < 0) || (inclusive && elt.Cmp(end) == 0)
@@ -210,7 +328,7 @@ func ClientLookUp(key string, node *Node) (NodeAddress, error) {
 	// Find the successor of key
 	// Return the successor's address and port
 	newKey := StrHash(key) // Use file name as key
-	addr := find(newKey, node.Address)
+	addr, _ := findContext(contextWithNode(context.Background(), node), newKey, node.Address)
 
 	if addr == "-1" {
 		return "", errors.New("cannot find the store position of the key")
@@ -221,69 +339,101 @@ func ClientLookUp(key string, node *Node) (NodeAddress, error) {
 
 // File structure
 type FileRPC struct {
-	Id      *big.Int
-	Name    string
-	Content []byte
+	Id       *big.Int
+	Name     string
+	Content  []byte
+	Checksum string // Hex encoded SHA-256 of Content
+}
+
+// Check the digest carried in the file against its content
+func (f FileRPC) verify() error {
+	if f.Checksum != contentChecksum(f.Content) {
+		return fmt.Errorf("%w: %s", ErrCorrupt, f.Name)
+	}
+	return nil
 }
 
-func ClientStoreFile(fileName string, node *Node) error {
+/*
+* @description: Upload a file from the node's file_upload folder to the ring
+* @return: 		the key to get the file with: the file name, or the hex SHA-256
+*				of the uploaded content when the node uses the content store mode
+ */
+func ClientStoreFile(fileName string, node *Node) (string, error) {
 	// Store the file in the node
-	// Return the address and port of the node that stores the file
-	addr, err := ClientLookUp(fileName, node)
+	err := ValidateName(fileName)
 	if err != nil {
-		return err
-	} else {
-		fmt.Println("The file is stored in node: ", addr)
+		return "", err
 	}
 	// Open file and pack into fileRPC
-	currentNodeFileUploadPath := "tmp/" + node.Name + "/file_upload/"
-	filepath := currentNodeFileUploadPath + fileName
+	filepath := node.path("file_upload", fileName)
 	file, err := os.Open(filepath)
 	if err != nil {
-		fmt.Println("Cannot open the file")
-		return err
+		node.logger().Error("Cannot open the file", F("file", fileName), Err(err))
+		return "", err
 	}
 	defer file.Close()
 	// Init new file struct and put content into it
 	newFile := FileRPC{}
-	newFile.Name = fileName
 	newFile.Content, err = ioutil.ReadAll(file)
-	newFile.Id = StrHash(fileName)
+	if err != nil {
+		return "", err
+	}
+	// Encrypted file content
+	if node.EncryptFlag {
+		newFile.Content = node.encryptFile(newFile.Content)
+	}
+	newFile.Checksum = contentChecksum(newFile.Content)
+	newFile.Name = fileName
+	if node.StoreMode == StoreModeContent {
+		// The key is the digest of what is stored, so any reader can verify it
+		newFile.Name = newFile.Checksum
+	}
+	newFile.Id = StrHash(newFile.Name)
 	newFile.Id.Mod(newFile.Id, hashMod)
+
+	addr, err := ClientLookUp(newFile.Name, node)
 	if err != nil {
-		return err
+		return "", err
 	} else {
-		// Encrypted file content
-		if node.EncryptFlag {
-			newFile.Content = node.encryptFile(newFile.Content)
-		}
-		reply := new(StoreFileRPCReply)
-		reply.Backup = false
-		err = ChordCall(addr, "Node.StoreFileRPC", newFile, &reply)
-		if reply.Err != nil && err != nil {
-			return errors.New("cannot store the file")
-		}
+		node.logger().Info("The file is stored in node", F("file", newFile.Name), F(FieldPeer, addr))
 	}
-	return nil
+	reply := new(StoreFileRPCReply)
+	reply.Backup = false
+	err = ChordCallContext(contextWithNode(context.Background(), node), addr, "Node.StoreFileRPC", newFile, reply)
+	if err != nil || !reply.Success {
+		return "", errors.New("cannot store the file")
+	}
+	return newFile.Name, nil
 }
 
 func ClientGetFile(fileName string, node *Node) error {
 	// Get the file from the node
+	err := ValidateName(fileName)
+	if err != nil {
+		return err
+	}
 	addr, err := ClientLookUp(fileName, node)
 	if err != nil {
 		return err
 	} else {
-		fmt.Println("The file is stored in node: ", addr)
+		node.logger().Info("The file is stored in node", F("file", fileName), F(FieldPeer, addr))
 	}
 	// Open file and pack into fileRPC
 	file := FileRPC{}
 	file.Name = fileName
 	file.Id = StrHash(fileName)
 	file.Id.Mod(file.Id, hashMod)
-	err = ChordCall(addr, "Node.GetFileRPC", file, &file)
+	err = ChordCallContext(contextWithNode(context.Background(), node), addr, "Node.GetFileRPC", file, &file)
 	if err != nil {
-		fmt.Println("Cannot get the file")
+		node.logger().Warn("Cannot get the file", F(FieldMethod, "GetFileRPC"), F(FieldPeer, addr), F("file", fileName), Err(err))
+		return err
+	} else if file.Name != fileName {
+		// Only ever write the file that was asked for
+		return errors.New("remote node returned a different file: " + file.Name)
+	} else if err = file.verify(); err != nil {
 		return err
+	} else if node.StoreMode == StoreModeContent && file.Checksum != fileName {
+		return fmt.Errorf("%w: content does not match key %s", ErrCorrupt, fileName)
 	} else {
 		// Decrypt file content
 		if node.EncryptFlag {
@@ -299,118 +449,63 @@ func ClientGetFile(fileName string, node *Node) error {
 	return nil
 }
 
-func GetLocalAddress() string {
+func GetLocalAddress() (string, error) {
 	// Obtain the local ip address from dns server 8.8.8:80
 	conn, err := net.Dial("udp", "8.8.8.8:80")
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 	defer conn.Close()
 	localAddr := conn.LocalAddr().(*net.UDPAddr)
-	return localAddr.IP.String()
+	return localAddr.IP.String(), nil
 }
 
 type IP struct {
 	Query string
 }
 
-func Getip2() string {
+func Getip2() (string, error) {
 	req, err := http.Get("http://ip-api.com/json/")
 	if err != nil {
-		return err.Error()
+		return "", err
 	}
 	defer req.Body.Close()
 
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		return err.Error()
+		return "", err
 	}
 
 	var ip IP
-	fmt.Println("body: ", string(body))
-	json.Unmarshal(body, &ip)
-
-	return ip.Query
-}
-
-func HandleConnection(listener net.Listener, node *Node) {
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			fmt.Println("Accept failed:", err.Error())
-			continue
-		}
-		go jsonrpc.ServeConn(conn)
+	defaultLogger.Debug("Public address lookup", F("body", string(body)))
+	err = json.Unmarshal(body, &ip)
+	if err != nil {
+		return "", err
+	}
+	if ip.Query == "" {
+		return "", errors.New("public address lookup returned no address")
 	}
+	return ip.Query, nil
 }
 
-func StartChord(args Arguments) *Node {
-	// Check if the command line arguments are valid
-	valid := CheckArgsValid(args)
-	var node *Node
-	if valid == -1 {
-		fmt.Println("Invalid command line arguments")
-		os.Exit(1)
-	} else {
-		fmt.Println("Valid command line arguments")
-		// Create new Node
-		node = NewNode(args)
-
-		IPAddr := fmt.Sprintf("%s:%d", args.Address, args.Port)
-		tcpAddr, err := net.ResolveTCPAddr("tcp4", IPAddr)
-		if err != nil {
-			fmt.Println("ResolveTCPAddr failed:", err.Error())
-			os.Exit(1)
-		}
-		rpc.Register(node)
-
-		listener, err := net.Listen("tcp", tcpAddr.String())
-		if err != nil {
-			fmt.Println("ListenTCP failed:", err.Error())
-			os.Exit(1)
-		}
-		fmt.Println("Local node listening on ", tcpAddr)
-		// Use a separate goroutine to accept connection
-		go HandleConnection(listener, node)
-
-		if valid == 0 {
-			// Join exsiting chord
-			RemoteAddr := fmt.Sprintf("%s:%d", args.JoinAddress, args.JoinPort)
-
-			// Connect to the remote node
-			fmt.Println("Connecting to the remote node..." + RemoteAddr)
-			err := node.JoinChord(NodeAddress(RemoteAddr))
-			if err != nil {
-				fmt.Println("Join RPC call failed")
-				os.Exit(1)
-			} else {
-				fmt.Println("Join RPC call success")
-			}
-		} else if valid == 1 {
-			// Create new chord
-			node.CreateChord()
-			// Combine address and port, convert port to string
-		}
-
-		// Start periodic tasks
-		Se_stab := ScheduledExecutor{Delay: time.Duration(args.Stabilize) * time.Millisecond, Quit: make(chan int)}
-		Se_stab.Start(func() {
-			node.Stablize()
-		})
-
-		Se_ff := ScheduledExecutor{Delay: time.Duration(args.FixFingers) * time.Millisecond, Quit: make(chan int)}
-		Se_ff.Start(func() {
-			node.FixFingers()
-		})
-
-		Se_cp := ScheduledExecutor{Delay: time.Duration(args.CheckPred) * time.Millisecond, Quit: make(chan int)}
-		Se_cp.Start(func() {
-			node.CheckPredecessor()
-		})
-
-		node.Se_cp = &Se_cp
-		node.Se_ff = &Se_ff
-		node.Se_stab = &Se_stab
-	}
-	return node
+// startPeriodicTasks runs stabilize, fix_fingers and check_predecessor until Quit
+func (node *Node) startPeriodicTasks(cfg Config) {
+	Se_stab := ScheduledExecutor{Delay: cfg.Stabilize, Quit: make(chan int)}
+	Se_stab.Start(func() {
+		node.observeTask("stabilize", node.stabilize)
+	})
+
+	Se_ff := ScheduledExecutor{Delay: cfg.FixFingers, Quit: make(chan int)}
+	Se_ff.Start(func() {
+		node.observeTask("fix_fingers", node.fixFingers)
+	})
+
+	Se_cp := ScheduledExecutor{Delay: cfg.CheckPredecessor, Quit: make(chan int)}
+	Se_cp.Start(func() {
+		node.observeTask("check_predecessor", node.checkPredecessor)
+	})
+
+	node.Se_cp = &Se_cp
+	node.Se_ff = &Se_ff
+	node.Se_stab = &Se_stab
 }
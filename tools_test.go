@@ -0,0 +1,68 @@
+package chord
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// startTestRing starts n nodes joined through the first one and waits until their successors form a ring
+func startTestRing(t *testing.T, n int, cfg Config) []*Node {
+	t.Helper()
+	cfg.Stabilize, cfg.FixFingers, cfg.CheckPredecessor = 20*time.Millisecond, 20*time.Millisecond, 20*time.Millisecond
+	// Named by port, two of the nodes would often share one of the 64 identifiers
+	ids := map[int64]bool{}
+	var nodes []*Node
+	for i := 0; len(nodes) < n; i++ {
+		cfg.Name = fmt.Sprintf("ring-node-%d", i)
+		id := Identifier(cfg.Name).Int64()
+		if ids[id] {
+			continue
+		}
+		ids[id] = true
+		if len(nodes) > 0 {
+			cfg.Join = nodes[0].Address
+		}
+		nodes = append(nodes, startTestNode(t, cfg))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for {
+		report, err := CrawlRing(ctx, nodes[0].Address)
+		if err == nil && report.Complete && len(report.Members) == n && len(report.Problems) == 0 {
+			return nodes
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("ring of %d nodes did not converge: %v", n, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestClientStoreFile(t *testing.T) {
+	nodes := startTestRing(t, 3, Config{})
+	if err := os.WriteFile(nodes[0].path("file_upload", "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	key, err := ClientStoreFile("a.txt", nodes[0])
+	if err != nil || key != "a.txt" {
+		t.Fatalf("ClientStoreFile = %q, %v", key, err)
+	}
+	// Any node gets it from the node it was stored on
+	if err := ClientGetFile(key, nodes[2]); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(nodes[2].path("file_download", "a.txt"))
+	if err != nil || string(content) != "hello" {
+		t.Fatalf("downloaded %q, %v", content, err)
+	}
+
+	if _, err := ClientStoreFile("missing.txt", nodes[0]); err == nil {
+		t.Fatal("ClientStoreFile of a missing upload succeeded")
+	}
+	if _, err := ClientStoreFile("../a.txt", nodes[0]); err == nil {
+		t.Fatal("ClientStoreFile of an invalid name succeeded")
+	}
+}
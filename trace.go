@@ -0,0 +1,627 @@
+package chord
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*------------------------------------------------------------*/
+/*                   Distributed Tracing Below                */
+/*------------------------------------------------------------*/
+
+// SpanContext identifies a span across processes, it is sent in every RPC envelope
+type SpanContext struct {
+	TraceId string `json:"traceId"` // 16 bytes, hex encoded
+	SpanId  string `json:"spanId"`  // 8 bytes, hex encoded
+}
+
+// Kinds of Span, numbered as in OTLP
+const (
+	SpanKindInternal = 1 // A local step, e.g. a lookup or a stabilization run
+	SpanKindServer   = 2 // A served RPC request
+	SpanKindClient   = 3 // An RPC call made by ChordCall
+)
+
+/*
+* @description: A finished unit of work. Spans of one lookup or stabilization
+*				run share a TraceId; ParentId links a span to the span that
+*				caused it, also across nodes.
+ */
+type Span struct {
+	TraceId  string
+	SpanId   string
+	ParentId string `json:",omitempty"`
+	Name     string // Method name for RPC spans, "lookup", "stabilize", ... otherwise
+	Kind     int
+	Node     string      `json:",omitempty"` // Name of the node doing the work
+	Peer     NodeAddress `json:",omitempty"` // Remote node of an RPC span
+	Start    time.Time
+	End      time.Time
+	Error    string            `json:",omitempty"`
+	Attrs    map[string]string `json:",omitempty"`
+}
+
+func (s *Span) Context() SpanContext {
+	return SpanContext{TraceId: s.TraceId, SpanId: s.SpanId}
+}
+
+// SetAttr records an attribute, nil spans (tracing disabled) ignore it
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	if s.Attrs == nil {
+		s.Attrs = make(map[string]string)
+	}
+	s.Attrs[key] = fmt.Sprint(value)
+}
+
+// Finish ends the span and hands it to the exporter, nil spans ignore it
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	if err != nil {
+		s.Error = err.Error()
+	}
+	exporter := currentExporter()
+	if exporter != nil {
+		exporter.ExportSpan(*s)
+	}
+}
+
+// SpanExporter receives every finished span
+type SpanExporter interface {
+	ExportSpan(span Span)
+	Close() error
+}
+
+/*
+* Every node of a process may bring its own exporter, they are stacked so the
+* node stopping first does not disable the tracing of the others.
+ */
+var (
+	exporterMutex sync.RWMutex
+	spanExporters []SpanExporter // The last one is used, none disables tracing
+)
+
+// SetSpanExporter enables tracing with exporter in place of all others, nil disables it
+func SetSpanExporter(exporter SpanExporter) {
+	exporterMutex.Lock()
+	spanExporters = nil
+	if exporter != nil {
+		spanExporters = append(spanExporters, exporter)
+	}
+	exporterMutex.Unlock()
+}
+
+// installSpanExporter makes exporter the current one until it is removed
+func installSpanExporter(exporter SpanExporter) {
+	exporterMutex.Lock()
+	spanExporters = append(spanExporters, exporter)
+	exporterMutex.Unlock()
+}
+
+// removeSpanExporter removes exporter, the one installed before it is used again
+func removeSpanExporter(exporter SpanExporter) {
+	exporterMutex.Lock()
+	defer exporterMutex.Unlock()
+	for i := len(spanExporters) - 1; i >= 0; i-- {
+		if spanExporters[i] == exporter {
+			spanExporters = append(spanExporters[:i:i], spanExporters[i+1:]...)
+			return
+		}
+	}
+}
+
+func currentExporter() SpanExporter {
+	exporterMutex.RLock()
+	defer exporterMutex.RUnlock()
+	if len(spanExporters) == 0 {
+		return nil
+	}
+	return spanExporters[len(spanExporters)-1]
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type spanKey struct{}
+type remoteSpanKey struct{}
+
+// ContextWithRemoteSpan makes the spans started from ctx children of a span of another process
+func ContextWithRemoteSpan(ctx context.Context, parent SpanContext) context.Context {
+	return context.WithValue(ctx, remoteSpanKey{}, parent)
+}
+
+// SpanFromContext returns the span started last in ctx, nil if none
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+/*
+* @description: Start a span as child of the span in ctx, or of the remote
+*				span set with ContextWithRemoteSpan, or as root of a new trace
+* @param: 		node: name of the working node, "" to inherit it from the parent
+* @return: 		ctx carrying the span and the span, nil when tracing is disabled
+ */
+func StartSpan(ctx context.Context, name string, kind int, node string) (context.Context, *Span) {
+	if currentExporter() == nil {
+		return ctx, nil
+	}
+	span := &Span{SpanId: randomHex(8), Name: name, Kind: kind, Node: node, Start: time.Now()}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.TraceId = parent.TraceId
+		span.ParentId = parent.SpanId
+		if span.Node == "" {
+			span.Node = parent.Node
+		}
+	} else if remote, ok := ctx.Value(remoteSpanKey{}).(SpanContext); ok && remote.TraceId != "" {
+		span.TraceId = remote.TraceId
+		span.ParentId = remote.SpanId
+	} else {
+		span.TraceId = randomHex(16)
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+/*
+* net/rpc does not hand a context to the RPC methods. The server codec records
+* the span of every request under its decoded argument, which is the very value
+* a method with a pointer argument (e.g. FindSuccessorRPC's *big.Int) receives,
+* so such methods can continue the trace with requestContext.
+ */
+var requestSpans sync.Map // argument pointer -> *Span
+
+// requestContext returns a context carrying the span of the request whose argument is arg
+func requestContext(arg interface{}) context.Context {
+	ctx := context.Background()
+	if span, ok := requestSpans.Load(arg); ok {
+		ctx = context.WithValue(ctx, spanKey{}, span.(*Span))
+	}
+	return ctx
+}
+
+// traceFields adds the trace of ctx to log fields, so log lines can be matched with spans
+func traceFields(ctx context.Context, fields ...Field) []Field {
+	if span := SpanFromContext(ctx); span != nil {
+		fields = append(fields, F("trace", span.TraceId), F("span", span.SpanId))
+	}
+	return fields
+}
+
+/*------------------------------------------------------------*/
+/*                        Exporters                           */
+/*------------------------------------------------------------*/
+
+// FileSpanExporter appends spans to a file, one JSON object per line
+type FileSpanExporter struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func NewFileSpanExporter(path string) (*FileSpanExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSpanExporter{file: file}, nil
+}
+
+func (e *FileSpanExporter) ExportSpan(span Span) {
+	data, err := json.Marshal(span)
+	if err != nil {
+		return
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.file != nil {
+		e.file.Write(append(data, '\n'))
+	}
+}
+
+func (e *FileSpanExporter) Close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.file == nil {
+		return nil
+	}
+	err := e.file.Close()
+	e.file = nil
+	return err
+}
+
+// ReadSpans reads a file written by FileSpanExporter, malformed lines are skipped
+func ReadSpans(path string) ([]Span, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var spans []Span
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var span Span
+		if json.Unmarshal(scanner.Bytes(), &span) == nil {
+			spans = append(spans, span)
+		}
+	}
+	return spans, scanner.Err()
+}
+
+/*
+* @description: Sends spans in batches to an OTLP/HTTP collector as JSON
+*				(POST <url>, e.g. http://localhost:4318/v1/traces). Spans are
+*				buffered and dropped, not blocked on, when the collector is slow.
+ */
+type CollectorSpanExporter struct {
+	url     string
+	service string
+	spans   chan Span
+	done    chan struct{}
+	client  *http.Client
+	mutex   sync.RWMutex // Guards closed against ExportSpan after Close
+	closed  bool
+}
+
+const (
+	collectorBatchSize = 128
+	collectorBuffer    = 4096
+	collectorInterval  = time.Second
+)
+
+// NewCollectorSpanExporter exports to url, service is reported as the service.name resource attribute
+func NewCollectorSpanExporter(url string, service string) *CollectorSpanExporter {
+	e := &CollectorSpanExporter{
+		url:     url,
+		service: service,
+		spans:   make(chan Span, collectorBuffer),
+		done:    make(chan struct{}),
+		client:  &http.Client{Timeout: 5 * time.Second},
+	}
+	go e.run()
+	return e
+}
+
+func (e *CollectorSpanExporter) ExportSpan(span Span) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.spans <- span:
+	default:
+		defaultLogger.Debug("Span dropped, collector is too slow", F("span", span.SpanId))
+	}
+}
+
+func (e *CollectorSpanExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(collectorInterval)
+	defer ticker.Stop()
+	var batch []Span
+	for {
+		select {
+		case span, ok := <-e.spans:
+			if !ok {
+				e.send(batch)
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= collectorBatchSize {
+				e.send(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			e.send(batch)
+			batch = nil
+		}
+	}
+}
+
+func (e *CollectorSpanExporter) send(batch []Span) {
+	if len(batch) == 0 {
+		return
+	}
+	data, err := json.Marshal(encodeOTLP(e.service, batch))
+	if err != nil {
+		return
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		defaultLogger.Warn("Export spans failed", F("collector", e.url), Err(err))
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		defaultLogger.Warn("Export spans failed", F("collector", e.url), F("status", resp.Status))
+	}
+}
+
+// Close sends the buffered spans and stops the exporter
+func (e *CollectorSpanExporter) Close() error {
+	e.mutex.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.spans)
+	}
+	e.mutex.Unlock()
+	<-e.done
+	return nil
+}
+
+/*------------------------------------------------------------*/
+/*                   OTLP/JSON Encoding                       */
+/*------------------------------------------------------------*/
+
+// The subset of the OTLP/JSON trace request used by CollectorSpanExporter
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` // 1 ok, 2 error
+	Message string `json:"message,omitempty"`
+}
+
+func encodeOTLP(service string, spans []Span) otlpRequest {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceId:           span.TraceId,
+			SpanId:            span.SpanId,
+			ParentSpanId:      span.ParentId,
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Status:            otlpStatus{Code: 1},
+		}
+		if span.Error != "" {
+			s.Status = otlpStatus{Code: 2, Message: span.Error}
+		}
+		attrs := map[string]string{}
+		for key, value := range span.Attrs {
+			attrs[key] = value
+		}
+		if span.Node != "" {
+			attrs["chord.node"] = span.Node
+		}
+		if span.Peer != "" {
+			attrs["chord.peer"] = string(span.Peer)
+		}
+		keys := make([]string, 0, len(attrs))
+		for key := range attrs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s.Attributes = append(s.Attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: attrs[key]}})
+		}
+		encoded = append(encoded, s)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: service}}}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "chord"}, Spans: encoded}},
+	}}}
+}
+
+// decodeOTLP is the inverse of encodeOTLP
+func decodeOTLP(request otlpRequest) []Span {
+	var spans []Span
+	for _, resource := range request.ResourceSpans {
+		for _, scope := range resource.ScopeSpans {
+			for _, s := range scope.Spans {
+				span := Span{TraceId: s.TraceId, SpanId: s.SpanId, ParentId: s.ParentSpanId, Name: s.Name, Kind: s.Kind}
+				if nanos, err := strconv.ParseInt(s.StartTimeUnixNano, 10, 64); err == nil {
+					span.Start = time.Unix(0, nanos)
+				}
+				if nanos, err := strconv.ParseInt(s.EndTimeUnixNano, 10, 64); err == nil {
+					span.End = time.Unix(0, nanos)
+				}
+				if s.Status.Code == 2 {
+					span.Error = s.Status.Message
+				}
+				for _, attr := range s.Attributes {
+					switch attr.Key {
+					case "chord.node":
+						span.Node = attr.Value.StringValue
+					case "chord.peer":
+						span.Peer = NodeAddress(attr.Value.StringValue)
+					default:
+						span.SetAttr(attr.Key, attr.Value.StringValue)
+					}
+				}
+				spans = append(spans, span)
+			}
+		}
+	}
+	return spans
+}
+
+/*
+* @description: Stand-in for an OTLP collector: accepts POST requests sent by
+*				CollectorSpanExporter and passes the spans to exporter
+ */
+func CollectorHandler(exporter SpanExporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeMethodNotAllowed(w, http.MethodPost)
+			return
+		}
+		var request otlpRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		for _, span := range decodeOTLP(request) {
+			exporter.ExportSpan(span)
+		}
+		writeJSON(w, http.StatusOK, struct{}{})
+	})
+}
+
+/*------------------------------------------------------------*/
+/*                      Trace Rendering                       */
+/*------------------------------------------------------------*/
+
+var spanKindNames = map[int]string{SpanKindInternal: "internal", SpanKindServer: "server", SpanKindClient: "client"}
+
+/*
+* @description: Render the spans of one trace as an indented tree, children
+*				sorted by start time, e.g.
+*				lookup [n1] 2.1ms
+*				  FindSuccessorRPC client [n1] -> localhost:8001 1.9ms
+*				    FindSuccessorRPC server [n2] 1.5ms
+* @return: 		the tree, or an error if no span has the trace id
+ */
+func TraceTree(spans []Span, traceId string) (string, error) {
+	children := make(map[string][]Span)
+	known := make(map[string]bool)
+	var trace []Span
+	for _, span := range spans {
+		if span.TraceId == traceId {
+			trace = append(trace, span)
+			known[span.SpanId] = true
+		}
+	}
+	if len(trace) == 0 {
+		return "", errors.New("no spans for trace " + traceId)
+	}
+	sort.Slice(trace, func(i, j int) bool { return trace[i].Start.Before(trace[j].Start) })
+	var roots []Span
+	for _, span := range trace {
+		if span.ParentId == "" || !known[span.ParentId] {
+			// Parents that were not exported (e.g. by a node without tracing) make their children roots
+			roots = append(roots, span)
+		} else {
+			children[span.ParentId] = append(children[span.ParentId], span)
+		}
+	}
+	var b strings.Builder
+	var render func(span Span, depth int)
+	render = func(span Span, depth int) {
+		fmt.Fprintf(&b, "%s%s", strings.Repeat("  ", depth), span.Name)
+		if span.Kind != SpanKindInternal {
+			fmt.Fprintf(&b, " %s", spanKindNames[span.Kind])
+		}
+		if span.Node != "" {
+			fmt.Fprintf(&b, " [%s]", span.Node)
+		}
+		if span.Peer != "" {
+			fmt.Fprintf(&b, " -> %s", span.Peer)
+		}
+		fmt.Fprintf(&b, " %s", span.End.Sub(span.Start).Round(time.Microsecond))
+		if span.Error != "" {
+			fmt.Fprintf(&b, " error: %s", span.Error)
+		}
+		b.WriteString("\n")
+		for _, child := range children[span.SpanId] {
+			render(child, depth+1)
+		}
+	}
+	for _, root := range roots {
+		render(root, 0)
+	}
+	return b.String(), nil
+}
+
+// TraceSummary is one line of ListTraces
+type TraceSummary struct {
+	TraceId string
+	Root    string // Name of the earliest span
+	Node    string
+	Start   time.Time
+	Spans   int
+	Errors  int
+}
+
+// ListTraces summarizes every trace found in spans, oldest first
+func ListTraces(spans []Span) []TraceSummary {
+	byTrace := make(map[string]*TraceSummary)
+	var order []*TraceSummary
+	for _, span := range spans {
+		summary, ok := byTrace[span.TraceId]
+		if !ok {
+			summary = &TraceSummary{TraceId: span.TraceId, Root: span.Name, Node: span.Node, Start: span.Start}
+			byTrace[span.TraceId] = summary
+			order = append(order, summary)
+		}
+		if span.Start.Before(summary.Start) {
+			summary.Root, summary.Node, summary.Start = span.Name, span.Node, span.Start
+		}
+		summary.Spans++
+		if span.Error != "" {
+			summary.Errors++
+		}
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i].Start.Before(order[j].Start) })
+	summaries := make([]TraceSummary, len(order))
+	for i, summary := range order {
+		summaries[i] = *summary
+	}
+	return summaries
+}
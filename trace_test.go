@@ -0,0 +1,46 @@
+package chord
+
+import (
+	"context"
+	"testing"
+)
+
+// recordingExporter keeps the spans it receives
+type recordingExporter struct{ spans chan Span }
+
+func (r recordingExporter) ExportSpan(span Span) { r.spans <- span }
+func (r recordingExporter) Close() error         { return nil }
+
+func TestSpanExporterOfEachNode(t *testing.T) {
+	defer SetSpanExporter(nil)
+	first := recordingExporter{make(chan Span, 1)}
+	second := recordingExporter{make(chan Span, 1)}
+	installSpanExporter(first)
+	installSpanExporter(second)
+
+	// The node stopping first only removes its own exporter
+	removeSpanExporter(first)
+	_, span := StartSpan(context.Background(), "test", SpanKindInternal, "node")
+	span.Finish(nil)
+	if len(second.spans) != 1 || len(first.spans) != 0 {
+		t.Fatalf("spans exported to %d and %d, want only to the remaining exporter", len(first.spans), len(second.spans))
+	}
+	removeSpanExporter(second)
+	if currentExporter() != nil {
+		t.Fatal("tracing enabled with no exporter installed")
+	}
+}
+
+func TestQuitKeepsTracingOfOtherNodes(t *testing.T) {
+	defer SetSpanExporter(nil)
+	a := startTestNode(t, Config{TraceFile: t.TempDir() + "/a.jsonl"})
+	b := startTestNode(t, Config{TraceFile: t.TempDir() + "/b.jsonl"})
+	a.Quit()
+	if currentExporter() != b.traceExporter {
+		t.Fatal("quitting a node changed the exporter of the other node")
+	}
+	b.Quit()
+	if currentExporter() != nil {
+		t.Fatal("exporter still installed after every node quit")
+	}
+}
@@ -0,0 +1,67 @@
+package chord
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+/*------------------------------------------------------------*/
+/*                       Transport Below                      */
+/*------------------------------------------------------------*/
+
+/*
+* @description: Transport carries the calls made by ChordCall and gives the
+*				nodes the listeners they are served on. The default sends the
+*				calls over TCP through the connection pool to the listener of the
+*				target process, where HandleConnection hands them through the
+*				codecs of the server (limits, authorization, metrics) to the RPC
+*				server of the node. SimNetwork does the same over in-memory
+*				connections.
+ */
+type Transport interface {
+	// Call invokes serviceMethod ("Node.<Method>" or "Node/<index>.<Method>") in the process at hostPort
+	Call(ctx context.Context, hostPort string, serviceMethod string, request interface{}, reply interface{}) error
+
+	// Listen returns the listener the process at hostPort ("IP:Port") is served on
+	Listen(ctx context.Context, hostPort string) (net.Listener, error)
+}
+
+var (
+	transportMutex sync.RWMutex
+	transport      Transport = pool
+)
+
+// SetTransport replaces the transport of every ChordCall in the process, nil restores TCP
+func SetTransport(t Transport) {
+	if t == nil {
+		t = pool
+	}
+	transportMutex.Lock()
+	transport = t
+	transportMutex.Unlock()
+}
+
+func currentTransport() Transport {
+	transportMutex.RLock()
+	defer transportMutex.RUnlock()
+	return transport
+}
+
+// nodeKey is the context key of the local node making the calls, see contextWithNode
+type nodeKey struct{}
+
+/*
+* @description: Mark the calls made with ctx as calls of node: they name it as
+*				the caller and are dialed in its wire format. Calls without a node
+*				are client calls and use the settings of the process.
+ */
+func contextWithNode(ctx context.Context, node *Node) context.Context {
+	return context.WithValue(ctx, nodeKey{}, node)
+}
+
+// callingNode is the node making the calls of ctx, nil for a client
+func callingNode(ctx context.Context) *Node {
+	node, _ := ctx.Value(nodeKey{}).(*Node)
+	return node
+}
@@ -0,0 +1,78 @@
+package chord
+
+import (
+	"strconv"
+)
+
+/*------------------------------------------------------------*/
+/*                    Virtual Nodes Below                     */
+/*------------------------------------------------------------*/
+
+// Maximum value of --vnodes, the identifier space only has 2^m positions
+const maxVirtualNodes = 16
+
+/*
+* @description: Create the index-th virtual node hosted by node. A virtual node
+*				has its own name ("<Name>#<index>"), identifier, finger table and
+*				successor list, so it takes its own position on the ring. It is
+*				served by the listener of node under the RPC service "Node/<index>",
+*				shares the keys and metadata log of node and keeps its objects in
+*				"<node folder>/vnodes/<index>".
+* @param: 		index: 1 .. cfg.VNodes - 1, index 0 is node itself
+* @return: 		the virtual node, not registered and not part of a ring yet
+ */
+func (node *Node) newVirtualNode(index int, cfg Config) (*Node, error) {
+	suffix := strconv.Itoa(index)
+	vnode := &Node{}
+	vnode.Name = node.Name + "#" + suffix
+	vnode.Logger = cfg.Logger.With(F(FieldNode, vnode.Name))
+	vnode.Address = node.Address + NodeAddress("/"+suffix)
+	vnode.Identifier = StrHash(vnode.Name)
+	vnode.Identifier.Mod(vnode.Identifier, hashMod)
+	vnode.DataDir = node.DataDir
+	vnode.FingerTable = make([]fingerEntry, fingerTableSize+1)
+	vnode.Successors = make([]NodeAddress, len(node.Successors))
+	vnode.PrivateKey = node.PrivateKey
+	vnode.PublicKey = node.PublicKey
+	vnode.EncryptFlag = node.EncryptFlag
+	vnode.StoreMode = node.StoreMode
+	vnode.features = node.features
+	vnode.hellos = node.hellos
+	vnode.wire = node.wire
+	vnode.secrets = node.secrets
+	vnode.nonces = node.nonces
+	vnode.InitFingerTable()
+	vnode.InitSuccessors()
+
+	var err error
+	vnode.Bucket, vnode.Backup, err = newVirtualStores(cfg.Storage, node.path("vnodes", suffix), node.meta, suffix)
+	if err != nil {
+		return nil, err
+	}
+	return vnode, nil
+}
+
+/*
+* @description: Create the virtual nodes requested by cfg.VNodes and warn about
+*				identifiers that collide with another node of the process
+ */
+func (node *Node) createVirtualNodes(cfg Config) error {
+	used := map[string]string{node.Identifier.String(): node.Name}
+	for i := 1; i < cfg.VNodes; i++ {
+		vnode, err := node.newVirtualNode(i, cfg)
+		if err != nil {
+			return err
+		}
+		if other, ok := used[vnode.Identifier.String()]; ok {
+			node.logger().Warn("Virtual node has the same identifier as another node", F("vnode", vnode.Name), F("id", vnode.Identifier), F("other", other))
+		}
+		used[vnode.Identifier.String()] = vnode.Name
+		node.VNodes = append(node.VNodes, vnode)
+	}
+	return nil
+}
+
+// LocalNodes returns node followed by its virtual nodes
+func (node *Node) LocalNodes() []*Node {
+	return append([]*Node{node}, node.VNodes...)
+}
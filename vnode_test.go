@@ -0,0 +1,108 @@
+package chord
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSplitAddress(t *testing.T) {
+	cases := []struct {
+		addr              NodeAddress
+		hostPort, service string
+	}{
+		{"127.0.0.1:4000", "127.0.0.1:4000", "Node"},
+		{"127.0.0.1:4000/2", "127.0.0.1:4000", "Node/2"},
+	}
+	for _, c := range cases {
+		if hostPort, service := splitAddress(c.addr); hostPort != c.hostPort || service != c.service {
+			t.Errorf("splitAddress(%s) = %s, %s", c.addr, hostPort, service)
+		}
+	}
+}
+
+// vnodeName is a node name whose virtual nodes all take different identifiers
+func vnodeName(t *testing.T, vnodes int) string {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		name := fmt.Sprintf("host-%d", i)
+		used := map[string]bool{Identifier(name).String(): true}
+		for v := 1; v < vnodes; v++ {
+			used[Identifier(fmt.Sprintf("%s#%d", name, v)).String()] = true
+		}
+		if len(used) == vnodes {
+			return name
+		}
+	}
+	t.Fatal("no name found for the virtual nodes")
+	return ""
+}
+
+func TestVirtualNodes(t *testing.T) {
+	cfg := Config{Name: vnodeName(t, 3), VNodes: 3, Storage: "file", Stabilize: 20 * time.Millisecond, FixFingers: 20 * time.Millisecond, CheckPredecessor: 20 * time.Millisecond}
+	node := startTestNode(t, cfg)
+	local := node.LocalNodes()
+	if len(local) != 3 {
+		t.Fatalf("%d local nodes, want 3", len(local))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for i, vnode := range local[1:] {
+		if want := fmt.Sprintf("%s#%d", node.Name, i+1); vnode.Name != want {
+			t.Errorf("virtual node name %s, want %s", vnode.Name, want)
+		}
+		if want := NodeAddress(fmt.Sprintf("%s/%d", node.Address, i+1)); vnode.Address != want {
+			t.Errorf("virtual node address %s, want %s", vnode.Address, want)
+		}
+		// Node.<Method> calls reach the virtual node through the listener of the process
+		if name, err := crawlName(ctx, vnode.Address); err != nil || name != vnode.Name {
+			t.Errorf("GetNameRPC of %s = %s, %v", vnode.Address, name, err)
+		}
+	}
+
+	// The virtual nodes join the ring of their host
+	for {
+		report, err := CrawlRing(ctx, node.Address)
+		if err == nil && report.Complete && len(report.Members) == 3 && len(report.Problems) == 0 {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("ring of the virtual nodes did not converge: %+v, %v", report, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Every object is kept by the local node owning its key
+	client := NewClient(node)
+	held := make(map[NodeAddress]int)
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if err := client.Put(ctx, key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+		owner, err := client.Lookup(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range local {
+			if n.Address == owner && !n.Bucket.Contains(key) {
+				t.Errorf("%s not stored by its owner %s", key, owner)
+			}
+		}
+		held[owner]++
+	}
+	for i, n := range local[1:] {
+		if held[n.Address] == 0 {
+			continue
+		}
+		files, err := os.ReadDir(node.path("vnodes", fmt.Sprint(i+1), "chord_storage"))
+		if err != nil || len(files) != held[n.Address] {
+			t.Errorf("folder of %s holds %d files, want %d: %v", n.Name, len(files), held[n.Address], err)
+		}
+	}
+	if len(held) < 2 {
+		t.Fatalf("keys held by %v, want them spread over the virtual nodes", held)
+	}
+}
@@ -0,0 +1,316 @@
+package chord
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/rpc"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*------------------------------------------------------------*/
+/*                    Wire Formats Below                      */
+/*------------------------------------------------------------*/
+
+/*
+* A connection opens with a handshake naming the wire version and format,
+*	client: "CHORD/1 gob\n"    server: "OK\n" or "ERR <reason>\n"
+* after which both sides speak the codec of the format (see codec.go). A
+* connection starting with "{" skips the handshake and speaks JSON, as
+* clients built before the handshake do.
+ */
+
+// Wire formats of the calls between processes
+const (
+	WireJSON = "json" // JSON-RPC 1.0 with the trace member, readable and the default
+	WireGob  = "gob"  // Binary gob framing of net/rpc, file content is not base64 encoded
+)
+
+// WireVersion is the version of the handshake, the framing and wireSchema
+const WireVersion = 1
+
+// How long either side waits for the handshake of the other when the context has no deadline
+const handshakeTimeout = 5 * time.Second
+
+// How long a refused connection is kept to tell the client why, see refuseHandshake
+const refuseTimeout = time.Second
+
+// ErrWire is wrapped by the errors of a refused handshake
+var ErrWire = errors.New("wire handshake refused")
+
+var (
+	wireMutex  sync.RWMutex
+	wireFormat = WireJSON
+)
+
+// validWireFormat reports whether format is WireJSON or WireGob
+func validWireFormat(format string) bool {
+	return format == WireJSON || format == WireGob
+}
+
+/*
+* @description: Set the format of the connections clients of the process dial
+*				from now on; a node dials in its own format, see Config.Wire.
+*				Servers accept every format, so the nodes of a ring can switch
+*				format one after the other.
+ */
+func SetWireFormat(format string) error {
+	if !validWireFormat(format) {
+		return fmt.Errorf("unknown wire format %q", format)
+	}
+	wireMutex.Lock()
+	wireFormat = format
+	wireMutex.Unlock()
+	return nil
+}
+
+func currentWireFormat() string {
+	wireMutex.RLock()
+	defer wireMutex.RUnlock()
+	return wireFormat
+}
+
+// callWireFormat is the format of the node making the calls of ctx, that of the process for a client
+func callWireFormat(ctx context.Context) string {
+	if node := callingNode(ctx); node != nil && node.wire != "" {
+		return node.wire
+	}
+	return currentWireFormat()
+}
+
+// handshakeDeadline is the deadline of ctx, or handshakeTimeout from now
+func handshakeDeadline(ctx context.Context) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+	return time.Now().Add(handshakeTimeout)
+}
+
+// readLine reads up to and including '\n' one byte at a time, so nothing after the line is consumed
+func readLine(r io.Reader, max int) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < max {
+		_, err := r.Read(b)
+		if err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", errors.New("handshake line too long")
+}
+
+/*
+* @description: Open a client codec of format on a dialed connection,
+*				performing the handshake
+* @return: 		an error wrapping ErrWire if the server refused the format, or
+*				ErrBusy if it refused the connection
+ */
+func newClientCodec(ctx context.Context, conn net.Conn, format string) (rpc.ClientCodec, error) {
+	conn.SetDeadline(handshakeDeadline(ctx))
+	_, err := fmt.Fprintf(conn, "CHORD/%d %s\n", WireVersion, format)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := readLine(conn, 256)
+	if err != nil {
+		return nil, fmt.Errorf("wire handshake: %w", err)
+	}
+	if reply != "OK" {
+		reason := strings.TrimPrefix(reply, "ERR ")
+		if strings.HasPrefix(reason, ErrBusy.Error()) {
+			return nil, fmt.Errorf("%w%s", ErrBusy, strings.TrimPrefix(reason, ErrBusy.Error()))
+		}
+		return nil, fmt.Errorf("%w: %s", ErrWire, reason)
+	}
+	conn.SetDeadline(time.Time{})
+	if format == WireGob {
+		return newGobClientCodec(conn), nil
+	}
+	return newJSONClientCodec(conn), nil
+}
+
+// bufferedConn reads through the reader the handshake was peeked with
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+/*
+* @description: Read the handshake of an accepted connection and open the
+*				server codec of the format it asks for
+* @return: 		an error if the client is not a Chord client or its version or
+*				format is not supported, the client was told why
+ */
+func newServerCodec(conn net.Conn) (rpc.ServerCodec, string, error) {
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	reader := bufio.NewReader(conn)
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, "", err
+	}
+	conn.SetReadDeadline(time.Time{})
+	buffered := &bufferedConn{Conn: conn, reader: reader}
+	if first[0] == '{' {
+		return newJSONServerCodec(buffered), WireJSON, nil
+	}
+
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	line, err := readLine(reader, 256)
+	if err != nil {
+		return nil, "", fmt.Errorf("wire handshake: %w", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	var version int
+	var format string
+	_, err = fmt.Sscanf(line, "CHORD/%d %s", &version, &format)
+	switch {
+	case err != nil || line != fmt.Sprintf("CHORD/%d %s", version, format):
+		err = fmt.Errorf("malformed handshake %q", line)
+	case version != WireVersion:
+		err = fmt.Errorf("wire version %d is not supported, this node speaks version %d", version, WireVersion)
+	case !validWireFormat(format):
+		err = fmt.Errorf("wire format %q is not supported", format)
+	}
+	if err != nil {
+		fmt.Fprintf(conn, "ERR %s\n", err)
+		return nil, "", err
+	}
+	_, err = io.WriteString(conn, "OK\n")
+	if err != nil {
+		return nil, "", err
+	}
+	if format == WireGob {
+		return newGobServerCodec(buffered), format, nil
+	}
+	return newJSONServerCodec(buffered), format, nil
+}
+
+// refuseHandshake answers the handshake of a client with "ERR <reason>", a client without handshake gets no answer
+func refuseHandshake(conn net.Conn, reason error) {
+	conn.SetDeadline(time.Now().Add(refuseTimeout))
+	reader := bufio.NewReader(conn)
+	first, err := reader.Peek(1)
+	if err != nil || first[0] == '{' {
+		return
+	}
+	_, err = readLine(reader, 256)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(conn, "ERR %s\n", reason)
+}
+
+/*------------------------------------------------------------*/
+/*                      Wire Schema Below                     */
+/*------------------------------------------------------------*/
+
+// wireMethod is the request and reply type of one RPC, as the server method declares them
+type wireMethod struct {
+	Request interface{}
+	Reply   interface{}
+}
+
+/*
+* @description: wireSchema is the schema of WireVersion: every RPC served by
+*				a node with its request and reply type. Changing a type, or
+*				removing or renaming a method, breaks peers of the same version
+*				and needs a new WireVersion; checkWireSchema keeps the table and
+*				the methods of Node in step.
+ */
+var wireSchema = map[string]wireMethod{
+	"FindSuccessorRPC":         {(*big.Int)(nil), (*FindSuccessorRPCReply)(nil)},
+	"GetNameRPC":               {"", (*GetNameRPCReply)(nil)},
+	"GetStateRPC":              {"", (*NodeState)(nil)},
+	"GetStoreModeRPC":          {"", (*GetStoreModeRPCReply)(nil)},
+	"NotifyRPC":                {NodeAddress(""), (*NotifyRPCReply)(nil)},
+	"SetPredecessorRPC":        {NodeAddress(""), (*SetPredecessorRPCReply)(nil)},
+	"GetSuccessorListRPC":      {(*struct{})(nil), (*GetSuccessorListRPCReply)(nil)},
+	"GetPredecessorRPC":        {(*struct{})(nil), (*GetPredecessorRPCReply)(nil)},
+	"DeleteSuccessorBackupRPC": {(*struct{})(nil), (*DeleteSuccessorBackupRPCReply)(nil)},
+	"StoreFileRPC":             {FileRPC{}, (*StoreFileRPCReply)(nil)},
+	"PutFileRPC":               {FileRPC{}, (*StoreFileRPCReply)(nil)},
+	"DeleteFileRPC":            {FileRPC{}, (*DeleteFileRPCReply)(nil)},
+	"DeleteBackupFileRPC":      {FileRPC{}, (*DeleteFileRPCReply)(nil)},
+	"SuccessorStoreFileRPC":    {FileRPC{}, (*SuccessorStoreFileRPCReply)(nil)},
+	"CheckFileExistRPC":        {"", (*CheckFileExistRPCReply)(nil)},
+	"GetFileRPC":               {FileRPC{}, (*FileRPC)(nil)},
+	"GetBackupFileRPC":         {FileRPC{}, (*FileRPC)(nil)},
+	"HelloRPC":                 {Hello{}, (*Hello)(nil)},
+	"ConfirmHelloRPC":          {ConfirmHelloRPCArgs{}, (*ConfirmHelloRPCReply)(nil)},
+}
+
+// indirect is t without pointers
+func indirect(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+/*
+* @description: Check a call against wireSchema before it is sent. JSON
+*				tolerates a reply of the wrong type, gob does not, so a caller
+*				decoding into the wrong type is caught whatever the format.
+*				Pointers are ignored: a request may be sent by value or pointer.
+ */
+func checkWireCall(method string, request interface{}, reply interface{}) error {
+	name := method[strings.LastIndex(method, ".")+1:]
+	schema, ok := wireSchema[name]
+	if !ok {
+		return fmt.Errorf("RPC %s is not in the wire schema", name)
+	}
+	if indirect(reflect.TypeOf(request)) != indirect(reflect.TypeOf(schema.Request)) {
+		return fmt.Errorf("RPC %s takes %T, not %T", name, schema.Request, request)
+	}
+	if reply != nil && reflect.TypeOf(reply) != reflect.TypeOf(schema.Reply) {
+		return fmt.Errorf("RPC %s replies %T, not %T", name, schema.Reply, reply)
+	}
+	return nil
+}
+
+/*
+* @description: Check that the RPC methods of Node, those net/rpc serves,
+*				are exactly the methods of wireSchema with the same types
+ */
+func checkWireSchema() error {
+	errorType := reflect.TypeOf((*error)(nil)).Elem()
+	nodeType := reflect.TypeOf((*Node)(nil))
+	served := make(map[string]bool)
+	for i := 0; i < nodeType.NumMethod(); i++ {
+		method := nodeType.Method(i)
+		t := method.Type
+		// Shape of a method served by net/rpc: func (node *Node) M(request T, reply *R) error
+		if t.NumIn() != 3 || t.NumOut() != 1 || t.Out(0) != errorType || t.In(2).Kind() != reflect.Pointer {
+			continue
+		}
+		served[method.Name] = true
+		schema, ok := wireSchema[method.Name]
+		if !ok {
+			return fmt.Errorf("RPC %s is not in the wire schema", method.Name)
+		}
+		if t.In(1) != reflect.TypeOf(schema.Request) || t.In(2) != reflect.TypeOf(schema.Reply) {
+			return fmt.Errorf("RPC %s takes (%s, %s), the wire schema says (%T, %T)", method.Name, t.In(1), t.In(2), schema.Request, schema.Reply)
+		}
+	}
+	for name := range wireSchema {
+		if !served[name] {
+			return fmt.Errorf("RPC %s of the wire schema is not served", name)
+		}
+	}
+	return nil
+}
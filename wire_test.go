@@ -0,0 +1,171 @@
+package chord
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"strings"
+	"testing"
+)
+
+// echoService is served by the RPC servers of the wire tests
+type echoService struct{}
+
+func (echoService) Echo(f FileRPC, reply *FileRPC) error {
+	*reply = f
+	return nil
+}
+
+func (echoService) Fail(f FileRPC, reply *FileRPC) error {
+	return fmt.Errorf("%w: %s", ErrNotFound, f.Name)
+}
+
+// serveEcho serves echoService on the server end of a pipe and returns the client end
+func serveEcho(t *testing.T) net.Conn {
+	t.Helper()
+	server := rpc.NewServer()
+	if err := server.RegisterName("Echo", echoService{}); err != nil {
+		t.Fatal(err)
+	}
+	clientConn, serverConn := net.Pipe()
+	go func() {
+		codec, _, err := newServerCodec(serverConn)
+		if err != nil {
+			serverConn.Close()
+			return
+		}
+		server.ServeCodec(codec)
+	}()
+	t.Cleanup(func() { clientConn.Close() })
+	return clientConn
+}
+
+func TestWireRoundTrip(t *testing.T) {
+	content := bytes.Repeat([]byte{0, 1, 2, 255}, 1<<18)
+	for _, format := range []string{WireJSON, WireGob} {
+		t.Run(format, func(t *testing.T) {
+			codec, err := newClientCodec(context.Background(), serveEcho(t), format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := rpc.NewClientWithCodec(codec)
+			defer client.Close()
+
+			sent := FileRPC{Id: big.NewInt(42), Name: "a.txt", Content: content, Checksum: contentChecksum(content)}
+			for _, request := range []interface{}{sent, callParams{Trace: &SpanContext{TraceId: "t", SpanId: "s"}, Params: sent}} {
+				var reply FileRPC
+				if err := client.Call("Echo.Echo", request, &reply); err != nil {
+					t.Fatal(err)
+				}
+				if reply.Id.Cmp(sent.Id) != 0 || reply.Name != sent.Name || !bytes.Equal(reply.Content, content) || reply.Checksum != sent.Checksum {
+					t.Fatalf("echo of %T differs: %s %d bytes", request, reply.Name, len(reply.Content))
+				}
+			}
+			// Errors keep their sentinel across the wire
+			err = remoteError(client.Call("Echo.Fail", FileRPC{Name: "b"}, &FileRPC{}))
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Fail = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestWireCarriesTraceAndCredentials(t *testing.T) {
+	for _, format := range []string{WireJSON, WireGob} {
+		t.Run(format, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			defer serverConn.Close()
+			served := make(chan rpc.ServerCodec, 1)
+			go func() {
+				codec, _, _ := newServerCodec(serverConn)
+				served <- codec
+			}()
+			client, err := newClientCodec(context.Background(), clientConn, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := <-served
+			trace := &SpanContext{TraceId: "0123456789abcdef0123456789abcdef", SpanId: "0123456789abcdef"}
+			auth := &Credentials{Ring: "ring", Time: 1, Nonce: "nonce", Token: "token"}
+			go client.WriteRequest(&rpc.Request{ServiceMethod: "Node.StoreFileRPC", Seq: 7}, callParams{Trace: trace, Auth: auth, Params: FileRPC{Name: "a"}})
+
+			var request rpc.Request
+			if err := server.ReadRequestHeader(&request); err != nil {
+				t.Fatal(err)
+			}
+			if request.ServiceMethod != "Node.StoreFileRPC" {
+				t.Fatalf("method %q", request.ServiceMethod)
+			}
+			if got := server.(interface{ requestTrace() *SpanContext }).requestTrace(); got == nil || *got != *trace {
+				t.Fatalf("trace %+v, want %+v", got, trace)
+			}
+			if got := server.(interface{ requestAuth() *Credentials }).requestAuth(); got == nil || *got != *auth {
+				t.Fatalf("credentials %+v, want %+v", got, auth)
+			}
+			var f FileRPC
+			if err := server.ReadRequestBody(&f); err != nil || f.Name != "a" {
+				t.Fatalf("body %+v, %v", f, err)
+			}
+		})
+	}
+}
+
+func TestJSONWithoutHandshake(t *testing.T) {
+	// Clients built before the handshake speak plain net/rpc/jsonrpc
+	client := jsonrpc.NewClient(serveEcho(t))
+	defer client.Close()
+	var reply FileRPC
+	if err := client.Call("Echo.Echo", FileRPC{Name: "a"}, &reply); err != nil || reply.Name != "a" {
+		t.Fatalf("echo = %+v, %v", reply, err)
+	}
+}
+
+func TestHandshakeRefused(t *testing.T) {
+	for _, format := range []string{"xml", "json extra"} {
+		if _, err := newClientCodec(context.Background(), serveEcho(t), format); !errors.Is(err, ErrWire) {
+			t.Errorf("handshake of format %q = %v, want ErrWire", format, err)
+		}
+	}
+
+	conn := serveEcho(t)
+	go fmt.Fprintf(conn, "CHORD/%d json\n", WireVersion+1)
+	line, err := readLine(conn, 256)
+	if err != nil || !strings.HasPrefix(line, "ERR wire version") {
+		t.Fatalf("handshake of another version = %q, %v", line, err)
+	}
+
+	// A node beyond its connection limit tells the client it is busy
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		refuseHandshake(serverConn, fmt.Errorf("%w: the limit of 1 connections is reached", ErrBusy))
+		serverConn.Close()
+	}()
+	if _, err := newClientCodec(context.Background(), clientConn, WireGob); !errors.Is(err, ErrBusy) {
+		t.Fatalf("handshake with a busy node = %v, want ErrBusy", err)
+	}
+}
+
+func TestWireSchema(t *testing.T) {
+	if err := checkWireSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkWireCall("Node.GetNameRPC", "", &GetNameRPCReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkWireCall("Node/2.StoreFileRPC", &FileRPC{}, &StoreFileRPCReply{}); err != nil {
+		t.Fatalf("request by pointer to a virtual node: %v", err)
+	}
+	if err := checkWireCall("Node.GetNameRPC", "", &GetPredecessorRPCReply{}); err == nil {
+		t.Fatal("a reply of the wrong type passed")
+	}
+	if err := checkWireCall("Node.NoSuchRPC", "", nil); err == nil {
+		t.Fatal("an unknown RPC passed")
+	}
+}